@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
+	"net/smtp"
 	"os"
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
@@ -40,7 +42,7 @@ func main() {
 	}
 	defer tasksClient.Close()
 
-	// Firebase (FCM)
+	// Firebase (FCM, Auth)
 	firebaseApp, err := firebase.NewApp(ctx, nil)
 	if err != nil {
 		log.Fatalf("Failed to create Firebase app: %v", err)
@@ -49,6 +51,15 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create FCM messaging client: %v", err)
 	}
+	authClient, err := firebaseApp.Auth(ctx)
+	if err != nil {
+		log.Fatalf("Failed to create Firebase Auth client: %v", err)
+	}
+	// userAuth additionally identifies the signed-in user from their
+	// Firebase ID token, for endpoints scoped to the caller's own data.
+	userAuth := func(next http.HandlerFunc) http.HandlerFunc {
+		return middleware.APIKeyAuth(middleware.UserAuth(middleware.FirebaseVerifier{Client: authClient}, next))
+	}
 
 	// GCS
 	gcsClient, err := storage.NewClient(ctx)
@@ -64,6 +75,20 @@ func main() {
 		Gen:      &jobs.CloudTTSGenerator{},
 		Storage:  jobs.NewGCSAudioStorage(gcsClient),
 		Notifier: jobs.NewFCMNotifier(messagingClient),
+		Usage:    jobs.NewFirestoreUsageStore(firestoreClient),
+		Events:   jobs.NewFirestoreJobEvents(firestoreClient),
+		Devices:  jobs.NewFirestoreDeviceTokenStore(firestoreClient),
+	}
+	if secret := os.Getenv("WEBHOOK_SECRET"); secret != "" {
+		jobDeps.Webhooks = jobs.NewHTTPWebhookNotifier([]byte(secret), nil)
+	}
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		var auth smtp.Auth
+		if user := os.Getenv("SMTP_USERNAME"); user != "" {
+			host, _, _ := net.SplitHostPort(addr)
+			auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+		}
+		jobDeps.Mailer = jobs.NewSMTPNotifier(addr, os.Getenv("SMTP_FROM"), auth)
 	}
 
 	// Router
@@ -75,11 +100,17 @@ func main() {
 	// Protected endpoints (API key required)
 	mux.HandleFunc("/generateAudio", middleware.APIKeyAuth(handlers.GenerateAudioHandler))
 	mux.HandleFunc("/generateAudioWithTTS", middleware.APIKeyAuth(handlers.GenerateAudioTTSHandler))
+	mux.HandleFunc("/synthesize/stream", middleware.APIKeyAuth(jobDeps.SynthesizeStreamHandler))
+	mux.HandleFunc("/synthesize/session", userAuth(middleware.ConcurrencyLimit(handlers.MaxTTSSessionsPerUser, jobDeps.TTSSessionHandler)))
 
 	// Job endpoints
-	mux.HandleFunc("/jobs", middleware.APIKeyAuth(jobDeps.CreateJobHandler))
+	mux.HandleFunc("/jobs", userAuth(jobDeps.CreateJobHandler))
 	mux.HandleFunc("/jobs/process", middleware.APIKeyAuth(jobDeps.ProcessJobHandler))
 	mux.HandleFunc("/jobs/", middleware.APIKeyAuth(jobDeps.GetJobHandler))
+	mux.HandleFunc("/jobs/{jobId}/audio", userAuth(jobDeps.GetJobAudioHandler))
+	mux.HandleFunc("/jobs/{jobId}/events", middleware.APIKeyAuth(jobDeps.JobEventsHandler))
+	mux.HandleFunc("/usage", userAuth(jobDeps.UsageHandler))
+	mux.HandleFunc("/deviceTokens", userAuth(jobDeps.DeviceTokensHandler))
 
 	// Health check
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -99,8 +130,9 @@ func main() {
 
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key"},
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key", "X-Firebase-Token", "Range", "If-Range", "If-None-Match"},
+		ExposedHeaders:   []string{"Content-Length", "Content-Range", "Accept-Ranges", "ETag", "X-Sample-Rate", "X-Channels", "X-Bits-Per-Sample"},
 		AllowCredentials: false,
 	})
 
@@ -0,0 +1,33 @@
+package config
+
+// Subscription tiers used for storage quotas.
+const (
+	TierFree    = "free"
+	TierPremium = "premium"
+)
+
+// QuotaLimits caps how much generated audio an owner may keep.
+type QuotaLimits struct {
+	MaxBytesStored  int64   `json:"maxBytesStored"`
+	MaxAudioSeconds float64 `json:"maxAudioSeconds"`
+}
+
+// QuotaTiers contains the limits for each subscription tier
+var QuotaTiers = map[string]QuotaLimits{
+	TierFree: {
+		MaxBytesStored:  500 << 20, // 500 MiB ≈ 3h of 24kHz LINEAR16
+		MaxAudioSeconds: 3 * 60 * 60,
+	},
+	TierPremium: {
+		MaxBytesStored:  20 << 30, // 20 GiB
+		MaxAudioSeconds: 100 * 60 * 60,
+	},
+}
+
+// GetQuotaLimits returns the limits for a tier, falling back to the free tier
+func GetQuotaLimits(tier string) QuotaLimits {
+	if limits, ok := QuotaTiers[tier]; ok {
+		return limits
+	}
+	return QuotaTiers[TierFree]
+}
@@ -0,0 +1,34 @@
+package config
+
+import "testing"
+
+func TestGetQuotaLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		tier string
+		want QuotaLimits
+	}{
+		{name: "free tier", tier: TierFree, want: QuotaTiers[TierFree]},
+		{name: "premium tier", tier: TierPremium, want: QuotaTiers[TierPremium]},
+		{name: "empty tier falls back to free", tier: "", want: QuotaTiers[TierFree]},
+		{name: "unknown tier falls back to free", tier: "enterprise", want: QuotaTiers[TierFree]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetQuotaLimits(tt.tier); got != tt.want {
+				t.Errorf("GetQuotaLimits(%q) = %+v, want %+v", tt.tier, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaTiers_PremiumExceedsFree(t *testing.T) {
+	free, premium := QuotaTiers[TierFree], QuotaTiers[TierPremium]
+	if premium.MaxBytesStored <= free.MaxBytesStored {
+		t.Errorf("premium bytes %d should exceed free %d", premium.MaxBytesStored, free.MaxBytesStored)
+	}
+	if premium.MaxAudioSeconds <= free.MaxAudioSeconds {
+		t.Errorf("premium seconds %f should exceed free %f", premium.MaxAudioSeconds, free.MaxAudioSeconds)
+	}
+}
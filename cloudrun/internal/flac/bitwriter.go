@@ -0,0 +1,51 @@
+package flac
+
+// bitWriter packs MSB-first bit fields into a byte slice.
+type bitWriter struct {
+	buf   []byte
+	cur   uint64
+	nbits uint
+}
+
+// write appends the low n bits of v (n <= 32).
+func (b *bitWriter) write(v uint64, n int) {
+	if n == 0 {
+		return
+	}
+	b.cur = b.cur<<uint(n) | v&(1<<uint(n)-1)
+	b.nbits += uint(n)
+	for b.nbits >= 8 {
+		b.nbits -= 8
+		b.buf = append(b.buf, byte(b.cur>>b.nbits))
+	}
+}
+
+// writeSigned appends v as an n-bit two's complement value.
+func (b *bitWriter) writeSigned(v int64, n int) {
+	b.write(uint64(v), n)
+}
+
+// writeRice appends the zigzag-mapped residual r with Rice parameter k.
+func (b *bitWriter) writeRice(r int64, k int) {
+	u := uint64(r<<1) ^ uint64(r>>63)
+	for q := u >> uint(k); q > 0; {
+		n := min(q, 32)
+		b.write(0, int(n))
+		q -= n
+	}
+	b.write(1, 1)
+	b.write(u, k)
+}
+
+// align zero-pads to the next byte boundary.
+func (b *bitWriter) align() {
+	if b.nbits > 0 {
+		b.write(0, int(8-b.nbits))
+	}
+}
+
+func (b *bitWriter) reset() {
+	b.buf = b.buf[:0]
+	b.cur = 0
+	b.nbits = 0
+}
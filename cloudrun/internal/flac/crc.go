@@ -0,0 +1,47 @@
+package flac
+
+var (
+	crc8Table  [256]uint8  // polynomial x^8 + x^2 + x + 1
+	crc16Table [256]uint16 // polynomial x^16 + x^15 + x^2 + 1
+)
+
+func init() {
+	for i := range crc8Table {
+		c := uint8(i)
+		for j := 0; j < 8; j++ {
+			if c&0x80 != 0 {
+				c = c<<1 ^ 0x07
+			} else {
+				c <<= 1
+			}
+		}
+		crc8Table[i] = c
+	}
+	for i := range crc16Table {
+		c := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if c&0x8000 != 0 {
+				c = c<<1 ^ 0x8005
+			} else {
+				c <<= 1
+			}
+		}
+		crc16Table[i] = c
+	}
+}
+
+func crc8(data []byte) uint8 {
+	var c uint8
+	for _, b := range data {
+		c = crc8Table[c^b]
+	}
+	return c
+}
+
+func crc16(data []byte) uint16 {
+	var c uint16
+	for _, b := range data {
+		c = c<<8 ^ crc16Table[byte(c>>8)^b]
+	}
+	return c
+}
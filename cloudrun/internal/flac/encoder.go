@@ -0,0 +1,417 @@
+// Package flac implements a streaming FLAC encoder in pure Go.
+//
+// Each channel of a block is coded with the best of the CONSTANT, VERBATIM
+// and FIXED (order 0-4) subframe types using partitioned Rice residuals.
+// That is a fraction of what a full LPC search gains on speech while keeping
+// the encoder small.
+//
+// Audio frames are written to the underlying writer as soon as a block is
+// full. The stream header (the "fLaC" marker, STREAMINFO and Vorbis
+// comments) depends on the whole stream — total samples, MD5, frame sizes —
+// so it is only available from Header after Close and must be placed in
+// front of the frames by the caller.
+package flac
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+)
+
+// BlockSize is the number of samples per channel in every frame but the last.
+const BlockSize = 4096
+
+const (
+	maxFixedOrder     = 4
+	maxPartitionOrder = 8
+	maxRiceParam      = 14 // 15 is the escape code with 4-bit parameters
+)
+
+// Encoder encodes interleaved signed little-endian PCM into FLAC frames.
+type Encoder struct {
+	w             io.Writer
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+	comments      []string
+
+	block              [][]int64 // per-channel samples of the pending block
+	partial            []byte    // bytes of an incomplete sample frame
+	md5                hash.Hash
+	frameNo            uint64
+	total              uint64
+	minFrame, maxFrame int
+	frame              bitWriter
+	resid              [maxFixedOrder + 1][]int64
+	err                error
+	closed             bool
+}
+
+// NewEncoder returns an Encoder writing audio frames to w. bitsPerSample
+// must be 16 or 24. comments are Vorbis comment fields in "NAME=value" form,
+// e.g. "TITLE=Chapter 1".
+func NewEncoder(w io.Writer, sampleRate, channels, bitsPerSample int, comments ...string) (*Encoder, error) {
+	if sampleRate <= 0 || sampleRate >= 1<<20 {
+		return nil, fmt.Errorf("flac: unsupported sample rate %d", sampleRate)
+	}
+	if channels < 1 || channels > 8 {
+		return nil, fmt.Errorf("flac: unsupported channel count %d", channels)
+	}
+	if bitsPerSample != 16 && bitsPerSample != 24 {
+		return nil, fmt.Errorf("flac: unsupported bit depth %d", bitsPerSample)
+	}
+	e := &Encoder{
+		w:             w,
+		sampleRate:    sampleRate,
+		channels:      channels,
+		bitsPerSample: bitsPerSample,
+		comments:      comments,
+		block:         make([][]int64, channels),
+		md5:           md5.New(),
+	}
+	for ch := range e.block {
+		e.block[ch] = make([]int64, 0, BlockSize)
+	}
+	for order := range e.resid {
+		e.resid[order] = make([]int64, BlockSize)
+	}
+	return e, nil
+}
+
+// Write consumes interleaved PCM and emits a frame for every full block.
+func (e *Encoder) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	if e.closed {
+		return 0, errors.New("flac: write after close")
+	}
+	n := len(p)
+
+	bytesPerSample := e.bitsPerSample / 8
+	frameBytes := bytesPerSample * e.channels
+	if len(e.partial) > 0 {
+		need := frameBytes - len(e.partial)
+		if len(p) < need {
+			e.partial = append(e.partial, p...)
+			return n, nil
+		}
+		e.partial = append(e.partial, p[:need]...)
+		if err := e.appendSamples(e.partial); err != nil {
+			return n, err
+		}
+		e.partial = e.partial[:0]
+		p = p[need:]
+	}
+	whole := len(p) - len(p)%frameBytes
+	if err := e.appendSamples(p[:whole]); err != nil {
+		return n, err
+	}
+	e.partial = append(e.partial, p[whole:]...)
+	return n, nil
+}
+
+// Close writes the final (possibly short) frame. A trailing incomplete
+// sample frame is discarded.
+func (e *Encoder) Close() error {
+	if e.closed {
+		return e.err
+	}
+	e.closed = true
+	if e.err == nil && len(e.block[0]) > 0 {
+		e.err = e.writeFrame()
+	}
+	return e.err
+}
+
+// Header returns the stream header to place before the frames: the "fLaC"
+// marker, STREAMINFO and a Vorbis comment block. It is only complete after
+// Close.
+func (e *Encoder) Header() []byte {
+	h := []byte("fLaC")
+
+	info := make([]byte, 34)
+	binary.BigEndian.PutUint16(info[0:2], BlockSize)
+	binary.BigEndian.PutUint16(info[2:4], BlockSize)
+	putUint24(info[4:7], uint32(e.minFrame))
+	putUint24(info[7:10], uint32(e.maxFrame))
+	packed := uint64(e.sampleRate)<<44 |
+		uint64(e.channels-1)<<41 |
+		uint64(e.bitsPerSample-1)<<36 |
+		e.total&(1<<36-1)
+	binary.BigEndian.PutUint64(info[10:18], packed)
+	copy(info[18:34], e.md5.Sum(nil))
+	h = appendBlock(h, 0, false, info)
+
+	vendor := "voiceyourtext"
+	vc := binary.LittleEndian.AppendUint32(nil, uint32(len(vendor)))
+	vc = append(vc, vendor...)
+	vc = binary.LittleEndian.AppendUint32(vc, uint32(len(e.comments)))
+	for _, c := range e.comments {
+		vc = binary.LittleEndian.AppendUint32(vc, uint32(len(c)))
+		vc = append(vc, c...)
+	}
+	return appendBlock(h, 4, true, vc)
+}
+
+func appendBlock(h []byte, blockType byte, last bool, body []byte) []byte {
+	if last {
+		blockType |= 0x80
+	}
+	h = append(h, blockType, 0, 0, 0)
+	putUint24(h[len(h)-3:], uint32(len(body)))
+	return append(h, body...)
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v>>16), byte(v>>8), byte(v)
+}
+
+func (e *Encoder) appendSamples(p []byte) error {
+	e.md5.Write(p)
+	bytesPerSample := e.bitsPerSample / 8
+	ch := 0
+	for i := 0; i+bytesPerSample <= len(p); i += bytesPerSample {
+		var s int64
+		if bytesPerSample == 2 {
+			s = int64(int16(binary.LittleEndian.Uint16(p[i:])))
+		} else {
+			s = int64(int32(uint32(p[i])<<8|uint32(p[i+1])<<16|uint32(p[i+2])<<24) >> 8)
+		}
+		e.block[ch] = append(e.block[ch], s)
+		if ch++; ch == e.channels {
+			ch = 0
+			if len(e.block[0]) == BlockSize {
+				if err := e.writeFrame(); err != nil {
+					e.err = err
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeFrame encodes and emits the pending block.
+func (e *Encoder) writeFrame() error {
+	n := len(e.block[0])
+	f := &e.frame
+	f.reset()
+
+	f.write(0x3ffe, 14) // sync
+	f.write(0, 1)       // reserved
+	f.write(0, 1)       // fixed block size
+	if n == BlockSize {
+		f.write(12, 4) // 256 * 2^(12-8) = 4096
+	} else {
+		f.write(7, 4) // 16-bit (blocksize-1) follows
+	}
+	f.write(uint64(sampleRateCode(e.sampleRate)), 4)
+	f.write(uint64(e.channels-1), 4) // independent channels
+	if e.bitsPerSample == 16 {
+		f.write(4, 3)
+	} else {
+		f.write(6, 3)
+	}
+	f.write(0, 1) // reserved
+	writeUTF8(f, e.frameNo)
+	if n != BlockSize {
+		f.write(uint64(n-1), 16)
+	}
+	f.write(uint64(crc8(f.buf)), 8)
+
+	for ch := 0; ch < e.channels; ch++ {
+		e.writeSubframe(e.block[ch])
+		e.block[ch] = e.block[ch][:0]
+	}
+	f.align()
+	f.buf = binary.BigEndian.AppendUint16(f.buf, crc16(f.buf))
+
+	size := len(f.buf)
+	if e.frameNo == 0 || size < e.minFrame {
+		e.minFrame = size
+	}
+	e.maxFrame = max(e.maxFrame, size)
+	e.frameNo++
+	e.total += uint64(n)
+
+	_, err := e.w.Write(f.buf)
+	return err
+}
+
+func (e *Encoder) writeSubframe(x []int64) {
+	f := &e.frame
+	bps := e.bitsPerSample
+	n := len(x)
+
+	constant := true
+	for _, v := range x[1:] {
+		if v != x[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		f.write(0, 8) // padding bit, type CONSTANT, no wasted bits
+		f.writeSigned(x[0], bps)
+		return
+	}
+
+	// Pick the fixed predictor with the smallest absolute residual sum.
+	best, bestSum := -1, uint64(math.MaxUint64)
+	for order := 0; order <= maxFixedOrder && order < n; order++ {
+		r := e.resid[order][:n-order]
+		var sum uint64
+		for i := range r {
+			r[i] = fixedResidual(x, order, i+order)
+			if r[i] < 0 {
+				sum += uint64(-r[i])
+			} else {
+				sum += uint64(r[i])
+			}
+		}
+		if sum < bestSum {
+			best, bestSum = order, sum
+		}
+	}
+
+	partOrder, params, bits := riceParams(e.resid[best][:n-best], n, best)
+	bits += best*bps + 2 + 4
+	if bits >= n*bps {
+		f.write(1<<1, 8) // VERBATIM
+		for _, v := range x {
+			f.writeSigned(v, bps)
+		}
+		return
+	}
+
+	f.write(uint64(8|best)<<1, 8) // FIXED, order best
+	for _, v := range x[:best] {
+		f.writeSigned(v, bps)
+	}
+	f.write(0, 2) // Rice coding with 4-bit parameters
+	f.write(uint64(partOrder), 4)
+	resid := e.resid[best][:n-best]
+	pos := 0
+	for p, k := range params {
+		count := n >> uint(partOrder)
+		if p == 0 {
+			count -= best
+		}
+		f.write(uint64(k), 4)
+		for _, r := range resid[pos : pos+count] {
+			f.writeRice(r, k)
+		}
+		pos += count
+	}
+}
+
+// fixedResidual returns the order-th fixed-predictor residual at x[i].
+func fixedResidual(x []int64, order, i int) int64 {
+	switch order {
+	case 0:
+		return x[i]
+	case 1:
+		return x[i] - x[i-1]
+	case 2:
+		return x[i] - 2*x[i-1] + x[i-2]
+	case 3:
+		return x[i] - 3*x[i-1] + 3*x[i-2] - x[i-3]
+	default:
+		return x[i] - 4*x[i-1] + 6*x[i-2] - 4*x[i-3] + x[i-4]
+	}
+}
+
+// riceParams chooses the partition order and per-partition Rice parameters
+// that minimise the estimated residual size for a block of n samples whose
+// first predOrder samples are warm-up.
+func riceParams(resid []int64, n, predOrder int) (partOrder int, params []int, bits int) {
+	bits = math.MaxInt
+	for po := 0; po <= maxPartitionOrder; po++ {
+		if n%(1<<po) != 0 || n>>po <= predOrder {
+			break
+		}
+		ps := make([]int, 1<<po)
+		total := 0
+		pos := 0
+		for p := range ps {
+			count := n >> po
+			if p == 0 {
+				count -= predOrder
+			}
+			var sum uint64
+			for _, r := range resid[pos : pos+count] {
+				sum += uint64(r<<1) ^ uint64(r>>63)
+			}
+			pos += count
+			k, b := bestRice(sum, count)
+			ps[p] = k
+			total += 4 + b
+		}
+		if total < bits {
+			partOrder, params, bits = po, ps, total
+		}
+	}
+	return partOrder, params, bits
+}
+
+// bestRice estimates the cheapest Rice parameter for count values summing to sum.
+func bestRice(sum uint64, count int) (k, bits int) {
+	bits = math.MaxInt
+	for p := 0; p <= maxRiceParam; p++ {
+		b := count*(p+1) + int(sum>>uint(p))
+		if b < bits {
+			k, bits = p, b
+		}
+	}
+	return k, bits
+}
+
+func sampleRateCode(rate int) int {
+	switch rate {
+	case 88200:
+		return 1
+	case 176400:
+		return 2
+	case 192000:
+		return 3
+	case 8000:
+		return 4
+	case 16000:
+		return 5
+	case 22050:
+		return 6
+	case 24000:
+		return 7
+	case 32000:
+		return 8
+	case 44100:
+		return 9
+	case 48000:
+		return 10
+	case 96000:
+		return 11
+	default:
+		return 0 // taken from STREAMINFO
+	}
+}
+
+// writeUTF8 writes v using the extended UTF-8 coding FLAC uses for frame numbers.
+func writeUTF8(f *bitWriter, v uint64) {
+	if v < 0x80 {
+		f.write(v, 8)
+		return
+	}
+	n := 2
+	for v >= 1<<(5*n+1) {
+		n++
+	}
+	f.write(uint64(0xff00>>n)&0xff|v>>(6*(n-1)), 8)
+	for i := n - 2; i >= 0; i-- {
+		f.write(0x80|v>>(6*i)&0x3f, 8)
+	}
+}
@@ -0,0 +1,165 @@
+package flac_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	mflac "github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/meta"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/flac"
+)
+
+// encode writes pcm in uneven pieces and returns header + frames.
+func encode(t *testing.T, pcm []byte, sampleRate, channels, bps int, comments ...string) []byte {
+	t.Helper()
+	var frames bytes.Buffer
+	enc, err := flac.NewEncoder(&frames, sampleRate, channels, bps, comments...)
+	if err != nil {
+		t.Fatalf("NewEncoder: %v", err)
+	}
+	for len(pcm) > 0 {
+		n := min(len(pcm), 3001)
+		if _, err := enc.Write(pcm[:n]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		pcm = pcm[n:]
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return append(enc.Header(), frames.Bytes()...)
+}
+
+// decode returns the interleaved samples of a FLAC stream.
+func decode(t *testing.T, data []byte) (*mflac.Stream, []int32) {
+	t.Helper()
+	stream, err := mflac.Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("flac.Parse: %v", err)
+	}
+	var samples []int32
+	for {
+		frame, err := stream.ParseNext()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ParseNext: %v", err)
+		}
+		for i := 0; i < int(frame.BlockSize); i++ {
+			for _, sub := range frame.Subframes {
+				samples = append(samples, sub.Samples[i])
+			}
+		}
+	}
+	return stream, samples
+}
+
+func speechLike(n, sampleRate int) []int16 {
+	rng := rand.New(rand.NewSource(1))
+	s := make([]int16, n)
+	for i := range s {
+		t := float64(i) / float64(sampleRate)
+		env := 0.5 + 0.5*math.Sin(2*math.Pi*3*t)
+		v := env * (8000*math.Sin(2*math.Pi*180*t) + 3000*math.Sin(2*math.Pi*720*t) + 300*rng.NormFloat64())
+		s[i] = int16(v)
+	}
+	// A pause spanning whole blocks exercises CONSTANT subframes.
+	clear(s[n/2 : n/2+2*flac.BlockSize])
+	return s
+}
+
+func TestEncoder_RoundTripMono16(t *testing.T) {
+	const sr = 24000
+	src := speechLike(3*sr+123, sr)
+	pcm := make([]byte, 0, len(src)*2)
+	for _, v := range src {
+		pcm = binary.LittleEndian.AppendUint16(pcm, uint16(v))
+	}
+
+	data := encode(t, pcm, sr, 1, 16, "TITLE=Chapter 1", "ARTIST=Emma")
+	stream, got := decode(t, data)
+
+	info := stream.Info
+	if info.SampleRate != sr || info.NChannels != 1 || info.BitsPerSample != 16 {
+		t.Errorf("unexpected STREAMINFO: %+v", info)
+	}
+	if info.NSamples != uint64(len(src)) {
+		t.Errorf("NSamples = %d, want %d", info.NSamples, len(src))
+	}
+	if info.MD5sum != md5.Sum(pcm) {
+		t.Error("STREAMINFO MD5 does not match the input PCM")
+	}
+	if len(got) != len(src) {
+		t.Fatalf("decoded %d samples, want %d", len(got), len(src))
+	}
+	for i := range src {
+		if got[i] != int32(src[i]) {
+			t.Fatalf("sample %d = %d, want %d", i, got[i], src[i])
+		}
+	}
+	if len(data) >= len(pcm)*3/4 {
+		t.Errorf("FLAC is %d bytes for %d bytes of PCM; expected real compression", len(data), len(pcm))
+	}
+
+	var comments []string
+	for _, block := range stream.Blocks {
+		if vc, ok := block.Body.(*meta.VorbisComment); ok {
+			for _, tag := range vc.Tags {
+				comments = append(comments, tag[0]+"="+tag[1])
+			}
+		}
+	}
+	if len(comments) != 2 || comments[0] != "TITLE=Chapter 1" || comments[1] != "ARTIST=Emma" {
+		t.Errorf("comments = %v", comments)
+	}
+}
+
+func TestEncoder_RoundTripStereo24(t *testing.T) {
+	const sr = 48000
+	rng := rand.New(rand.NewSource(2))
+	n := flac.BlockSize + 17
+	var src []int32
+	var pcm []byte
+	for i := 0; i < n; i++ {
+		for ch := 0; ch < 2; ch++ {
+			v := int32(rng.Intn(1<<24) - 1<<23)
+			src = append(src, v)
+			pcm = append(pcm, byte(v), byte(v>>8), byte(v>>16))
+		}
+	}
+	_, got := decode(t, encode(t, pcm, sr, 2, 24))
+	if len(got) != len(src) {
+		t.Fatalf("decoded %d samples, want %d", len(got), len(src))
+	}
+	for i := range src {
+		if got[i] != src[i] {
+			t.Fatalf("sample %d = %d, want %d", i, got[i], src[i])
+		}
+	}
+}
+
+func TestEncoder_Empty(t *testing.T) {
+	stream, got := decode(t, encode(t, nil, 24000, 1, 16))
+	if stream.Info.NSamples != 0 || len(got) != 0 {
+		t.Errorf("expected an empty stream, got %d samples", len(got))
+	}
+}
+
+func TestNewEncoder_Invalid(t *testing.T) {
+	if _, err := flac.NewEncoder(io.Discard, 24000, 1, 8); err == nil {
+		t.Error("expected error for 8-bit PCM")
+	}
+	if _, err := flac.NewEncoder(io.Discard, 0, 1, 16); err == nil {
+		t.Error("expected error for zero sample rate")
+	}
+	if _, err := flac.NewEncoder(io.Discard, 24000, 9, 16); err == nil {
+		t.Error("expected error for 9 channels")
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/middleware"
+)
+
+// MaxDeviceTokensPerOwner caps how many devices one owner can register.
+const MaxDeviceTokensPerOwner = 20
+
+// maxDeviceTokenLength is well above the length of FCM registration tokens.
+const maxDeviceTokenLength = 4096
+
+// DeviceTokenRequest is the request body for POST and DELETE /deviceTokens.
+// The owner is never named in the body: it is the signed-in caller.
+type DeviceTokenRequest struct {
+	Token string `json:"token"` // FCM registration token
+}
+
+// DeviceTokensHandler handles /deviceTokens.
+// POST registers a device's FCM token to the signed-in user, so
+// notifications for all of their jobs reach it; DELETE unregisters it,
+// e.g. on sign-out. Both are idempotent and reply 204.
+func (d *JobDeps) DeviceTokensHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if d.Devices == nil {
+		http.Error(w, `{"error":"device registration not enabled"}`, http.StatusNotImplemented)
+		return
+	}
+
+	owner := middleware.RequestUser(r)
+	if owner == "" {
+		http.Error(w, `{"error":"sign-in required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req DeviceTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" || len(req.Token) > maxDeviceTokenLength {
+		http.Error(w, `{"error":"invalid token"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	if r.Method == http.MethodDelete {
+		if err := d.Devices.Remove(ctx, owner, req.Token); err != nil {
+			log.Printf("DeviceTokens: remove %s: %v", owner, err)
+			http.Error(w, `{"error":"failed to unregister device"}`, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	tokens, err := d.Devices.List(ctx, owner)
+	if err != nil {
+		log.Printf("DeviceTokens: list %s: %v", owner, err)
+		http.Error(w, `{"error":"failed to register device"}`, http.StatusInternalServerError)
+		return
+	}
+	if slices.Contains(tokens, req.Token) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if len(tokens) >= MaxDeviceTokensPerOwner {
+		http.Error(w, `{"error":"too many devices registered"}`, http.StatusConflict)
+		return
+	}
+	if err := d.Devices.Add(ctx, owner, req.Token); err != nil {
+		log.Printf("DeviceTokens: add %s: %v", owner, err)
+		http.Error(w, `{"error":"failed to register device"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -20,16 +20,20 @@ import (
 	texttospeech "google.golang.org/api/texttospeech/v1beta1"
 
 	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
 )
 
 const maxTextLength = 5000
 
 // GenerateAudioTTSRequest represents the request body for generateAudioWithTTS endpoint
 type GenerateAudioTTSRequest struct {
-	Text     string `json:"text"`
-	VoiceID  string `json:"voiceId"`
-	Language string `json:"language"`
-	Style    string `json:"style"`
+	Text         string `json:"text"`
+	VoiceID      string `json:"voiceId"`
+	Language     string `json:"language"`
+	Style        string `json:"style"`
+	Title        string `json:"title"`        // tagged into the audio file where supported
+	OutputFormat string `json:"outputFormat"` // "wav" (default), "mp3" or "flac"
+	Bitrate      int    `json:"bitrate"`      // kbps, mp3 only
 }
 
 // Timepoint represents a word timing for highlighting
@@ -84,6 +88,17 @@ func GenerateAudioTTSHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	format, err := jobs.ParseOutputFormat(req.OutputFormat)
+	if err != nil || format == jobs.OutputFormatHLS { // HLS is only produced by jobs
+		http.Error(w, `{"error": "Unsupported output format"}`, http.StatusBadRequest)
+		return
+	}
+	bitrate, err := jobs.ResolveBitrate(format, req.Bitrate)
+	if err != nil {
+		http.Error(w, `{"error": "Unsupported bitrate"}`, http.StatusBadRequest)
+		return
+	}
+
 	// Default voice ID
 	if req.VoiceID == "" {
 		req.VoiceID = "en-us-female-a"
@@ -123,11 +138,27 @@ func GenerateAudioTTSHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Encode after synthesis so timepoints only shift by the encoder delay
+	audioContent, delay, err := jobs.EncodeAudio(audioContent, format, jobs.EncodeOptions{
+		Bitrate:  bitrate,
+		Title:    req.Title,
+		Voice:    voice,
+		Language: language,
+	})
+	if err != nil {
+		log.Printf("Audio encoding error: %v", err)
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to encode audio", "message": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	for i := range timepoints {
+		timepoints[i].TimeSeconds += delay
+	}
+
 	// Generate unique filename
-	filename := fmt.Sprintf("audio/%s_%d_%s.wav", req.VoiceID, time.Now().Unix(), uuid.New().String())
+	filename := fmt.Sprintf("audio/%s_%d_%s.%s", req.VoiceID, time.Now().Unix(), uuid.New().String(), format.Extension())
 
 	// Upload to Cloud Storage
-	audioURL, err := uploadToStorage(audioContent, filename, req.Text, req.VoiceID, language, req.Style)
+	audioURL, err := uploadToStorage(audioContent, filename, format.ContentType(), req.Text, req.VoiceID, language, req.Style)
 	if err != nil {
 		log.Printf("Storage upload error: %v", err)
 		http.Error(w, fmt.Sprintf(`{"error": "Failed to save audio", "message": "%s"}`, err.Error()), http.StatusInternalServerError)
@@ -142,7 +173,7 @@ func GenerateAudioTTSHandler(w http.ResponseWriter, r *http.Request) {
 		Style:        req.Style,
 		AudioURL:     audioURL,
 		Filename:     filename,
-		MimeType:     "audio/wav",
+		MimeType:     format.ContentType(),
 		Message:      "Audio generated and saved successfully",
 		Timepoints:   timepoints,
 	}
@@ -339,7 +370,7 @@ func generateTTSAudioV1(text string, voice *config.VoiceOption, language string)
 }
 
 // uploadToStorage uploads audio to Google Cloud Storage
-func uploadToStorage(audioContent []byte, filename, originalText, voiceID, language, style string) (string, error) {
+func uploadToStorage(audioContent []byte, filename, contentType, originalText, voiceID, language, style string) (string, error) {
 	ctx := context.Background()
 
 	bucketName := os.Getenv("STORAGE_BUCKET_NAME")
@@ -358,7 +389,7 @@ func uploadToStorage(audioContent []byte, filename, originalText, voiceID, langu
 
 	// Upload the file
 	writer := obj.NewWriter(ctx)
-	writer.ContentType = "audio/wav"
+	writer.ContentType = contentType
 	writer.Metadata = map[string]string{
 		"originalText": originalText,
 		"voice":        voiceID,
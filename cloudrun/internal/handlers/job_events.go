@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+)
+
+// sseKeepAlive is how often an idle event stream sends a comment line so
+// proxies and load balancers do not close it.
+const sseKeepAlive = 15 * time.Second
+
+// JobEventsHandler handles GET /jobs/{jobId}/events.
+// Streams Server-Sent Events until the job completes or fails: a "status"
+// event with the job's current state first, then "status" events for each
+// transition and a "progress" event with the chunk count and that chunk's
+// timepoints as each chunk is synthesized. The data of every event is a
+// jobs.JobEvent.
+func (d *JobDeps) JobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID := r.PathValue("jobId")
+	if jobID == "" {
+		http.Error(w, `{"error":"jobId required"}`, http.StatusBadRequest)
+		return
+	}
+
+	if d.Events == nil {
+		http.Error(w, `{"error":"job events not supported"}`, http.StatusNotImplemented)
+		return
+	}
+
+	// Subscribe before reading the job so no transition falls in between.
+	ctx := r.Context()
+	events, err := d.Events.Subscribe(ctx, jobID)
+	if err != nil {
+		log.Printf("JobEvents: subscribe %s: %v", jobID, err)
+		http.Error(w, `{"error":"failed to subscribe to job events"}`, http.StatusInternalServerError)
+		return
+	}
+
+	job, err := d.Store.Get(ctx, jobID)
+	if err != nil {
+		log.Printf("JobEvents: store.Get %s: %v", jobID, err)
+		http.Error(w, `{"error":"job not found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	send := func(event jobs.JobEvent) bool {
+		data, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("JobEvents: encode %s event %s: %v", event.Type, jobID, err)
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	current := jobs.JobEvent{Type: jobs.JobEventStatus, Status: job.Status, AudioURL: job.AudioURL, ErrorMsg: job.ErrorMsg}
+	if !send(current) || jobFinished(job.Status) {
+		return
+	}
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case event, ok := <-events:
+			// A closed channel means the subscriber fell behind; the
+			// client reconnects and starts again from the current state.
+			if !ok || !send(event) {
+				return
+			}
+			if event.Type == jobs.JobEventStatus && jobFinished(event.Status) {
+				return
+			}
+		}
+	}
+}
+
+// jobFinished reports whether status is final.
+func jobFinished(status jobs.JobStatus) bool {
+	return status == jobs.JobStatusCompleted || status == jobs.JobStatusFailed
+}
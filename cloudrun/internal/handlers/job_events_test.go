@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+)
+
+// readSSE reads one event from an SSE stream, skipping comment lines.
+func readSSE(t *testing.T, r *bufio.Reader) (string, jobs.JobEvent) {
+	t.Helper()
+	var name string
+	var event jobs.JobEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && name != "":
+			return name, event
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("bad data %q: %v", line, err)
+			}
+		}
+	}
+}
+
+func TestJobEventsHandler_StreamsProcessing(t *testing.T) {
+	// The stream and the processing share only the event bus, as they
+	// would on separate instances.
+	job := jobs.Job{ID: "job-1", Text: "こんにちは", VoiceID: "ja-jp-female-a", Language: "ja-JP", Status: jobs.JobStatusPending}
+	events := jobs.NewMemoryJobEvents()
+	copied := job
+	d := &JobDeps{Store: newMockJobStore(&job), Events: events}
+	processor := &JobDeps{Store: newMockJobStore(&copied), Gen: mockTTSGenerator{}, Storage: newMemAudioStorage(), Events: events}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs/{jobId}/events", d.JobEventsHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/jobs/job-1/events")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	// The current state arrives first, once the handler has subscribed.
+	if name, e := readSSE(t, r); name != "status" || e.Status != jobs.JobStatusPending {
+		t.Fatalf("first event = %s %+v, want pending status", name, e)
+	}
+
+	postJSON(processor.ProcessJobHandler, "/jobs/process", `{"jobId":"job-1"}`)
+
+	if name, e := readSSE(t, r); name != "status" || e.Status != jobs.JobStatusProcessing {
+		t.Errorf("event = %s %+v, want processing status", name, e)
+	}
+	if name, e := readSSE(t, r); name != "progress" || e.Chunk != 1 || e.Chunks != 1 || len(e.Timepoints) != 1 || e.Timepoints[0].MarkName != "0:0:1" {
+		t.Errorf("event = %s %+v, want progress of chunk 1/1 with its timepoint", name, e)
+	}
+	name, e := readSSE(t, r)
+	if name != "status" || e.Status != jobs.JobStatusCompleted || e.AudioURL == "" {
+		t.Errorf("event = %s %+v, want completed status with audioUrl", name, e)
+	}
+	// The stream ends with the job.
+	if rest, _ := io.ReadAll(r); len(rest) != 0 {
+		t.Errorf("unexpected data after completion: %q", rest)
+	}
+}
+
+func TestJobEventsHandler_FinishedJob(t *testing.T) {
+	store := newMockJobStore(&jobs.Job{ID: "job-1", Status: jobs.JobStatusFailed, ErrorMsg: "boom"})
+	d := &JobDeps{Store: store, Events: jobs.NewMemoryJobEvents()}
+
+	req := httptest.NewRequest(http.MethodGet, "/jobs/job-1/events", nil)
+	req.SetPathValue("jobId", "job-1")
+	w := httptest.NewRecorder()
+	d.JobEventsHandler(w, req)
+
+	want := "event: status\ndata: {\"type\":\"status\",\"status\":\"failed\",\"errorMsg\":\"boom\"}\n\n"
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("got %d %q, want 200 %q", w.Code, w.Body.String(), want)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/jobs/missing/events", nil)
+	req.SetPathValue("jobId", "missing")
+	w = httptest.NewRecorder()
+	d.JobEventsHandler(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing job: status = %d, want 404", w.Code)
+	}
+
+	d.Events = nil
+	w = httptest.NewRecorder()
+	d.JobEventsHandler(w, req)
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("no event bus: status = %d, want 501", w.Code)
+	}
+}
@@ -3,16 +3,31 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 
 	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
 	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/middleware"
+)
+
+const (
+	// alertTTL is how long FCM keeps trying to deliver a completion or
+	// failure alert to an offline device.
+	alertTTL = 24 * time.Hour
+	// progressPushInterval spaces out silent progress pushes, which both
+	// platforms throttle, and is also their TTL since they go stale.
+	progressPushInterval = 30 * time.Second
 )
 
 // JobDeps holds all dependencies for job handlers.
@@ -22,21 +37,41 @@ type JobDeps struct {
 	Gen      jobs.TTSGenerator
 	Storage  jobs.AudioStorage
 	Notifier jobs.Notifier
+	Usage    jobs.UsageStore       // optional; quotas are not enforced when nil
+	Events   jobs.JobEvents        // optional; GET /jobs/{jobId}/events is unavailable when nil
+	Webhooks jobs.WebhookNotifier  // optional; callbackUrl is rejected when nil
+	Devices  jobs.DeviceTokenStore // optional; only the submitting device is notified when nil
+	Mailer   jobs.EmailNotifier    // optional; notifyEmail is rejected when nil
 }
 
 // CreateJobRequest is the request body for POST /jobs.
 type CreateJobRequest struct {
-	Text        string `json:"text"`
-	VoiceID     string `json:"voiceId"`
-	Language    string `json:"language"`
-	Style       string `json:"style"`
-	FileID      string `json:"fileId"`
-	DeviceToken string `json:"deviceToken"`
+	Text         string            `json:"text"`
+	VoiceID      string            `json:"voiceId"`
+	Language     string            `json:"language"`
+	Style        string            `json:"style"`
+	FileID       string            `json:"fileId"`
+	DeviceToken  string            `json:"deviceToken"`
+	Title        string            `json:"title"`        // document title, tagged into the audio
+	Locale       string            `json:"locale"`       // optional language of notification texts, e.g. "de" or "pt-BR"
+	OutputFormat string            `json:"outputFormat"` // "wav" (default), "mp3", "flac" or "hls"
+	Bitrate      int               `json:"bitrate"`      // kbps, mp3 only
+	Pauses       *jobs.Pauses      `json:"pauses"`       // optional silence at paragraphs, headings and chunk joins
+	Loudness     *jobs.Loudness    `json:"loudness"`     // optional EBU R128 loudness normalization
+	TrimSilence  *jobs.SilenceTrim `json:"trimSilence"`  // optional per-chunk lead-in/tail silence removal
+	CrossfadeMs  int               `json:"crossfadeMs"`  // optional crossfade at chunk joins, up to 200 ms
+	RenderSpeed  float64           `json:"renderSpeed"`  // optional tempo change keeping pitch, 0.5 to 3
+	Background   *jobs.Background  `json:"background"`   // optional looping background track, ducked under speech
+	Chapters     []jobs.Chapter    `json:"chapters"`     // optional explicit chapters
+	AutoChapters bool              `json:"autoChapters"` // optional: without chapters, add one per heading line
+	CallbackURL  string            `json:"callbackUrl"`  // optional https URL for signed completion/failure webhooks
+	NotifyEmail  string            `json:"notifyEmail"`  // optional address emailed the audio link on completion/failure
 }
 
 // CreateJobResponse is the response for POST /jobs.
 type CreateJobResponse struct {
-	JobID string `json:"jobId"`
+	JobID       string `json:"jobId"`
+	PlaylistURL string `json:"playlistUrl,omitempty"` // HLS jobs only; fills in as segments are synthesized
 }
 
 // ProcessTaskRequest is the request body for POST /jobs/process (called by Cloud Tasks).
@@ -71,25 +106,137 @@ func (d *JobDeps) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	format, err := jobs.ParseOutputFormat(req.OutputFormat)
+	if err != nil {
+		http.Error(w, `{"error":"unsupported outputFormat"}`, http.StatusBadRequest)
+		return
+	}
+	bitrate, err := jobs.ResolveBitrate(format, req.Bitrate)
+	if err != nil {
+		http.Error(w, `{"error":"unsupported bitrate"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Pauses != nil {
+		if err := req.Pauses.Validate(); err != nil {
+			http.Error(w, `{"error":"invalid pauses"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if req.TrimSilence != nil {
+		if err := req.TrimSilence.Validate(); err != nil {
+			http.Error(w, `{"error":"invalid trimSilence"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if err := jobs.ValidateCrossfadeMs(req.CrossfadeMs); err != nil {
+		http.Error(w, `{"error":"invalid crossfadeMs"}`, http.StatusBadRequest)
+		return
+	}
+	if err := jobs.ValidateChapters(req.Chapters, utf8.RuneCountInString(req.Text)); err != nil {
+		http.Error(w, `{"error":"invalid chapters"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Background != nil {
+		if err := req.Background.Validate(); err != nil {
+			http.Error(w, `{"error":"invalid background"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if err := jobs.ValidateRenderSpeed(req.RenderSpeed); err != nil {
+		http.Error(w, `{"error":"invalid renderSpeed"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Loudness != nil {
+		if err := req.Loudness.Validate(); err != nil {
+			http.Error(w, `{"error":"invalid loudness"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Locale != "" {
+		if err := jobs.ValidateLocale(req.Locale); err != nil {
+			http.Error(w, `{"error":"invalid locale"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if req.NotifyEmail != "" {
+		if d.Mailer == nil {
+			http.Error(w, `{"error":"email notifications not enabled"}`, http.StatusNotImplemented)
+			return
+		}
+		if err := jobs.ValidateEmailAddress(req.NotifyEmail); err != nil {
+			http.Error(w, `{"error":"invalid notifyEmail"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if req.CallbackURL != "" {
+		if d.Webhooks == nil {
+			http.Error(w, `{"error":"webhooks not enabled"}`, http.StatusNotImplemented)
+			return
+		}
+		if err := jobs.ValidateCallbackURL(req.CallbackURL); err != nil {
+			http.Error(w, `{"error":"invalid callbackUrl"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	owner := middleware.RequestUser(r)
+	if d.Usage != nil {
+		// Every job is billed to someone, so quotas need a signed-in creator
+		if owner == "" {
+			http.Error(w, `{"error":"sign-in required"}`, http.StatusUnauthorized)
+			return
+		}
+		usage, err := d.Usage.Get(ctx, owner)
+		if err != nil {
+			// Fail open: a usage lookup outage should not block generation
+			log.Printf("CreateJob: usage.Get failed: %v", err)
+		} else {
+			seconds, bytes := jobs.EstimateAudio(req.Text, format, bitrate)
+			var quotaErr *jobs.QuotaExceededError
+			if errors.As(jobs.CheckQuota(usage, seconds, bytes), &quotaErr) {
+				writeQuotaExceeded(w, quotaErr)
+				return
+			}
+		}
+	}
+
 	jobID := uuid.New().String()
 	job := &jobs.Job{
-		ID:          jobID,
-		Status:      jobs.JobStatusPending,
-		Text:        req.Text,
-		VoiceID:     req.VoiceID,
-		Language:    req.Language,
-		Style:       req.Style,
-		FileID:      req.FileID,
-		DeviceToken: req.DeviceToken,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+		ID:           jobID,
+		Status:       jobs.JobStatusPending,
+		Text:         req.Text,
+		VoiceID:      req.VoiceID,
+		Language:     req.Language,
+		Style:        req.Style,
+		FileID:       req.FileID,
+		DeviceToken:  req.DeviceToken,
+		OwnerID:      owner,
+		Title:        req.Title,
+		Locale:       req.Locale,
+		OutputFormat: format,
+		Bitrate:      bitrate,
+		Pauses:       req.Pauses,
+		TrimSilence:  req.TrimSilence,
+		CrossfadeMs:  req.CrossfadeMs,
+		Loudness:     req.Loudness,
+		RenderSpeed:  req.RenderSpeed,
+		Background:   req.Background,
+		Chapters:     req.Chapters,
+		AutoChapters: req.AutoChapters,
+		CallbackURL:  req.CallbackURL,
+		NotifyEmail:  req.NotifyEmail,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
 	}
 
 	// Firestore document limit is 1MB. For large texts, store in GCS instead.
-	ctx := r.Context()
 	const maxFirestoreTextBytes = 500_000
 	if len(req.Text) > maxFirestoreTextBytes {
-		textURL, err := d.Storage.Upload(ctx, []byte(req.Text), "text/jobs/"+jobID+".txt")
+		textURL, err := d.Storage.Upload(ctx, []byte(req.Text), "text/jobs/"+jobID+".txt", jobs.UploadOptions{
+			ContentType: "text/plain; charset=utf-8",
+			Metadata:    map[string]string{"jobId": jobID},
+		})
 		if err != nil {
 			log.Printf("CreateJob: upload text to GCS failed: %v", err)
 			http.Error(w, `{"error":"failed to upload text"}`, http.StatusInternalServerError)
@@ -100,6 +247,16 @@ func (d *JobDeps) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("CreateJob: large text (%d bytes) stored at %s", len(req.Text), textURL)
 	}
 
+	if format == jobs.OutputFormatHLS {
+		playlistURL, err := jobs.StartHLSPlaylist(ctx, d.Storage, jobID)
+		if err != nil {
+			log.Printf("CreateJob: start HLS playlist failed: %v", err)
+			http.Error(w, `{"error":"failed to create playlist"}`, http.StatusInternalServerError)
+			return
+		}
+		job.PlaylistURL = playlistURL
+	}
+
 	if err := d.Store.Create(ctx, job); err != nil {
 		log.Printf("CreateJob: store.Create failed: %v", err)
 		http.Error(w, `{"error":"failed to create job"}`, http.StatusInternalServerError)
@@ -114,7 +271,7 @@ func (d *JobDeps) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("CreateJob: created jobId=%s text_len=%d voiceId=%s", job.ID, len(job.Text), job.VoiceID)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(CreateJobResponse{JobID: job.ID})
+	json.NewEncoder(w).Encode(CreateJobResponse{JobID: job.ID, PlaylistURL: job.PlaylistURL})
 }
 
 // GetJobHandler handles GET /jobs/{jobId}.
@@ -142,6 +299,145 @@ func (d *JobDeps) GetJobHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(job)
 }
 
+// GetJobAudioHandler handles GET /jobs/{jobId}/audio.
+// Proxies the generated audio from storage with Content-Length, ETag and
+// byte-range support so players can seek without downloading the whole file.
+// Only the job's owner, signed in with a Firebase ID token, may download it.
+// Storage is read in bounded windows (see jobs.NewAudioReadSeeker), so a
+// range request fetches little more than the requested bytes.
+func (d *JobDeps) GetJobAudioHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID := r.PathValue("jobId")
+	if jobID == "" {
+		http.Error(w, `{"error":"jobId required"}`, http.StatusBadRequest)
+		return
+	}
+
+	reader, ok := d.Storage.(jobs.ReadableAudioStorage)
+	if !ok {
+		http.Error(w, `{"error":"audio download not supported"}`, http.StatusNotImplemented)
+		return
+	}
+	caller := middleware.RequestUser(r)
+	if caller == "" {
+		http.Error(w, `{"error":"sign-in required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	job, err := d.Store.Get(ctx, jobID)
+	if err != nil {
+		log.Printf("GetJobAudio: store.Get %s: %v", jobID, err)
+		http.Error(w, `{"error":"job not found"}`, http.StatusNotFound)
+		return
+	}
+	if job.OwnerID != caller {
+		http.Error(w, `{"error":"not the owner of this job"}`, http.StatusForbidden)
+		return
+	}
+	if job.Status != jobs.JobStatusCompleted || job.AudioPath == "" {
+		http.Error(w, `{"error":"audio not available"}`, http.StatusConflict)
+		return
+	}
+
+	info, err := reader.Stat(ctx, job.AudioPath)
+	if errors.Is(err, jobs.ErrAudioNotFound) {
+		http.Error(w, `{"error":"audio not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("GetJobAudio: stat %s: %v", job.AudioPath, err)
+		http.Error(w, `{"error":"failed to read audio"}`, http.StatusInternalServerError)
+		return
+	}
+
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "audio/wav"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if info.ETag != "" {
+		w.Header().Set("ETag", quoteETag(info.ETag))
+	}
+
+	content := jobs.NewAudioReadSeeker(ctx, reader, job.AudioPath, info.Size)
+	defer content.Close()
+	http.ServeContent(w, r, path.Base(job.AudioPath), info.Updated, content)
+}
+
+// quoteETag wraps a storage ETag in double quotes as required by RFC 9110.
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// QuotaExceededResponse is the 402 body returned when a job would exceed the owner's tier limits.
+type QuotaExceededResponse struct {
+	Error     string  `json:"error"`
+	Message   string  `json:"message"`
+	Tier      string  `json:"tier"`
+	Resource  string  `json:"resource"`
+	Used      float64 `json:"used"`
+	Requested float64 `json:"requested"`
+	Limit     float64 `json:"limit"`
+}
+
+func writeQuotaExceeded(w http.ResponseWriter, e *jobs.QuotaExceededError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPaymentRequired)
+	json.NewEncoder(w).Encode(QuotaExceededResponse{
+		Error:     "quota_exceeded",
+		Message:   e.Error(),
+		Tier:      e.Tier,
+		Resource:  e.Resource,
+		Used:      e.Used,
+		Requested: e.Requested,
+		Limit:     e.Limit,
+	})
+}
+
+// UsageResponse is the response for GET /usage.
+type UsageResponse struct {
+	*jobs.Usage
+	Limits config.QuotaLimits `json:"limits"`
+}
+
+// UsageHandler handles GET /usage.
+// Returns the signed-in caller's stored bytes and generated seconds together
+// with their tier limits.
+func (d *JobDeps) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if d.Usage == nil {
+		http.Error(w, `{"error":"usage tracking not enabled"}`, http.StatusNotImplemented)
+		return
+	}
+
+	ownerID := middleware.RequestUser(r)
+	if ownerID == "" {
+		http.Error(w, `{"error":"sign-in required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	usage, err := d.Usage.Get(r.Context(), ownerID)
+	if err != nil {
+		log.Printf("Usage: usage.Get %s: %v", ownerID, err)
+		http.Error(w, `{"error":"failed to load usage"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UsageResponse{Usage: usage, Limits: config.GetQuotaLimits(usage.Tier)})
+}
+
 // ProcessJobHandler handles POST /jobs/process.
 // Called by Cloud Tasks; processes the job asynchronously.
 // Always returns 200 so Cloud Tasks does not retry on application errors.
@@ -171,9 +467,17 @@ func (d *JobDeps) ProcessJobHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if job.Status == jobs.JobStatusCompleted {
+		// A redelivered task; the job was already generated and billed.
+		log.Printf("ProcessJob: job %s already completed", job.ID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	if err := d.Store.SetProcessing(ctx, job.ID); err != nil {
 		log.Printf("ProcessJob: set processing %s: %v", job.ID, err)
 	}
+	d.publish(ctx, job.ID, jobs.JobEvent{Type: jobs.JobEventStatus, Status: jobs.JobStatusProcessing})
 
 	voice := config.GetVoiceByID(job.VoiceID)
 	if voice == nil {
@@ -184,7 +488,7 @@ func (d *JobDeps) ProcessJobHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := jobs.ProcessJob(ctx, job, voice, d.Gen, d.Storage)
+	result, err := jobs.ProcessJob(ctx, job, voice, d.Gen, d.Storage, d.publishProgress(ctx, job))
 	if err != nil {
 		log.Printf("ProcessJob: process %s failed: %v", job.ID, err)
 		d.failJob(ctx, job, err.Error())
@@ -192,11 +496,24 @@ func (d *JobDeps) ProcessJobHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := d.Store.SetCompleted(ctx, job.ID, result.AudioURL, result.Timepoints); err != nil {
+	if err := d.Store.SetCompleted(ctx, job.ID, result); err != nil {
 		log.Printf("ProcessJob: set completed %s: %v", job.ID, err)
 	}
+	d.publish(ctx, job.ID, jobs.JobEvent{Type: jobs.JobEventStatus, Status: jobs.JobStatusCompleted, AudioURL: result.AudioURL})
+
+	if d.Usage != nil && job.OwnerID != "" {
+		if err := d.Usage.Add(ctx, job.OwnerID, result.AudioBytes, result.DurationSeconds); err != nil {
+			log.Printf("ProcessJob: usage.Add %s: %v", job.ID, err)
+		}
+	}
 
 	d.notifyCompleted(ctx, job, result)
+	d.notifyWebhook(ctx, job, jobs.WebhookEvent{
+		Type:            jobs.WebhookJobCompleted,
+		Status:          jobs.JobStatusCompleted,
+		AudioURL:        result.AudioURL,
+		DurationSeconds: result.DurationSeconds,
+	})
 	log.Printf("ProcessJob: completed jobId=%s audioUrl=%s", job.ID, result.AudioURL)
 	w.WriteHeader(http.StatusOK)
 }
@@ -205,35 +522,165 @@ func (d *JobDeps) failJob(ctx context.Context, job *jobs.Job, errMsg string) {
 	if err := d.Store.SetFailed(ctx, job.ID, errMsg); err != nil {
 		log.Printf("failJob: set failed %s: %v", job.ID, err)
 	}
+	d.publish(ctx, job.ID, jobs.JobEvent{Type: jobs.JobEventStatus, Status: jobs.JobStatusFailed, ErrorMsg: errMsg})
 	d.notifyFailed(ctx, job, errMsg)
+	d.notifyWebhook(ctx, job, jobs.WebhookEvent{Type: jobs.WebhookJobFailed, Status: jobs.JobStatusFailed, Error: errMsg})
 }
 
-func (d *JobDeps) notifyCompleted(ctx context.Context, job *jobs.Job, result *jobs.ProcessResult) {
-	if job.DeviceToken == "" || d.Notifier == nil {
+// publish sends event to the job's subscribers. Events are best effort:
+// the job document stays the source of truth, so failures are only logged.
+func (d *JobDeps) publish(ctx context.Context, jobID string, event jobs.JobEvent) {
+	if d.Events == nil {
 		return
 	}
+	if err := d.Events.Publish(ctx, jobID, event); err != nil {
+		log.Printf("publish %s event %s: %v", event.Type, jobID, err)
+	}
+}
+
+// publishProgress returns the ProcessJob progress callback that publishes
+// chunk progress to event subscribers and, at most every
+// progressPushInterval, as a silent push; or nil when there is nowhere to
+// send it. The last chunk is not pushed since the completion alert follows.
+func (d *JobDeps) publishProgress(ctx context.Context, job *jobs.Job) jobs.ProgressFunc {
+	if d.Events == nil && d.Notifier == nil {
+		return nil
+	}
+	var pushed time.Time
+	return func(chunk, chunks int, timepoints []jobs.TTSTimepoint) {
+		d.publish(ctx, job.ID, jobs.JobEvent{Type: jobs.JobEventProgress, Chunk: chunk, Chunks: chunks, Timepoints: timepoints})
+		if chunk == chunks || time.Since(pushed) < progressPushInterval {
+			return
+		}
+		pushed = time.Now()
+		d.push(ctx, job, jobs.Push{
+			Data: map[string]string{
+				"jobId":  job.ID,
+				"fileId": job.FileID,
+				"status": string(jobs.JobStatusProcessing),
+				"chunk":  strconv.Itoa(chunk),
+				"chunks": strconv.Itoa(chunks),
+			},
+			Silent:      true,
+			CollapseKey: "progress-" + job.ID,
+			TTL:         progressPushInterval,
+		})
+	}
+}
+
+func (d *JobDeps) notifyCompleted(ctx context.Context, job *jobs.Job, result *jobs.ProcessResult) {
 	data := map[string]string{
 		"jobId":    job.ID,
 		"audioUrl": result.AudioURL,
 		"fileId":   job.FileID,
 		"status":   string(jobs.JobStatusCompleted),
 	}
-	if err := d.Notifier.Send(ctx, job.DeviceToken, "音声生成完了", "テキストの読み上げ音声が生成されました", data); err != nil {
-		log.Printf("notifyCompleted: FCM %s: %v", job.ID, err)
-	}
+	title, body := jobs.NotificationText(job.NotificationLocale(), jobs.JobStatusCompleted, job.Title)
+	d.push(ctx, job, jobAlert(job, title, body, data))
+	d.sendEmail(ctx, job, jobs.JobEmail(job, jobs.JobStatusCompleted, result.AudioURL, result.DurationSeconds))
 }
 
 func (d *JobDeps) notifyFailed(ctx context.Context, job *jobs.Job, errMsg string) {
-	if job.DeviceToken == "" || d.Notifier == nil {
-		return
-	}
 	data := map[string]string{
 		"jobId":  job.ID,
 		"fileId": job.FileID,
 		"status": string(jobs.JobStatusFailed),
 		"error":  errMsg,
 	}
-	if err := d.Notifier.Send(ctx, job.DeviceToken, "音声生成失敗", "音声の生成に失敗しました", data); err != nil {
-		log.Printf("notifyFailed: FCM %s: %v", job.ID, err)
+	title, body := jobs.NotificationText(job.NotificationLocale(), jobs.JobStatusFailed, job.Title)
+	d.push(ctx, job, jobAlert(job, title, body, data))
+	d.sendEmail(ctx, job, jobs.JobEmail(job, jobs.JobStatusFailed, "", 0))
+}
+
+// jobAlert is the completion or failure alert for job. It shares one
+// collapse key per job, so a Cloud Tasks retry replaces the earlier alert
+// rather than stacking another, and is grouped on iOS by document.
+func jobAlert(job *jobs.Job, title, body string, data map[string]string) jobs.Push {
+	thread := job.FileID
+	if thread == "" {
+		thread = job.ID
+	}
+	return jobs.Push{
+		Title:       title,
+		Body:        body,
+		Data:        data,
+		CollapseKey: "job-" + job.ID,
+		ThreadID:    thread,
+		TTL:         alertTTL,
+	}
+}
+
+// push notifies the device that submitted the job and every device
+// registered to its owner, and forgets the owner's tokens that FCM reports
+// as unregistered.
+func (d *JobDeps) push(ctx context.Context, job *jobs.Job, push jobs.Push) {
+	if d.Notifier == nil {
+		return
+	}
+	var tokens []string
+	if job.DeviceToken != "" {
+		tokens = append(tokens, job.DeviceToken)
+	}
+	if d.Devices != nil && job.OwnerID != "" {
+		registered, err := d.Devices.List(ctx, job.OwnerID)
+		if err != nil {
+			log.Printf("push: list device tokens %s: %v", job.ID, err)
+		}
+		for _, t := range registered {
+			if !slices.Contains(tokens, t) {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	if len(tokens) == 0 {
+		return
+	}
+
+	unregistered, err := d.Notifier.Send(ctx, tokens, push)
+	if err != nil {
+		log.Printf("push: FCM %s: %v", job.ID, err)
+	}
+	if len(unregistered) == 0 || d.Devices == nil || job.OwnerID == "" {
+		return
+	}
+	if err := d.Devices.Remove(ctx, job.OwnerID, unregistered...); err != nil {
+		log.Printf("push: remove unregistered tokens %s: %v", job.ID, err)
+		return
+	}
+	log.Printf("push: removed %d unregistered device tokens of owner %s", len(unregistered), job.OwnerID)
+}
+
+// sendEmail emails the job's notifyEmail address, if it has one.
+func (d *JobDeps) sendEmail(ctx context.Context, job *jobs.Job, email jobs.Email) {
+	if job.NotifyEmail == "" || d.Mailer == nil {
+		return
+	}
+	if err := d.Mailer.Send(ctx, job.NotifyEmail, email); err != nil {
+		log.Printf("sendEmail: %s: %v", job.ID, err)
+	}
+}
+
+// notifyWebhook delivers event to the job's callbackUrl and records the
+// attempts on the job. The job's own fields are filled in here.
+func (d *JobDeps) notifyWebhook(ctx context.Context, job *jobs.Job, event jobs.WebhookEvent) {
+	if job.CallbackURL == "" || d.Webhooks == nil {
+		return
+	}
+	// One ID per job and event type, so a redelivered task repeats the
+	// ID and receivers can drop the duplicate.
+	event.ID = job.ID + ":" + event.Type
+	event.JobID = job.ID
+	event.FileID = job.FileID
+	event.CreatedAt = time.Now()
+
+	attempts, err := d.Webhooks.Deliver(ctx, job.CallbackURL, event)
+	if err != nil {
+		log.Printf("notifyWebhook: %s: %v", job.ID, err)
+	}
+	if len(attempts) == 0 {
+		return
+	}
+	if err := d.Store.AddWebhookAttempts(ctx, job.ID, attempts); err != nil {
+		log.Printf("notifyWebhook: record attempts %s: %v", job.ID, err)
 	}
 }
@@ -0,0 +1,963 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/middleware"
+)
+
+// --- mocks ---
+
+type mockJobStore struct {
+	jobs map[string]*jobs.Job
+}
+
+func newMockJobStore(js ...*jobs.Job) *mockJobStore {
+	s := &mockJobStore{jobs: map[string]*jobs.Job{}}
+	for _, j := range js {
+		s.jobs[j.ID] = j
+	}
+	return s
+}
+
+func (s *mockJobStore) Create(_ context.Context, job *jobs.Job) error {
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *mockJobStore) Get(_ context.Context, jobID string) (*jobs.Job, error) {
+	j, ok := s.jobs[jobID]
+	if !ok {
+		return nil, fmt.Errorf("job %s not found", jobID)
+	}
+	return j, nil
+}
+
+func (s *mockJobStore) SetProcessing(_ context.Context, jobID string) error {
+	s.jobs[jobID].Status = jobs.JobStatusProcessing
+	return nil
+}
+
+func (s *mockJobStore) SetCompleted(_ context.Context, jobID string, result *jobs.ProcessResult) error {
+	j := s.jobs[jobID]
+	j.Status = jobs.JobStatusCompleted
+	j.AudioURL = result.AudioURL
+	j.AudioPath = result.AudioPath
+	j.Timepoints = result.Timepoints
+	j.DurationSeconds = result.DurationSeconds
+	j.PCMSHA256 = result.PCMSHA256
+	j.PCMBytes = result.PCMBytes
+	j.PeaksURL = result.PeaksURL
+	j.Chapters = result.Chapters
+	j.OutputLUFS = result.OutputLUFS
+	j.LoudnessApprox = result.LoudnessApproximate
+	return nil
+}
+
+func (s *mockJobStore) SetFailed(_ context.Context, jobID, errMsg string) error {
+	s.jobs[jobID].Status = jobs.JobStatusFailed
+	s.jobs[jobID].ErrorMsg = errMsg
+	return nil
+}
+
+func (s *mockJobStore) AddWebhookAttempts(_ context.Context, jobID string, attempts []jobs.WebhookAttempt) error {
+	s.jobs[jobID].WebhookAttempts = append(s.jobs[jobID].WebhookAttempts, attempts...)
+	return nil
+}
+
+// mockWebhooks records delivered events and reports one attempt for each.
+type mockWebhooks struct {
+	urls   []string
+	events []jobs.WebhookEvent
+}
+
+func (m *mockWebhooks) Deliver(_ context.Context, callbackURL string, event jobs.WebhookEvent) ([]jobs.WebhookAttempt, error) {
+	m.urls = append(m.urls, callbackURL)
+	m.events = append(m.events, event)
+	return []jobs.WebhookAttempt{{EventID: event.ID, At: time.Now(), StatusCode: http.StatusOK}}, nil
+}
+
+// mockNotifier records sent push notifications and reports the tokens in
+// dead as unregistered.
+type mockNotifier struct {
+	sent []sentNotification
+	dead []string
+}
+
+type sentNotification struct {
+	tokens []string
+	jobs.Push
+}
+
+func (m *mockNotifier) Send(_ context.Context, deviceTokens []string, push jobs.Push) ([]string, error) {
+	m.sent = append(m.sent, sentNotification{deviceTokens, push})
+	var unregistered []string
+	for _, t := range deviceTokens {
+		if slices.Contains(m.dead, t) {
+			unregistered = append(unregistered, t)
+		}
+	}
+	return unregistered, nil
+}
+
+// mockDeviceTokenStore is an in-memory DeviceTokenStore.
+type mockDeviceTokenStore struct {
+	tokens map[string][]string
+}
+
+func (m *mockDeviceTokenStore) List(_ context.Context, ownerID string) ([]string, error) {
+	return m.tokens[ownerID], nil
+}
+
+func (m *mockDeviceTokenStore) Add(_ context.Context, ownerID, token string) error {
+	if !slices.Contains(m.tokens[ownerID], token) {
+		m.tokens[ownerID] = append(m.tokens[ownerID], token)
+	}
+	return nil
+}
+
+func (m *mockDeviceTokenStore) Remove(_ context.Context, ownerID string, tokens ...string) error {
+	m.tokens[ownerID] = slices.DeleteFunc(m.tokens[ownerID], func(t string) bool { return slices.Contains(tokens, t) })
+	return nil
+}
+
+// mockMailer records sent emails by recipient.
+type mockMailer struct {
+	sent map[string]jobs.Email
+}
+
+func (m *mockMailer) Send(_ context.Context, to string, email jobs.Email) error {
+	m.sent[to] = email
+	return nil
+}
+
+type mockUsageStore struct {
+	usage map[string]*jobs.Usage
+}
+
+func newMockUsageStore(us ...*jobs.Usage) *mockUsageStore {
+	m := &mockUsageStore{usage: map[string]*jobs.Usage{}}
+	for _, u := range us {
+		m.usage[u.OwnerID] = u
+	}
+	return m
+}
+
+func (m *mockUsageStore) Get(_ context.Context, ownerID string) (*jobs.Usage, error) {
+	if u, ok := m.usage[ownerID]; ok {
+		return u, nil
+	}
+	return &jobs.Usage{OwnerID: ownerID, Tier: config.TierFree}, nil
+}
+
+func (m *mockUsageStore) Add(_ context.Context, ownerID string, bytes int64, seconds float64) error {
+	u, _ := m.Get(context.Background(), ownerID)
+	u.BytesStored += bytes
+	u.AudioSeconds += seconds
+	u.JobCount++
+	m.usage[ownerID] = u
+	return nil
+}
+
+type mockQueue struct {
+	enqueued []string
+}
+
+func (q *mockQueue) Enqueue(_ context.Context, jobID string) error {
+	q.enqueued = append(q.enqueued, jobID)
+	return nil
+}
+
+// mockTTSGenerator returns one second of 16kHz mono silence per call.
+type mockTTSGenerator struct{}
+
+func (mockTTSGenerator) Generate(_ context.Context, _ string, _ *config.VoiceOption, _ string) ([]byte, []jobs.TTSTimepoint, error) {
+	const sampleRate, pcmSize = 16000, 32000
+	data := make([]byte, 44+pcmSize)
+	copy(data[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(data[4:8], 36+pcmSize)
+	copy(data[8:16], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(data[16:20], 16)
+	binary.LittleEndian.PutUint16(data[20:22], 1)
+	binary.LittleEndian.PutUint16(data[22:24], 1)
+	binary.LittleEndian.PutUint32(data[24:28], sampleRate)
+	binary.LittleEndian.PutUint32(data[28:32], sampleRate*2)
+	binary.LittleEndian.PutUint16(data[32:34], 2)
+	binary.LittleEndian.PutUint16(data[34:36], 16)
+	copy(data[36:40], "data")
+	binary.LittleEndian.PutUint32(data[40:44], pcmSize)
+	return data, []jobs.TTSTimepoint{{MarkName: "0:0:1", TimeSeconds: 0.1}}, nil
+}
+
+// memAudioStorage is an in-memory ReadableAudioStorage.
+type memAudioStorage struct {
+	objects map[string][]byte
+}
+
+func newMemAudioStorage() *memAudioStorage {
+	return &memAudioStorage{objects: map[string][]byte{}}
+}
+
+func (m *memAudioStorage) Upload(_ context.Context, data []byte, filename string, _ jobs.UploadOptions) (string, error) {
+	m.objects[filename] = data
+	return "https://storage.example.com/" + filename, nil
+}
+
+func (m *memAudioStorage) Stat(_ context.Context, filename string) (*jobs.AudioObjectInfo, error) {
+	data, ok := m.objects[filename]
+	if !ok {
+		return nil, jobs.ErrAudioNotFound
+	}
+	return &jobs.AudioObjectInfo{
+		Size:        int64(len(data)),
+		ContentType: "audio/wav",
+		ETag:        fmt.Sprintf("etag-%d", len(data)),
+		Updated:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func (m *memAudioStorage) OpenRange(_ context.Context, filename string, offset, length int64) (io.ReadCloser, error) {
+	data, ok := m.objects[filename]
+	if !ok {
+		return nil, jobs.ErrAudioNotFound
+	}
+	end := int64(len(data))
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+	return io.NopCloser(bytes.NewReader(data[offset:end])), nil
+}
+
+// --- GetJobAudioHandler ---
+
+func newAudioTestDeps() (*JobDeps, []byte) {
+	audio := make([]byte, 1000)
+	for i := range audio {
+		audio[i] = byte(i)
+	}
+	storage := newMemAudioStorage()
+	storage.objects["audio/jobs/done.wav"] = audio
+	store := newMockJobStore(
+		&jobs.Job{ID: "done", OwnerID: "user-1", Status: jobs.JobStatusCompleted, AudioPath: "audio/jobs/done.wav"},
+		&jobs.Job{ID: "pending", OwnerID: "user-1", Status: jobs.JobStatusPending},
+		&jobs.Job{ID: "gone", OwnerID: "user-1", Status: jobs.JobStatusCompleted, AudioPath: "audio/jobs/gone.wav"},
+	)
+	return &JobDeps{Store: store, Storage: storage}, audio
+}
+
+// serveAudio requests a job's audio as user-1, the owner of the test jobs.
+func serveAudio(d *JobDeps, method, jobID string, header http.Header) *httptest.ResponseRecorder {
+	return serveAudioAs(d, "user-1", method, jobID, header)
+}
+
+func serveAudioAs(d *JobDeps, caller, method, jobID string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/jobs/"+jobID+"/audio", nil)
+	req.SetPathValue("jobId", jobID)
+	if caller != "" {
+		req = middleware.WithUser(req, caller)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	d.GetJobAudioHandler(w, req)
+	return w
+}
+
+func TestGetJobAudioHandler_FullBody(t *testing.T) {
+	d, audio := newAudioTestDeps()
+	w := serveAudio(d, http.MethodGet, "done", nil)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "audio/wav" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := w.Header().Get("Content-Length"); got != "1000" {
+		t.Errorf("Content-Length = %q", got)
+	}
+	if got := w.Header().Get("ETag"); got != `"etag-1000"` {
+		t.Errorf("ETag = %q", got)
+	}
+	if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Accept-Ranges = %q", got)
+	}
+	if !bytes.Equal(w.Body.Bytes(), audio) {
+		t.Error("body does not match stored audio")
+	}
+}
+
+func TestGetJobAudioHandler_Range(t *testing.T) {
+	d, audio := newAudioTestDeps()
+	w := serveAudio(d, http.MethodGet, "done", http.Header{"Range": {"bytes=100-199"}})
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", w.Code)
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 100-199/1000" {
+		t.Errorf("Content-Range = %q", got)
+	}
+	if !bytes.Equal(w.Body.Bytes(), audio[100:200]) {
+		t.Error("range body mismatch")
+	}
+}
+
+func TestGetJobAudioHandler_SuffixRange(t *testing.T) {
+	d, audio := newAudioTestDeps()
+	w := serveAudio(d, http.MethodGet, "done", http.Header{"Range": {"bytes=-10"}})
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", w.Code)
+	}
+	if !bytes.Equal(w.Body.Bytes(), audio[990:]) {
+		t.Error("suffix range body mismatch")
+	}
+}
+
+func TestGetJobAudioHandler_UnsatisfiableRange(t *testing.T) {
+	d, _ := newAudioTestDeps()
+	w := serveAudio(d, http.MethodGet, "done", http.Header{"Range": {"bytes=5000-6000"}})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("status = %d, want 416", w.Code)
+	}
+}
+
+func TestGetJobAudioHandler_IfNoneMatch(t *testing.T) {
+	d, _ := newAudioTestDeps()
+	w := serveAudio(d, http.MethodGet, "done", http.Header{"If-None-Match": {`"etag-1000"`}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("status = %d, want 304", w.Code)
+	}
+}
+
+func TestGetJobAudioHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		jobID      string
+		wantStatus int
+	}{
+		{"method not allowed", http.MethodPost, "done", http.StatusMethodNotAllowed},
+		{"unknown job", http.MethodGet, "missing", http.StatusNotFound},
+		{"job not completed", http.MethodGet, "pending", http.StatusConflict},
+		{"object missing", http.MethodGet, "gone", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _ := newAudioTestDeps()
+			w := serveAudio(d, tt.method, tt.jobID, nil)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestGetJobAudioHandler_OwnerOnly(t *testing.T) {
+	d, _ := newAudioTestDeps()
+	if w := serveAudioAs(d, "", http.MethodGet, "done", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: status = %d, want 401", w.Code)
+	}
+	if w := serveAudioAs(d, "user-2", http.MethodGet, "done", nil); w.Code != http.StatusForbidden || w.Body.Len() > 100 {
+		t.Errorf("another user: status = %d, want 403 without the audio", w.Code)
+	}
+}
+
+// --- quotas ---
+
+func postJSON(handler http.HandlerFunc, target, body string) *httptest.ResponseRecorder {
+	return postJSONAs(handler, "", target, body)
+}
+
+// postJSONAs posts body as the signed-in user uid, or anonymously when empty.
+func postJSONAs(handler http.HandlerFunc, uid, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	if uid != "" {
+		req = middleware.WithUser(req, uid)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestCreateJobHandler_QuotaExceeded(t *testing.T) {
+	free := config.QuotaTiers[config.TierFree]
+	usage := newMockUsageStore(&jobs.Usage{OwnerID: "user-1", Tier: config.TierFree, AudioSeconds: free.MaxAudioSeconds})
+	queue := &mockQueue{}
+	d := &JobDeps{Store: newMockJobStore(), Queue: queue, Storage: newMemAudioStorage(), Usage: usage}
+
+	w := postJSONAs(d.CreateJobHandler, "user-1", "/jobs", `{"text":"こんにちは"}`)
+
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("status = %d, want 402", w.Code)
+	}
+	var resp QuotaExceededResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Error != "quota_exceeded" || resp.Resource != "audioSeconds" || resp.Tier != config.TierFree {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if len(queue.enqueued) != 0 {
+		t.Error("job should not be enqueued when quota is exceeded")
+	}
+
+	// The account comes from the ID token, never from the body, and an
+	// anonymous job cannot dodge the check.
+	for _, body := range []string{`{"text":"こんにちは"}`, `{"text":"こんにちは","deviceToken":"fcm-token"}`} {
+		if w := postJSON(d.CreateJobHandler, "/jobs", body); w.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous %s: status = %d, want 401", body, w.Code)
+		}
+	}
+	usage.usage["user-2"] = &jobs.Usage{OwnerID: "user-2", Tier: config.TierFree}
+	if w := postJSONAs(d.CreateJobHandler, "user-1", "/jobs", `{"text":"こんにちは","ownerId":"user-2"}`); w.Code != http.StatusPaymentRequired {
+		t.Errorf("ownerId in body: status = %d, want 402", w.Code)
+	}
+	if len(queue.enqueued) != 0 {
+		t.Error("job should not be enqueued without a signed-in owner under quota")
+	}
+}
+
+func TestCreateJobHandler_QuotaByOutputFormat(t *testing.T) {
+	// 800 characters is ~100 s: 4.8 MB as WAV but only 0.8 MB as 64 kbps MP3.
+	free := config.QuotaTiers[config.TierFree]
+	usage := newMockUsageStore(&jobs.Usage{OwnerID: "user-1", Tier: config.TierFree, BytesStored: free.MaxBytesStored - 1_000_000})
+	d := &JobDeps{Store: newMockJobStore(), Queue: &mockQueue{}, Storage: newMemAudioStorage(), Usage: usage}
+
+	text := strings.Repeat("あ", 800)
+	if w := postJSONAs(d.CreateJobHandler, "user-1", "/jobs", `{"text":"`+text+`"}`); w.Code != http.StatusPaymentRequired {
+		t.Errorf("wav: status = %d, want 402", w.Code)
+	}
+	if w := postJSONAs(d.CreateJobHandler, "user-1", "/jobs", `{"text":"`+text+`","outputFormat":"mp3","bitrate":64}`); w.Code != http.StatusAccepted {
+		t.Errorf("mp3: status = %d, want 202", w.Code)
+	}
+}
+
+func TestCreateJobHandler_PremiumWithinQuota(t *testing.T) {
+	free := config.QuotaTiers[config.TierFree]
+	usage := newMockUsageStore(&jobs.Usage{OwnerID: "user-1", Tier: config.TierPremium, AudioSeconds: free.MaxAudioSeconds})
+	queue := &mockQueue{}
+	d := &JobDeps{Store: newMockJobStore(), Queue: queue, Storage: newMemAudioStorage(), Usage: usage}
+
+	w := postJSONAs(d.CreateJobHandler, "user-1", "/jobs", `{"text":"こんにちは"}`)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	if len(queue.enqueued) != 1 {
+		t.Errorf("expected 1 enqueued job, got %d", len(queue.enqueued))
+	}
+}
+
+func TestCreateJobHandler_OutputFormat(t *testing.T) {
+	store := newMockJobStore()
+	d := &JobDeps{Store: store, Queue: &mockQueue{}, Storage: newMemAudioStorage()}
+
+	w := postJSON(d.CreateJobHandler, "/jobs", `{"text":"こんにちは","outputFormat":"mp3"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	var resp CreateJobResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	job := store.jobs[resp.JobID]
+	if job.OutputFormat != jobs.OutputFormatMP3 || job.Bitrate != 64 {
+		t.Errorf("format = %q bitrate = %d, want mp3 at 64 kbps", job.OutputFormat, job.Bitrate)
+	}
+
+	for _, body := range []string{
+		`{"text":"こんにちは","outputFormat":"ogg"}`,
+		`{"text":"こんにちは","outputFormat":"mp3","bitrate":100}`,
+		`{"text":"こんにちは","bitrate":64}`,
+	} {
+		if w := postJSON(d.CreateJobHandler, "/jobs", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, w.Code)
+		}
+	}
+}
+
+func TestCreateJobHandler_HLSPlaylist(t *testing.T) {
+	store := newMockJobStore()
+	storage := newMemAudioStorage()
+	d := &JobDeps{Store: store, Queue: &mockQueue{}, Storage: storage}
+
+	w := postJSON(d.CreateJobHandler, "/jobs", `{"text":"こんにちは","outputFormat":"hls"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	var resp CreateJobResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	path := jobs.HLSPlaylistPath(resp.JobID)
+	if want := "https://storage.example.com/" + path; resp.PlaylistURL != want || store.jobs[resp.JobID].PlaylistURL != want {
+		t.Errorf("playlistUrl = %q (job %q), want %q", resp.PlaylistURL, store.jobs[resp.JobID].PlaylistURL, want)
+	}
+	// The playlist exists before processing starts, with no segments yet.
+	playlist := string(storage.objects[path])
+	if !strings.Contains(playlist, "#EXT-X-PLAYLIST-TYPE:EVENT") || strings.Contains(playlist, "#EXTINF") || strings.Contains(playlist, "#EXT-X-ENDLIST") {
+		t.Errorf("unexpected initial playlist:\n%s", playlist)
+	}
+}
+
+func TestCreateJobHandler_PostProcessing(t *testing.T) {
+	store := newMockJobStore()
+	d := &JobDeps{Store: store, Queue: &mockQueue{}, Storage: newMemAudioStorage()}
+
+	w := postJSON(d.CreateJobHandler, "/jobs", `{"text":"こんにちは","pauses":{"paragraphMs":800,"chunkMs":200},"loudness":{"targetLufs":-16},"renderSpeed":1.25,"background":{"path":"audio/backgrounds/rain.wav"}}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	var resp CreateJobResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if p := store.jobs[resp.JobID].Pauses; p == nil || p.ParagraphMs != 800 || p.ChunkMs != 200 {
+		t.Errorf("pauses = %+v, want paragraph 800ms and chunk 200ms", p)
+	}
+	if l := store.jobs[resp.JobID].Loudness; l == nil || l.TargetLUFS != -16 {
+		t.Errorf("loudness = %+v, want target -16 LUFS", l)
+	}
+	if s := store.jobs[resp.JobID].RenderSpeed; s != 1.25 {
+		t.Errorf("renderSpeed = %v, want 1.25", s)
+	}
+	if b := store.jobs[resp.JobID].Background; b == nil || b.Path != "audio/backgrounds/rain.wav" {
+		t.Errorf("background = %+v, want audio/backgrounds/rain.wav", b)
+	}
+
+	for _, body := range []string{
+		`{"text":"こんにちは","pauses":{"paragraphMs":-1}}`,
+		`{"text":"こんにちは","pauses":{"headingMs":60000}}`,
+		`{"text":"こんにちは","loudness":{"targetLufs":0}}`,
+		`{"text":"こんにちは","trimSilence":{"thresholdDb":-5}}`,
+		`{"text":"こんにちは","trimSilence":{"keepMs":-1}}`,
+		`{"text":"こんにちは","crossfadeMs":500}`,
+		`{"text":"こんにちは","renderSpeed":0.2}`,
+		`{"text":"こんにちは","renderSpeed":4}`,
+		`{"text":"こんにちは","background":{"path":"audio/jobs/other.wav"}}`,
+		`{"text":"こんにちは","background":{"path":"audio/backgrounds/../jobs/other.wav"}}`,
+		`{"text":"こんにちは","background":{"path":"audio/backgrounds/rain.wav","gainDb":6}}`,
+		`{"text":"こんにちは","loudness":{"targetLufs":-16,"truePeakDbtp":1}}`,
+	} {
+		if w := postJSON(d.CreateJobHandler, "/jobs", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, w.Code)
+		}
+	}
+}
+
+func TestCreateJobHandler_Chapters(t *testing.T) {
+	store := newMockJobStore()
+	d := &JobDeps{Store: store, Queue: &mockQueue{}, Storage: newMemAudioStorage()}
+
+	w := postJSON(d.CreateJobHandler, "/jobs", `{"text":"序章です。第一章です。","chapters":[{"title":"序章","charOffset":0},{"title":"第一章","charOffset":5}]}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	var resp CreateJobResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if c := store.jobs[resp.JobID].Chapters; len(c) != 2 || c[1].Title != "第一章" || c[1].CharOffset != 5 {
+		t.Errorf("chapters = %+v, want 序章@0 and 第一章@5", c)
+	}
+
+	for _, body := range []string{
+		`{"text":"序章です。","chapters":[{"title":"序章","charOffset":5}]}`,
+		`{"text":"序章です。","chapters":[{"title":"","charOffset":0}]}`,
+		`{"text":"序章です。第一章です。","chapters":[{"title":"b","charOffset":5},{"title":"a","charOffset":0}]}`,
+	} {
+		if w := postJSON(d.CreateJobHandler, "/jobs", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, w.Code)
+		}
+	}
+}
+
+func TestProcessJobHandler_RecordsUsage(t *testing.T) {
+	store := newMockJobStore(&jobs.Job{ID: "job-1", Text: "こんにちは", VoiceID: "ja-jp-female-a", Language: "ja-JP", OwnerID: "user-1"})
+	usage := newMockUsageStore()
+	d := &JobDeps{Store: store, Gen: mockTTSGenerator{}, Storage: newMemAudioStorage(), Usage: usage}
+
+	w := postJSON(d.ProcessJobHandler, "/jobs/process", `{"jobId":"job-1"}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if store.jobs["job-1"].Status != jobs.JobStatusCompleted {
+		t.Fatalf("job status = %s", store.jobs["job-1"].Status)
+	}
+	if !strings.HasSuffix(store.jobs["job-1"].PeaksURL, ".peaks.json") {
+		t.Errorf("peaksUrl = %q, want a .peaks.json sidecar", store.jobs["job-1"].PeaksURL)
+	}
+	u := usage.usage["user-1"]
+	if u == nil {
+		t.Fatal("usage not recorded")
+	}
+	if u.BytesStored != 44+32000 || u.AudioSeconds != 1 || u.JobCount != 1 {
+		t.Errorf("unexpected usage: %+v", u)
+	}
+}
+
+func TestProcessJobHandler_RedeliveryNotBilledTwice(t *testing.T) {
+	store := newMockJobStore(&jobs.Job{ID: "job-1", Text: "こんにちは", VoiceID: "ja-jp-female-a", Language: "ja-JP", OwnerID: "user-1"})
+	usage := newMockUsageStore()
+	d := &JobDeps{Store: store, Gen: mockTTSGenerator{}, Storage: newMemAudioStorage(), Usage: usage}
+
+	for range 2 {
+		if w := postJSON(d.ProcessJobHandler, "/jobs/process", `{"jobId":"job-1"}`); w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+	}
+	if u := usage.usage["user-1"]; u == nil || u.JobCount != 1 || u.AudioSeconds != 1 {
+		t.Errorf("usage = %+v, want one job billed", u)
+	}
+}
+
+func TestCreateJobHandler_CallbackURL(t *testing.T) {
+	store := newMockJobStore()
+	d := &JobDeps{Store: store, Queue: &mockQueue{}, Storage: newMemAudioStorage()}
+
+	body := `{"text":"こんにちは","callbackUrl":"https://example.com/hooks/tts"}`
+	if w := postJSON(d.CreateJobHandler, "/jobs", body); w.Code != http.StatusNotImplemented {
+		t.Errorf("without webhooks: status = %d, want 501", w.Code)
+	}
+
+	d.Webhooks = &mockWebhooks{}
+	w := postJSON(d.CreateJobHandler, "/jobs", body)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	var resp CreateJobResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if got := store.jobs[resp.JobID].CallbackURL; got != "https://example.com/hooks/tts" {
+		t.Errorf("callbackUrl = %q", got)
+	}
+
+	for _, u := range []string{"http://example.com/hook", "https://user:pw@example.com/hook", "/hook"} {
+		body := fmt.Sprintf(`{"text":"こんにちは","callbackUrl":%q}`, u)
+		if w := postJSON(d.CreateJobHandler, "/jobs", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", u, w.Code)
+		}
+	}
+}
+
+func TestProcessJobHandler_Webhook(t *testing.T) {
+	store := newMockJobStore(
+		&jobs.Job{ID: "job-1", Text: "こんにちは", VoiceID: "ja-jp-female-a", Language: "ja-JP", FileID: "file-1", CallbackURL: "https://example.com/a"},
+		&jobs.Job{ID: "job-2", Text: "こんにちは", VoiceID: "no-such-voice", CallbackURL: "https://example.com/b"},
+		&jobs.Job{ID: "job-3", Text: "こんにちは", VoiceID: "ja-jp-female-a", Language: "ja-JP"},
+	)
+	hooks := &mockWebhooks{}
+	d := &JobDeps{Store: store, Gen: mockTTSGenerator{}, Storage: newMemAudioStorage(), Webhooks: hooks}
+
+	for _, id := range []string{"job-1", "job-2", "job-3"} {
+		if w := postJSON(d.ProcessJobHandler, "/jobs/process", fmt.Sprintf(`{"jobId":%q}`, id)); w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", id, w.Code)
+		}
+	}
+
+	if len(hooks.events) != 2 {
+		t.Fatalf("delivered %d events, want 2 (job-3 has no callbackUrl)", len(hooks.events))
+	}
+	done, failed := hooks.events[0], hooks.events[1]
+	if hooks.urls[0] != "https://example.com/a" || done.Type != jobs.WebhookJobCompleted || done.JobID != "job-1" ||
+		done.FileID != "file-1" || done.AudioURL == "" || done.DurationSeconds != 1 || done.ID == "" {
+		t.Errorf("completed event = %+v to %s", done, hooks.urls[0])
+	}
+	if hooks.urls[1] != "https://example.com/b" || failed.Type != jobs.WebhookJobFailed || failed.Status != jobs.JobStatusFailed || failed.Error == "" {
+		t.Errorf("failed event = %+v to %s", failed, hooks.urls[1])
+	}
+	if a := store.jobs["job-1"].WebhookAttempts; len(a) != 1 || a[0].EventID != done.ID || a[0].StatusCode != http.StatusOK {
+		t.Errorf("job-1 attempts = %+v", a)
+	}
+	if a := store.jobs["job-2"].WebhookAttempts; len(a) != 1 || a[0].EventID != failed.ID {
+		t.Errorf("job-2 attempts = %+v", a)
+	}
+}
+
+func TestProcessJobHandler_WebhookRedeliverySharesID(t *testing.T) {
+	store := newMockJobStore(&jobs.Job{ID: "job-2", Text: "こんにちは", VoiceID: "no-such-voice", CallbackURL: "https://example.com/b"})
+	hooks := &mockWebhooks{}
+	d := &JobDeps{Store: store, Gen: mockTTSGenerator{}, Storage: newMemAudioStorage(), Webhooks: hooks}
+
+	// Cloud Tasks may deliver the same task twice.
+	for range 2 {
+		postJSON(d.ProcessJobHandler, "/jobs/process", `{"jobId":"job-2"}`)
+	}
+
+	if len(hooks.events) != 2 {
+		t.Fatalf("delivered %d events, want 2", len(hooks.events))
+	}
+	if a, b := hooks.events[0].ID, hooks.events[1].ID; a != b || a != "job-2:"+jobs.WebhookJobFailed {
+		t.Errorf("event IDs %q and %q, want both %q", a, b, "job-2:"+jobs.WebhookJobFailed)
+	}
+}
+
+func TestProcessJobHandler_LocalizedNotification(t *testing.T) {
+	store := newMockJobStore(
+		&jobs.Job{ID: "job-1", Text: "Hallo", VoiceID: "ja-jp-female-a", Language: "ja-JP", DeviceToken: "tok-1", Locale: "de-DE", Title: "Bericht"},
+		&jobs.Job{ID: "job-2", Text: "こんにちは", VoiceID: "no-such-voice", Language: "ja-JP", DeviceToken: "tok-2"},
+		&jobs.Job{ID: "job-3", Text: "hello", VoiceID: "en-us-female-a", Language: "en-US", DeviceToken: "tok-3", Locale: "sw"},
+	)
+	notifier := &mockNotifier{}
+	d := &JobDeps{Store: store, Gen: mockTTSGenerator{}, Storage: newMemAudioStorage(), Notifier: notifier}
+
+	for _, id := range []string{"job-1", "job-2", "job-3"} {
+		postJSON(d.ProcessJobHandler, "/jobs/process", fmt.Sprintf(`{"jobId":%q}`, id))
+	}
+
+	want := []sentNotification{
+		{[]string{"tok-1"}, jobs.Push{Title: "Audio fertig", Body: "Die Audiodatei für „Bericht“ ist fertig."}},
+		{[]string{"tok-2"}, jobs.Push{Title: "音声生成失敗", Body: "音声の生成に失敗しました"}},
+		{[]string{"tok-3"}, jobs.Push{Title: "Audio ready", Body: "Your text-to-speech audio is ready."}},
+	}
+	if len(notifier.sent) != len(want) {
+		t.Fatalf("sent %d notifications, want %d", len(notifier.sent), len(want))
+	}
+	for i, w := range want {
+		if got := notifier.sent[i]; !slices.Equal(got.tokens, w.tokens) || got.Title != w.Title || got.Body != w.Body {
+			t.Errorf("notification %d = %q %q to %v, want %q %q to %v", i, got.Title, got.Body, got.tokens, w.Title, w.Body, w.tokens)
+		}
+	}
+}
+
+func TestProcessJobHandler_NotifiesOwnerDevices(t *testing.T) {
+	store := newMockJobStore(&jobs.Job{ID: "job-1", Text: "こんにちは", VoiceID: "ja-jp-female-a", Language: "ja-JP", OwnerID: "user-1", DeviceToken: "iphone"})
+	devices := &mockDeviceTokenStore{tokens: map[string][]string{
+		"user-1": {"iphone", "ipad", "old-phone"},
+		"user-2": {"old-phone"},
+	}}
+	notifier := &mockNotifier{dead: []string{"old-phone"}}
+	d := &JobDeps{Store: store, Gen: mockTTSGenerator{}, Storage: newMemAudioStorage(), Notifier: notifier, Devices: devices}
+
+	postJSON(d.ProcessJobHandler, "/jobs/process", `{"jobId":"job-1"}`)
+
+	if len(notifier.sent) != 1 {
+		t.Fatalf("sent %d notifications, want one multicast", len(notifier.sent))
+	}
+	if got := notifier.sent[0].tokens; !slices.Equal(got, []string{"iphone", "ipad", "old-phone"}) {
+		t.Errorf("tokens = %v, want the submitting device once plus the owner's others", got)
+	}
+	if got := devices.tokens["user-1"]; !slices.Equal(got, []string{"iphone", "ipad"}) {
+		t.Errorf("user-1 tokens = %v, want the unregistered one removed", got)
+	}
+	if got := devices.tokens["user-2"]; len(got) != 1 {
+		t.Errorf("user-2 tokens = %v, want them untouched", got)
+	}
+}
+
+func TestProcessJobHandler_PushOptions(t *testing.T) {
+	text := strings.Repeat("あ。", 400)
+	if n := len(jobs.SplitText(text, jobs.MaxChunkBytes)); n < 3 {
+		t.Fatalf("text splits into %d chunks, want at least 3", n)
+	}
+	store := newMockJobStore(&jobs.Job{ID: "job-1", Text: text, VoiceID: "ja-jp-female-a", Language: "ja-JP", FileID: "file-1", DeviceToken: "iphone"})
+	notifier := &mockNotifier{}
+	d := &JobDeps{Store: store, Gen: mockTTSGenerator{}, Storage: newMemAudioStorage(), Notifier: notifier}
+
+	postJSON(d.ProcessJobHandler, "/jobs/process", `{"jobId":"job-1"}`)
+
+	// One progress push for the first chunk, throttled after that, then the alert.
+	if len(notifier.sent) != 2 {
+		t.Fatalf("sent %d pushes, want progress and completion", len(notifier.sent))
+	}
+	progress, alert := notifier.sent[0], notifier.sent[1]
+	if !progress.Silent || progress.Title != "" || progress.Data["status"] != "processing" || progress.Data["chunk"] != "1" ||
+		progress.CollapseKey != "progress-job-1" || progress.TTL != progressPushInterval {
+		t.Errorf("progress push = %+v", progress.Push)
+	}
+	if alert.Silent || alert.Title == "" || alert.Data["status"] != "completed" ||
+		alert.CollapseKey != "job-job-1" || alert.ThreadID != "file-1" || alert.TTL != alertTTL {
+		t.Errorf("completion push = %+v", alert.Push)
+	}
+	if progress.CollapseKey == alert.CollapseKey {
+		t.Error("a late progress push would replace the completion alert")
+	}
+}
+
+func TestCreateJobHandler_NotifyEmail(t *testing.T) {
+	store := newMockJobStore()
+	d := &JobDeps{Store: store, Queue: &mockQueue{}, Storage: newMemAudioStorage()}
+
+	body := `{"text":"hello","notifyEmail":"reader@example.com"}`
+	if w := postJSON(d.CreateJobHandler, "/jobs", body); w.Code != http.StatusNotImplemented {
+		t.Errorf("without a mailer: status = %d, want 501", w.Code)
+	}
+
+	d.Mailer = &mockMailer{}
+	w := postJSON(d.CreateJobHandler, "/jobs", body)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	var resp CreateJobResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if got := store.jobs[resp.JobID].NotifyEmail; got != "reader@example.com" {
+		t.Errorf("notifyEmail = %q", got)
+	}
+
+	for _, addr := range []string{"reader", "Reader <reader@example.com>", "a@example.com\r\nBcc: b@example.com"} {
+		if w := postJSON(d.CreateJobHandler, "/jobs", `{"text":"hello","notifyEmail":"`+addr+`"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", addr, w.Code)
+		}
+	}
+}
+
+func TestProcessJobHandler_NotifyEmail(t *testing.T) {
+	store := newMockJobStore(
+		&jobs.Job{ID: "job-1", Text: "hello", VoiceID: "en-us-female-a", Language: "en-US", Title: "Notes", NotifyEmail: "a@example.com"},
+		&jobs.Job{ID: "job-2", Text: "hallo", VoiceID: "no-such-voice", Locale: "de", NotifyEmail: "b@example.com"},
+		&jobs.Job{ID: "job-3", Text: "hello", VoiceID: "en-us-female-a", Language: "en-US"},
+	)
+	mailer := &mockMailer{sent: map[string]jobs.Email{}}
+	d := &JobDeps{Store: store, Gen: mockTTSGenerator{}, Storage: newMemAudioStorage(), Mailer: mailer}
+
+	for _, id := range []string{"job-1", "job-2", "job-3"} {
+		postJSON(d.ProcessJobHandler, "/jobs/process", fmt.Sprintf(`{"jobId":%q}`, id))
+	}
+
+	if len(mailer.sent) != 2 {
+		t.Fatalf("sent %d emails, want 2", len(mailer.sent))
+	}
+	done := mailer.sent["a@example.com"]
+	if done.Subject != "Audio ready" || !strings.Contains(done.Body, `"Notes"`) ||
+		!strings.Contains(done.Body, "Listen: "+store.jobs["job-1"].AudioURL) || !strings.Contains(done.Body, "Duration: 0:01") {
+		t.Errorf("completion email = %+v", done)
+	}
+	if failed := mailer.sent["b@example.com"]; failed.Subject != "Audioerstellung fehlgeschlagen" || strings.Contains(failed.Body, "Anhören") {
+		t.Errorf("failure email = %+v", failed)
+	}
+}
+
+func TestDeviceTokensHandler(t *testing.T) {
+	devices := &mockDeviceTokenStore{tokens: map[string][]string{}}
+	d := &JobDeps{Store: newMockJobStore(), Devices: devices}
+	do := func(method, uid, body string) int {
+		req := httptest.NewRequest(method, "/deviceTokens", strings.NewReader(body))
+		if uid != "" {
+			req = middleware.WithUser(req, uid)
+		}
+		w := httptest.NewRecorder()
+		d.DeviceTokensHandler(w, req)
+		return w.Code
+	}
+
+	for _, token := range []string{"iphone", "ipad", "iphone"} {
+		if code := do(http.MethodPost, "user-1", `{"token":"`+token+`"}`); code != http.StatusNoContent {
+			t.Errorf("register %s: status = %d, want 204", token, code)
+		}
+	}
+	if got := devices.tokens["user-1"]; !slices.Equal(got, []string{"iphone", "ipad"}) {
+		t.Errorf("tokens = %v", got)
+	}
+	if code := do(http.MethodDelete, "user-1", `{"token":"iphone"}`); code != http.StatusNoContent {
+		t.Errorf("unregister: status = %d, want 204", code)
+	}
+	if got := devices.tokens["user-1"]; !slices.Equal(got, []string{"ipad"}) {
+		t.Errorf("tokens after delete = %v", got)
+	}
+
+	// The owner is the signed-in caller; an ownerId in the body is ignored
+	// and anonymous callers cannot register at all.
+	if code := do(http.MethodPost, "user-2", `{"ownerId":"user-1","token":"attacker"}`); code != http.StatusNoContent {
+		t.Errorf("ownerId in body: status = %d, want 204", code)
+	}
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		if code := do(method, "", `{"token":"ipad"}`); code != http.StatusUnauthorized {
+			t.Errorf("anonymous %s: status = %d, want 401", method, code)
+		}
+	}
+	if got := devices.tokens["user-1"]; !slices.Equal(got, []string{"ipad"}) {
+		t.Errorf("user-1 tokens after other callers = %v", got)
+	}
+	if got := devices.tokens["user-2"]; !slices.Equal(got, []string{"attacker"}) {
+		t.Errorf("user-2 tokens = %v", got)
+	}
+
+	for i := range MaxDeviceTokensPerOwner - 1 {
+		devices.Add(context.Background(), "user-2", fmt.Sprint("tok-", i))
+	}
+	if code := do(http.MethodPost, "user-2", `{"token":"one-more"}`); code != http.StatusConflict {
+		t.Errorf("over the cap: status = %d, want 409", code)
+	}
+	if code := do(http.MethodPost, "user-2", `{"token":"tok-0"}`); code != http.StatusNoContent {
+		t.Errorf("re-register at the cap: status = %d, want 204", code)
+	}
+
+	for _, body := range []string{`{"token":""}`, `{}`, `{"token":"` + strings.Repeat("x", 5000) + `"}`, `{`} {
+		if code := do(http.MethodPost, "user-1", body); code != http.StatusBadRequest {
+			t.Errorf("%.30s: status = %d, want 400", body, code)
+		}
+	}
+	if code := do(http.MethodGet, "user-1", ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status = %d, want 405", code)
+	}
+
+	w := httptest.NewRecorder()
+	(&JobDeps{}).DeviceTokensHandler(w, middleware.WithUser(httptest.NewRequest(http.MethodPost, "/deviceTokens", strings.NewReader(`{"token":"iphone"}`)), "user-1"))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("without a store: status = %d, want 501", w.Code)
+	}
+}
+
+func TestCreateJobHandler_Locale(t *testing.T) {
+	store := newMockJobStore()
+	d := &JobDeps{Store: store, Queue: &mockQueue{}, Storage: newMemAudioStorage()}
+
+	w := postJSON(d.CreateJobHandler, "/jobs", `{"text":"hello","voiceId":"en-us-female-a","locale":"ko-KR"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	var resp CreateJobResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if got := store.jobs[resp.JobID].Locale; got != "ko-KR" {
+		t.Errorf("locale = %q, want ko-KR", got)
+	}
+
+	if w := postJSON(d.CreateJobHandler, "/jobs", `{"text":"hello","locale":"ko KR"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid locale: status = %d, want 400", w.Code)
+	}
+}
+
+func TestUsageHandler(t *testing.T) {
+	usage := newMockUsageStore(
+		&jobs.Usage{OwnerID: "user-1", Tier: config.TierPremium, BytesStored: 1234, AudioSeconds: 5},
+		&jobs.Usage{OwnerID: "user-2", Tier: config.TierFree, BytesStored: 99},
+	)
+	d := &JobDeps{Usage: usage}
+
+	// ownerId in the query is ignored: callers only ever see their own usage
+	req := middleware.WithUser(httptest.NewRequest(http.MethodGet, "/usage?ownerId=user-2", nil), "user-1")
+	w := httptest.NewRecorder()
+	d.UsageHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var resp struct {
+		OwnerID     string             `json:"ownerId"`
+		Tier        string             `json:"tier"`
+		BytesStored int64              `json:"bytesStored"`
+		Limits      config.QuotaLimits `json:"limits"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.OwnerID != "user-1" || resp.BytesStored != 1234 || resp.Limits != config.QuotaTiers[config.TierPremium] {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	for _, target := range []string{"/usage", "/usage?ownerId=user-1"} {
+		req = httptest.NewRequest(http.MethodGet, target, nil)
+		w = httptest.NewRecorder()
+		d.UsageHandler(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous %s: status = %d, want 401", target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+// ndjsonContentType selects the NDJSON form of POST /synthesize/stream
+// when present in the Accept header.
+const ndjsonContentType = "application/x-ndjson"
+
+// Trailers of a raw /synthesize/stream response. They are only sent once
+// the whole text has been synthesized, or synthesis failed after the audio
+// had started. Delivery is best-effort: HTTP/1.1 proxies and load balancers
+// (Cloud Run's front end among them) may drop trailers.
+const (
+	timepointsTrailer     = "X-Timepoints"      // JSON array of timepoints for the whole text
+	synthesisErrorTrailer = "X-Synthesis-Error" // set when the audio stopped early
+)
+
+// SynthesizeStreamRequest is the request body for POST /synthesize/stream.
+type SynthesizeStreamRequest struct {
+	Text     string `json:"text"`
+	VoiceID  string `json:"voiceId"`
+	Language string `json:"language"`
+	Encoding string `json:"encoding"` // "wav" (default) or "pcm" for headerless samples
+}
+
+// StreamAudioFormat describes the samples of a /synthesize/stream response.
+type StreamAudioFormat struct {
+	SampleRate    int `json:"sampleRate"`
+	Channels      int `json:"channels"`
+	BitsPerSample int `json:"bitsPerSample"`
+}
+
+// SynthesizeStreamEvent is one line of an NDJSON /synthesize/stream
+// response. Every chunk of text yields one event with its audio and
+// timepoints; the last line has Done or Error set.
+type SynthesizeStreamEvent struct {
+	Format     *StreamAudioFormat  `json:"format,omitempty"`     // first event only
+	Audio      []byte              `json:"audio,omitempty"`      // base64; the first event's starts with the WAV header
+	Timepoints []jobs.TTSTimepoint `json:"timepoints,omitempty"` // relative to the start of the whole stream
+	Done       bool                `json:"done,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// SynthesizeStreamHandler handles POST /synthesize/stream.
+// Unlike GenerateAudioTTSHandler it uploads nothing: each SplitText chunk is
+// written to the response as soon as it is synthesized, so playback can
+// start after the first chunk.
+//
+// By default the body is the audio itself (a WAV header with placeholder
+// sizes followed by the samples, or bare little-endian PCM for "pcm"), with
+// the timepoints in the X-Timepoints trailer on a best-effort basis.
+// Clients that need timepoints or failure reports should send
+// Accept: application/x-ndjson to get the audio and its timepoints
+// interleaved as SynthesizeStreamEvent lines instead.
+func (d *JobDeps) SynthesizeStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SynthesizeStreamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if req.Text == "" {
+		http.Error(w, `{"error":"text is required"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Text) > maxTextLength {
+		http.Error(w, fmt.Sprintf(`{"error":"text too long, maximum %d characters allowed"}`, maxTextLength), http.StatusBadRequest)
+		return
+	}
+
+	withHeader := true
+	switch req.Encoding {
+	case "", "wav":
+	case "pcm":
+		withHeader = false
+	default:
+		http.Error(w, `{"error":"invalid encoding"}`, http.StatusBadRequest)
+		return
+	}
+
+	if req.VoiceID == "" {
+		req.VoiceID = "en-us-female-a"
+	}
+	voice := config.GetVoiceByID(req.VoiceID)
+	if voice == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponseWithVoices{
+			Error:           "Invalid voice ID",
+			AvailableVoices: config.GetPublicVoices(),
+		})
+		return
+	}
+	language := req.Language
+	if language == "" {
+		language = voice.Language
+	}
+
+	ndjson := acceptsNDJSON(r)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	started := false
+	var timepoints []jobs.TTSTimepoint
+
+	// The status is only committed with the first chunk, so a request that
+	// fails before any audio still gets a plain error response.
+	err := jobs.SynthesizeStream(r.Context(), req.Text, voice, language, d.Gen, func(f wav.Format, pcm []byte, tps []jobs.TTSTimepoint) error {
+		var event SynthesizeStreamEvent
+		if !started {
+			h := w.Header()
+			h.Set("Cache-Control", "no-store")
+			h.Set("X-Sample-Rate", strconv.Itoa(f.SampleRate))
+			h.Set("X-Channels", strconv.Itoa(f.Channels))
+			h.Set("X-Bits-Per-Sample", strconv.Itoa(f.BitsPerSample))
+			switch {
+			case ndjson:
+				h.Set("Content-Type", ndjsonContentType)
+			case withHeader:
+				h.Set("Content-Type", "audio/wav")
+			default:
+				h.Set("Content-Type", "application/octet-stream")
+			}
+			if !ndjson {
+				h.Set("Trailer", timepointsTrailer+", "+synthesisErrorTrailer)
+			}
+			w.WriteHeader(http.StatusOK)
+			started = true
+
+			event.Format = &StreamAudioFormat{SampleRate: f.SampleRate, Channels: f.Channels, BitsPerSample: f.BitsPerSample}
+			if withHeader {
+				event.Audio = wav.StreamingHeader(f)
+			}
+		}
+		event.Audio = append(event.Audio, pcm...)
+
+		if ndjson {
+			event.Timepoints = tps
+			if err := enc.Encode(event); err != nil {
+				return err
+			}
+		} else {
+			timepoints = append(timepoints, tps...)
+			if _, err := w.Write(event.Audio); err != nil {
+				return err
+			}
+		}
+		return rc.Flush()
+	})
+
+	if err != nil {
+		log.Printf("synthesize/stream: %v", err)
+		switch {
+		case !started:
+			http.Error(w, fmt.Sprintf(`{"error":"failed to generate audio","message":%q}`, err.Error()), http.StatusInternalServerError)
+		case ndjson:
+			enc.Encode(SynthesizeStreamEvent{Error: err.Error()})
+		default:
+			w.Header().Set(synthesisErrorTrailer, err.Error())
+		}
+		return
+	}
+
+	if ndjson {
+		enc.Encode(SynthesizeStreamEvent{Done: true})
+		return
+	}
+	if timepoints == nil {
+		timepoints = []jobs.TTSTimepoint{}
+	}
+	data, _ := json.Marshal(timepoints)
+	w.Header().Set(timepointsTrailer, string(data))
+}
+
+// acceptsNDJSON reports whether the Accept header lists the NDJSON type.
+func acceptsNDJSON(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mt, _, err := mime.ParseMediaType(strings.TrimSpace(v)); err == nil && mt == ndjsonContentType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+// twoChunkText splits into two SplitText chunks, the second starting at
+// secondChunkMark's character.
+var (
+	twoChunkText    = strings.Repeat("あ。", 200)
+	secondChunk     = jobs.SplitText(twoChunkText, jobs.MaxChunkBytes)[1].CharOffset
+	secondChunkMark = fmt.Sprintf("0:%d:%d", secondChunk, secondChunk+1)
+)
+
+// flakyTTSGenerator succeeds for the first ok calls and fails after that.
+type flakyTTSGenerator struct{ ok int }
+
+func (g *flakyTTSGenerator) Generate(ctx context.Context, text string, voice *config.VoiceOption, language string) ([]byte, []jobs.TTSTimepoint, error) {
+	if g.ok == 0 {
+		return nil, nil, errors.New("tts unavailable")
+	}
+	g.ok--
+	return mockTTSGenerator{}.Generate(ctx, text, voice, language)
+}
+
+func postStream(d *JobDeps, body, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/synthesize/stream", strings.NewReader(body))
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	d.SynthesizeStreamHandler(w, req)
+	return w
+}
+
+func TestSynthesizeStreamHandler_WAV(t *testing.T) {
+	d := &JobDeps{Gen: mockTTSGenerator{}}
+	w := postStream(d, `{"text":"`+twoChunkText+`","voiceId":"ja-jp-female-a"}`, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "audio/wav" {
+		t.Errorf("Content-Type = %q, want audio/wav", ct)
+	}
+	if !w.Flushed {
+		t.Error("response was not flushed while streaming")
+	}
+	info, err := wav.Parse(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if info.DataOffset != wav.HeaderSize || info.DataLength != 64000 {
+		t.Errorf("data at %d+%d, want 44+64000 (two 1 s chunks)", info.DataOffset, info.DataLength)
+	}
+
+	trailer := w.Result().Trailer
+	if e := trailer.Get(synthesisErrorTrailer); e != "" {
+		t.Errorf("unexpected error trailer %q", e)
+	}
+	var tps []jobs.TTSTimepoint
+	if err := json.Unmarshal([]byte(trailer.Get(timepointsTrailer)), &tps); err != nil {
+		t.Fatalf("timepoints trailer: %v", err)
+	}
+	want := []jobs.TTSTimepoint{{MarkName: "0:0:1", TimeSeconds: 0.1}, {MarkName: secondChunkMark, TimeSeconds: 1.1}}
+	if len(tps) != 2 || tps[0] != want[0] || tps[1].MarkName != want[1].MarkName || tps[1].TimeSeconds != want[1].TimeSeconds {
+		t.Errorf("timepoints = %+v, want %+v", tps, want)
+	}
+}
+
+func TestSynthesizeStreamHandler_PCM(t *testing.T) {
+	d := &JobDeps{Gen: mockTTSGenerator{}}
+	w := postStream(d, `{"text":"こんにちは","encoding":"pcm"}`, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if w.Body.Len() != 32000 {
+		t.Errorf("body = %d bytes, want 32000 bytes of bare PCM", w.Body.Len())
+	}
+	if r, c, b := w.Header().Get("X-Sample-Rate"), w.Header().Get("X-Channels"), w.Header().Get("X-Bits-Per-Sample"); r != "16000" || c != "1" || b != "16" {
+		t.Errorf("format headers = %s/%s/%s, want 16000/1/16", r, c, b)
+	}
+}
+
+func TestSynthesizeStreamHandler_NDJSON(t *testing.T) {
+	d := &JobDeps{Gen: mockTTSGenerator{}}
+	w := postStream(d, `{"text":"`+twoChunkText+`"}`, "audio/wav, application/x-ndjson")
+	if ct := w.Header().Get("Content-Type"); ct != ndjsonContentType {
+		t.Fatalf("Content-Type = %q, want %s", ct, ndjsonContentType)
+	}
+
+	var events []SynthesizeStreamEvent
+	sc := bufio.NewScanner(w.Body)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var e SynthesizeStreamEvent
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("bad line %q: %v", sc.Text(), err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != 3 || !events[2].Done {
+		t.Fatalf("got %d events, want two chunks and done", len(events))
+	}
+	if f := events[0].Format; f == nil || f.SampleRate != 16000 || events[1].Format != nil {
+		t.Errorf("format = %+v, %+v; want 16 kHz on the first event only", events[0].Format, events[1].Format)
+	}
+	if len(events[0].Audio) != wav.HeaderSize+32000 || len(events[1].Audio) != 32000 {
+		t.Errorf("audio = %d, %d bytes; want header+32000, 32000", len(events[0].Audio), len(events[1].Audio))
+	}
+	if tps := events[1].Timepoints; len(tps) != 1 || tps[0].MarkName != secondChunkMark {
+		t.Errorf("second chunk timepoints = %+v", tps)
+	}
+}
+
+func TestSynthesizeStreamHandler_Errors(t *testing.T) {
+	// Before any audio the status can still report the failure.
+	w := postStream(&JobDeps{Gen: &flakyTTSGenerator{}}, `{"text":"こんにちは"}`, "")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+
+	// After the first chunk the failure goes in the trailer or last event.
+	w = postStream(&JobDeps{Gen: &flakyTTSGenerator{ok: 1}}, `{"text":"`+twoChunkText+`"}`, "")
+	if w.Code != http.StatusOK || w.Result().Trailer.Get(synthesisErrorTrailer) == "" {
+		t.Errorf("status = %d, error trailer %q; want 200 with an error trailer", w.Code, w.Result().Trailer.Get(synthesisErrorTrailer))
+	}
+	w = postStream(&JobDeps{Gen: &flakyTTSGenerator{ok: 1}}, `{"text":"`+twoChunkText+`"}`, ndjsonContentType)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	var last SynthesizeStreamEvent
+	json.Unmarshal([]byte(lines[len(lines)-1]), &last)
+	if len(lines) != 2 || last.Error == "" || last.Done {
+		t.Errorf("got %d lines ending in %+v, want one chunk then an error", len(lines), last)
+	}
+
+	d := &JobDeps{Gen: mockTTSGenerator{}}
+	for _, body := range []string{
+		`{"text":""}`,
+		`{"text":"こんにちは","encoding":"mp3"}`,
+		`{"text":"こんにちは","voiceId":"nope"}`,
+	} {
+		if w := postStream(d, body, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, w.Code)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+// MaxTTSSessionsPerUser caps concurrent /synthesize/session connections per
+// signed-in user on one instance.
+const MaxTTSSessionsPerUser = 4
+
+const (
+	// sessionQueue is how many received messages may wait for synthesis.
+	// Once it is full the session stops reading, so a client typing faster
+	// than speech is synthesized is held back by TCP flow control.
+	sessionQueue = 8
+	// sessionFrame is the duration of PCM carried by one binary message.
+	sessionFrame = 100 * time.Millisecond
+	// sessionWriteTimeout drops clients that stop reading.
+	sessionWriteTimeout = 10 * time.Second
+	// sessionPing and sessionPongWait detect dead connections while idle.
+	sessionPing     = 30 * time.Second
+	sessionPongWait = 2 * sessionPing
+)
+
+var sessionUpgrader = websocket.Upgrader{
+	// The endpoint is behind the API key, not cookies, and CORS allows
+	// every origin, so there is nothing for an origin check to protect.
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// TTSSessionMessage is a JSON text message sent by the client on a
+// /synthesize/session WebSocket.
+type TTSSessionMessage struct {
+	Type     string `json:"type"`               // "text" or "voice"
+	Text     string `json:"text,omitempty"`     // text: a sentence to speak
+	VoiceID  string `json:"voiceId,omitempty"`  // voice: used for the following sentences
+	Language string `json:"language,omitempty"` // voice: defaults to the voice's language
+}
+
+// TTSSessionEvent is a JSON text message sent by the server. Each "text"
+// message gets the next Seq and is answered in order by "start", then a
+// "timepoints" event and the binary PCM messages for each synthesized
+// chunk, then "end"; or by a single "error".
+type TTSSessionEvent struct {
+	Type            string                    `json:"type"` // "voice", "start", "timepoints", "end" or "error"
+	Seq             int                       `json:"seq,omitempty"`
+	Voice           *config.PublicVoiceOption `json:"voice,omitempty"`      // voice
+	Language        string                    `json:"language,omitempty"`   // voice
+	Format          *StreamAudioFormat        `json:"format,omitempty"`     // start: format of the binary messages that follow
+	Timepoints      []jobs.TTSTimepoint       `json:"timepoints,omitempty"` // character offsets within the sentence, times from its start
+	DurationSeconds float64                   `json:"durationSeconds,omitempty"`
+	Error           string                    `json:"error,omitempty"`
+}
+
+// sessionVoice is the voice a session currently speaks with.
+type sessionVoice struct {
+	voice    *config.VoiceOption
+	language string
+}
+
+// sessionItem is a received message queued for the synthesis loop.
+type sessionItem struct {
+	msg TTSSessionMessage
+	seq int    // text messages only
+	err string // set when the message was rejected on receipt
+}
+
+// TTSSessionHandler handles GET /synthesize/session, a WebSocket for
+// "read as I type": the client sends sentences as they are written and
+// gets each one back as PCM frames plus word timepoints. The initial voice
+// comes from the voiceId and language query parameters and can be switched
+// at any point with a "voice" message.
+func (d *JobDeps) TTSSessionHandler(w http.ResponseWriter, r *http.Request) {
+	voiceID := r.URL.Query().Get("voiceId")
+	if voiceID == "" {
+		voiceID = "en-us-female-a"
+	}
+	voice := config.GetVoiceByID(voiceID)
+	if voice == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponseWithVoices{
+			Error:           "Invalid voice ID",
+			AvailableVoices: config.GetPublicVoices(),
+		})
+		return
+	}
+	current := sessionVoice{voice: voice, language: r.URL.Query().Get("language")}
+	if current.language == "" {
+		current.language = voice.Language
+	}
+
+	conn, err := sessionUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader has already replied
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	queue := make(chan sessionItem, sessionQueue)
+	go readSession(ctx, cancel, conn, queue)
+
+	send := func(event TTSSessionEvent) error {
+		conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+		return conn.WriteJSON(event)
+	}
+	if err := send(current.event()); err != nil {
+		return
+	}
+
+	ping := time.NewTicker(sessionPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(sessionWriteTimeout)); err != nil {
+				return
+			}
+		case item, ok := <-queue:
+			if !ok {
+				return
+			}
+			var err error
+			switch {
+			case item.err != "":
+				err = send(TTSSessionEvent{Type: "error", Seq: item.seq, Error: item.err})
+			case item.msg.Type == "voice":
+				err = switchSessionVoice(&current, item.msg, send)
+			default:
+				err = d.speakSession(ctx, conn, current, item, send)
+			}
+			if err != nil {
+				log.Printf("synthesize/session: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// readSession reads client messages into queue until the connection fails
+// or closes, then cancels the session. Sending blocks while queue is full,
+// which is the session's backpressure.
+func readSession(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, queue chan<- sessionItem) {
+	defer cancel()
+	defer close(queue)
+	conn.SetReadLimit(2 * maxTextLength)
+	conn.SetReadDeadline(time.Now().Add(sessionPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(sessionPongWait))
+	})
+
+	seq := 0
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var item sessionItem
+		if err := json.Unmarshal(data, &item.msg); err != nil {
+			item.err = "invalid message"
+		}
+		conn.SetReadDeadline(time.Now().Add(sessionPongWait))
+
+		if item.err == "" {
+			switch item.msg.Type {
+			case "text":
+				seq++
+				item.seq = seq
+				if item.msg.Text == "" {
+					item.err = "text is required"
+				} else if len(item.msg.Text) > maxTextLength {
+					item.err = fmt.Sprintf("text too long, maximum %d characters allowed", maxTextLength)
+				}
+			case "voice":
+			default:
+				item.err = fmt.Sprintf("unknown message type %q", item.msg.Type)
+			}
+		}
+
+		select {
+		case queue <- item:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// switchSessionVoice applies a "voice" message, or reports an error and
+// keeps the current voice.
+func switchSessionVoice(current *sessionVoice, msg TTSSessionMessage, send func(TTSSessionEvent) error) error {
+	voice := config.GetVoiceByID(msg.VoiceID)
+	if voice == nil {
+		return send(TTSSessionEvent{Type: "error", Error: fmt.Sprintf("invalid voiceId %q", msg.VoiceID)})
+	}
+	*current = sessionVoice{voice: voice, language: msg.Language}
+	if current.language == "" {
+		current.language = voice.Language
+	}
+	return send(current.event())
+}
+
+// speakSession synthesizes one sentence and streams it to the client.
+// Synthesis errors are reported to the client; only write errors, which
+// end the session, are returned.
+func (d *JobDeps) speakSession(ctx context.Context, conn *websocket.Conn, current sessionVoice, item sessionItem, send func(TTSSessionEvent) error) error {
+	var writeErr error
+	var format *wav.Format
+	var pcmBytes int64
+	err := jobs.SynthesizeStream(ctx, item.msg.Text, current.voice, current.language, d.Gen, func(f wav.Format, pcm []byte, tps []jobs.TTSTimepoint) error {
+		if format == nil {
+			format = &f
+			start := &StreamAudioFormat{SampleRate: f.SampleRate, Channels: f.Channels, BitsPerSample: f.BitsPerSample}
+			if writeErr = send(TTSSessionEvent{Type: "start", Seq: item.seq, Format: start}); writeErr != nil {
+				return writeErr
+			}
+		}
+		if len(tps) > 0 {
+			if writeErr = send(TTSSessionEvent{Type: "timepoints", Seq: item.seq, Timepoints: tps}); writeErr != nil {
+				return writeErr
+			}
+		}
+		frame := max(int(sessionFrame.Seconds()*float64(f.SampleRate))*f.BlockAlign, f.BlockAlign)
+		for len(pcm) > 0 {
+			n := min(frame, len(pcm))
+			conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+			if writeErr = conn.WriteMessage(websocket.BinaryMessage, pcm[:n]); writeErr != nil {
+				return writeErr
+			}
+			pcmBytes += int64(n)
+			pcm = pcm[n:]
+		}
+		return nil
+	})
+	switch {
+	case writeErr != nil:
+		return writeErr
+	case ctx.Err() != nil:
+		return nil // the client went away
+	case err != nil:
+		log.Printf("synthesize/session: seq %d: %v", item.seq, err)
+		return send(TTSSessionEvent{Type: "error", Seq: item.seq, Error: "failed to generate audio"})
+	}
+	return send(TTSSessionEvent{Type: "end", Seq: item.seq, DurationSeconds: format.Duration(pcmBytes)})
+}
+
+// event describes the voice to the client.
+func (v sessionVoice) event() TTSSessionEvent {
+	public := v.voice.ToPublic()
+	return TTSSessionEvent{Type: "voice", Voice: &public, Language: v.language}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/middleware"
+)
+
+func dialSession(t *testing.T, srv *httptest.Server, query string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/synthesize/session" + query
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if conn != nil {
+		t.Cleanup(func() { conn.Close() })
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	}
+	return conn, resp, err
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) TTSSessionEvent {
+	t.Helper()
+	var e TTSSessionEvent
+	if err := conn.ReadJSON(&e); err != nil {
+		t.Fatalf("read event: %v", err)
+	}
+	return e
+}
+
+// readSentence reads the messages answering one text message and returns
+// its events and the number of PCM bytes received.
+func readSentence(t *testing.T, conn *websocket.Conn) (events []TTSSessionEvent, pcm int) {
+	t.Helper()
+	for {
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if typ == websocket.BinaryMessage {
+			pcm += len(data)
+			continue
+		}
+		var e TTSSessionEvent
+		if err := json.Unmarshal(data, &e); err != nil {
+			t.Fatalf("bad event %q: %v", data, err)
+		}
+		events = append(events, e)
+		if e.Type == "end" || e.Type == "error" {
+			return events, pcm
+		}
+	}
+}
+
+func TestTTSSessionHandler(t *testing.T) {
+	d := &JobDeps{Gen: mockTTSGenerator{}}
+	srv := httptest.NewServer(http.HandlerFunc(d.TTSSessionHandler))
+	defer srv.Close()
+
+	conn, _, err := dialSession(t, srv, "?voiceId=ja-jp-female-a")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if e := readEvent(t, conn); e.Type != "voice" || e.Voice.ID != "ja-jp-female-a" || e.Language != "ja-JP" {
+		t.Fatalf("first event = %+v, want the initial voice", e)
+	}
+
+	conn.WriteJSON(TTSSessionMessage{Type: "text", Text: "こんにちは。"})
+	events, pcm := readSentence(t, conn)
+	if len(events) != 3 || events[0].Type != "start" || events[1].Type != "timepoints" || events[2].Type != "end" {
+		t.Fatalf("events = %+v, want start, timepoints, end", events)
+	}
+	if f := events[0].Format; f == nil || f.SampleRate != 16000 || events[0].Seq != 1 {
+		t.Errorf("start = %+v, want seq 1 at 16 kHz", events[0])
+	}
+	if tps := events[1].Timepoints; len(tps) != 1 || tps[0].MarkName != "0:0:1" {
+		t.Errorf("timepoints = %+v", tps)
+	}
+	if pcm != 32000 || events[2].DurationSeconds != 1 {
+		t.Errorf("got %d PCM bytes, duration %v; want 32000 and 1 s", pcm, events[2].DurationSeconds)
+	}
+
+	// Switching voices applies to later sentences; a bad voice keeps the old one.
+	conn.WriteJSON(TTSSessionMessage{Type: "voice", VoiceID: "nope"})
+	if e := readEvent(t, conn); e.Type != "error" {
+		t.Errorf("bad voice: event = %+v, want error", e)
+	}
+	conn.WriteJSON(TTSSessionMessage{Type: "voice", VoiceID: "en-us-male-b"})
+	if e := readEvent(t, conn); e.Type != "voice" || e.Voice.ID != "en-us-male-b" || e.Language != "en-US" {
+		t.Errorf("switch: event = %+v, want en-us-male-b", e)
+	}
+
+	// Rejected messages use up their seq and the session carries on.
+	conn.WriteJSON(TTSSessionMessage{Type: "text"})
+	conn.WriteMessage(websocket.TextMessage, []byte("{"))
+	conn.WriteJSON(TTSSessionMessage{Type: "text", Text: "Hello."})
+	if e := readEvent(t, conn); e.Type != "error" || e.Seq != 2 {
+		t.Errorf("empty text: event = %+v, want error for seq 2", e)
+	}
+	if e := readEvent(t, conn); e.Type != "error" {
+		t.Errorf("malformed message: event = %+v, want error", e)
+	}
+	if events, _ := readSentence(t, conn); events[0].Seq != 3 || events[len(events)-1].Type != "end" {
+		t.Errorf("events = %+v, want seq 3 to complete", events)
+	}
+}
+
+func TestTTSSessionHandler_Limits(t *testing.T) {
+	d := &JobDeps{Gen: mockTTSGenerator{}}
+	limited := middleware.ConcurrencyLimit(1, d.TTSSessionHandler)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limited(w, middleware.WithUser(r, "user-1"))
+	}))
+	defer srv.Close()
+
+	if _, resp, err := dialSession(t, srv, "?voiceId=nope"); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid voice: err %v, want a 400 handshake failure", err)
+	}
+
+	conn, _, err := dialSession(t, srv, "")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	readEvent(t, conn)
+	if _, resp, err := dialSession(t, srv, ""); err == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("second session: err %v, want a 429 handshake failure", err)
+	}
+}
@@ -0,0 +1,82 @@
+// Package id3 writes the small subset of ID3v2.4 the service needs: text
+// frames, PRIV frames (HLS timestamps) and chapters (CHAP with a CTOC table
+// of contents), as a tag placed in front of MP3 data.
+package id3
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Frame is one ID3v2.4 frame.
+type Frame struct {
+	ID   string // four characters, e.g. "TIT2"
+	Body []byte
+}
+
+// Tag returns an ID3v2.4 tag containing frames.
+func Tag(frames ...Frame) []byte {
+	body := appendFrames(nil, frames)
+	tag := append([]byte("ID3\x04\x00\x00"), syncsafe(len(body))...)
+	return append(tag, body...)
+}
+
+func appendFrames(b []byte, frames []Frame) []byte {
+	for _, f := range frames {
+		b = append(b, f.ID...)
+		b = append(b, syncsafe(len(f.Body))...)
+		b = append(b, 0, 0) // flags
+		b = append(b, f.Body...)
+	}
+	return b
+}
+
+// syncsafe encodes n in the 4-byte, 7-bits-per-byte form ID3v2.4 uses for
+// sizes.
+func syncsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// Text returns a UTF-8 text information frame such as TIT2 (title).
+func Text(id, s string) Frame {
+	return Frame{ID: id, Body: append([]byte{3}, s...)}
+}
+
+// PRIV returns a private frame identified by owner.
+func PRIV(owner string, data []byte) Frame {
+	return Frame{ID: "PRIV", Body: append(append([]byte(owner), 0), data...)}
+}
+
+// Chapter is one entry of a chapter list.
+type Chapter struct {
+	Title      string
+	Start, End time.Duration
+}
+
+// Chapters returns a CTOC frame listing the chapters in order, followed by
+// one CHAP frame per chapter with its title as a TIT2 sub-frame. Players
+// with chapter support (Apple Podcasts, VLC, most audiobook apps) show them
+// as navigation points.
+func Chapters(chapters []Chapter) []Frame {
+	if len(chapters) == 0 {
+		return nil
+	}
+	ctoc := []byte("toc\x00")
+	ctoc = append(ctoc, 0x03, byte(len(chapters))) // top-level, ordered; entry count
+	frames := []Frame{{ID: "CTOC"}}
+	for i, c := range chapters {
+		id := fmt.Sprintf("chp%d", i)
+		ctoc = append(append(ctoc, id...), 0)
+
+		chap := append([]byte(id), 0)
+		chap = binary.BigEndian.AppendUint32(chap, uint32(c.Start.Milliseconds()))
+		chap = binary.BigEndian.AppendUint32(chap, uint32(c.End.Milliseconds()))
+		chap = binary.BigEndian.AppendUint32(chap, 0xffffffff) // no byte offsets
+		chap = binary.BigEndian.AppendUint32(chap, 0xffffffff)
+		chap = appendFrames(chap, []Frame{Text("TIT2", c.Title)})
+		frames = append(frames, Frame{ID: "CHAP", Body: chap})
+	}
+	frames[0].Body = ctoc
+	return frames
+}
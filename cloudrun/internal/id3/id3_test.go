@@ -0,0 +1,82 @@
+package id3_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/id3"
+)
+
+// frames splits the body of an ID3v2.4 tag (or a frame's sub-frames) into
+// frames.
+func frames(t *testing.T, b []byte) []id3.Frame {
+	t.Helper()
+	var out []id3.Frame
+	for len(b) >= 10 {
+		size := int(b[4])<<21 | int(b[5])<<14 | int(b[6])<<7 | int(b[7])
+		if 10+size > len(b) {
+			t.Fatalf("frame %q overruns the tag", b[:4])
+		}
+		out = append(out, id3.Frame{ID: string(b[:4]), Body: b[10 : 10+size]})
+		b = b[10+size:]
+	}
+	return out
+}
+
+func TestTag_Header(t *testing.T) {
+	tag := id3.Tag(id3.Text("TIT2", "吾輩は猫である"))
+	if !bytes.HasPrefix(tag, []byte("ID3\x04\x00\x00")) {
+		t.Fatalf("bad header % x", tag[:6])
+	}
+	size := int(tag[6])<<21 | int(tag[7])<<14 | int(tag[8])<<7 | int(tag[9])
+	if size != len(tag)-10 {
+		t.Errorf("tag size %d, want %d", size, len(tag)-10)
+	}
+	f := frames(t, tag[10:])
+	if len(f) != 1 || f[0].ID != "TIT2" || string(f[0].Body) != "\x03吾輩は猫である" {
+		t.Errorf("frames = %q", f)
+	}
+}
+
+func TestTag_LargeSizeIsSyncsafe(t *testing.T) {
+	tag := id3.Tag(id3.PRIV("owner", make([]byte, 300)))
+	for _, b := range tag[6:10] {
+		if b&0x80 != 0 {
+			t.Fatalf("size byte % x has the high bit set", tag[6:10])
+		}
+	}
+	if f := frames(t, tag[10:]); len(f) != 1 || len(f[0].Body) != len("owner")+1+300 {
+		t.Errorf("PRIV frame not sized correctly")
+	}
+}
+
+func TestChapters(t *testing.T) {
+	f := id3.Chapters([]id3.Chapter{
+		{Title: "プロローグ", Start: 0, End: 1500 * time.Millisecond},
+		{Title: "第一章", Start: 1500 * time.Millisecond, End: 62 * time.Second},
+	})
+	if len(f) != 3 || f[0].ID != "CTOC" || f[1].ID != "CHAP" || f[2].ID != "CHAP" {
+		t.Fatalf("frames = %v", f)
+	}
+	if want := "toc\x00\x03\x02chp0\x00chp1\x00"; string(f[0].Body) != want {
+		t.Errorf("CTOC = %q, want %q", f[0].Body, want)
+	}
+
+	chap := f[2].Body
+	if !bytes.HasPrefix(chap, []byte("chp1\x00")) {
+		t.Fatalf("CHAP element ID = %q", chap[:5])
+	}
+	times := chap[5:21]
+	if start, end := binary.BigEndian.Uint32(times), binary.BigEndian.Uint32(times[4:]); start != 1500 || end != 62000 {
+		t.Errorf("CHAP times %d-%d ms, want 1500-62000", start, end)
+	}
+	if sub := frames(t, chap[21:]); len(sub) != 1 || sub[0].ID != "TIT2" || string(sub[0].Body[1:]) != "第一章" {
+		t.Errorf("CHAP sub-frames = %q", sub)
+	}
+
+	if id3.Chapters(nil) != nil {
+		t.Error("expected no frames for no chapters")
+	}
+}
@@ -0,0 +1,101 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Range readers are opened over bounded windows rather than to the end of
+// the object, so a short Range request does not start a download of the
+// rest of a long audiobook. The window doubles while a client keeps reading
+// sequentially and starts small again after each Seek.
+const (
+	minReadWindow = 256 << 10
+	maxReadWindow = 8 << 20
+)
+
+// audioReadSeeker adapts ReadableAudioStorage to io.ReadSeeker so it can be
+// passed to http.ServeContent. A range reader over the next window is opened
+// lazily on Read, so serving a byte range fetches at most that range rounded
+// up to the current window.
+type audioReadSeeker struct {
+	ctx      context.Context
+	storage  ReadableAudioStorage
+	filename string
+	size     int64
+	offset   int64
+	window   int64
+	r        io.ReadCloser
+	end      int64 // end of the window r reads
+}
+
+// NewAudioReadSeeker returns an io.ReadSeekCloser over a stored object of the
+// given size. Callers must Close it to release the underlying reader.
+func NewAudioReadSeeker(ctx context.Context, storage ReadableAudioStorage, filename string, size int64) io.ReadSeekCloser {
+	return &audioReadSeeker{ctx: ctx, storage: storage, filename: filename, size: size, window: minReadWindow}
+}
+
+func (a *audioReadSeeker) Read(p []byte) (int, error) {
+	for {
+		if a.offset >= a.size {
+			return 0, io.EOF
+		}
+		if a.r == nil {
+			length := min(a.window, a.size-a.offset)
+			r, err := a.storage.OpenRange(a.ctx, a.filename, a.offset, length)
+			if err != nil {
+				return 0, err
+			}
+			a.r, a.end = r, a.offset+length
+		}
+		n, err := a.r.Read(p)
+		a.offset += int64(n)
+		if a.offset < a.end || err != nil && err != io.EOF {
+			return n, err
+		}
+		// The window is used up; the next Read opens a larger one.
+		a.r.Close()
+		a.r = nil
+		a.window = min(a.window*2, maxReadWindow)
+		if n > 0 || len(p) == 0 {
+			return n, nil
+		}
+	}
+}
+
+func (a *audioReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = a.offset + offset
+	case io.SeekEnd:
+		abs = a.size + offset
+	default:
+		return 0, errors.New("audioReadSeeker: invalid whence")
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("audioReadSeeker: negative position %d", abs)
+	}
+	if abs != a.offset {
+		if a.r != nil {
+			a.r.Close()
+			a.r = nil
+		}
+		a.window = minReadWindow
+	}
+	a.offset = abs
+	return abs, nil
+}
+
+func (a *audioReadSeeker) Close() error {
+	if a.r == nil {
+		return nil
+	}
+	err := a.r.Close()
+	a.r = nil
+	return err
+}
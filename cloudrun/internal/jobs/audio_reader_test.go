@@ -0,0 +1,67 @@
+package jobs_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+)
+
+// rangeRecorder records the length of every range opened.
+type rangeRecorder struct {
+	backgroundStorage
+	lengths []int64
+}
+
+func (m *rangeRecorder) OpenRange(ctx context.Context, filename string, offset, length int64) (io.ReadCloser, error) {
+	m.lengths = append(m.lengths, length)
+	return m.backgroundStorage.OpenRange(ctx, filename, offset, length)
+}
+
+func TestAudioReadSeeker_BoundedRanges(t *testing.T) {
+	data := make([]byte, 20<<20)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	storage := &rangeRecorder{backgroundStorage: backgroundStorage{objects: map[string][]byte{"a.wav": data}}}
+	r := jobs.NewAudioReadSeeker(context.Background(), storage, "a.wav", int64(len(data)))
+	defer r.Close()
+
+	// A short range far into the object opens a single small window.
+	if _, err := r.Seek(15<<20, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	buf := make([]byte, 100)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if !bytes.Equal(buf, data[15<<20:15<<20+100]) {
+		t.Error("range content mismatch")
+	}
+	if len(storage.lengths) != 1 || storage.lengths[0] < 0 || storage.lengths[0] > 1<<20 {
+		t.Errorf("opened ranges %v, want one bounded window", storage.lengths)
+	}
+
+	// Reading everything still returns the whole object across windows.
+	storage.lengths = nil
+	r.Seek(0, io.SeekStart)
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes, want the %d-byte object", len(got), len(data))
+	}
+	var total int64
+	for _, n := range storage.lengths {
+		if n < 0 {
+			t.Fatalf("opened an unbounded range: %v", storage.lengths)
+		}
+		total += n
+	}
+	if total != int64(len(data)) {
+		t.Errorf("ranges %v cover %d bytes, want %d", storage.lengths, total, len(data))
+	}
+}
@@ -2,11 +2,14 @@ package jobs
 
 import (
 	"context"
-	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 
 	"cloud.google.com/go/storage"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
 )
 
 // GCSAudioStorage implements AudioStorage using Google Cloud Storage.
@@ -26,7 +29,8 @@ func NewGCSAudioStorage(client *storage.Client) *GCSAudioStorage {
 // UploadWAVStreaming streams PCM audio chunks to GCS without holding all data in
 // memory. It:
 //  1. Opens a GCS writer and streams all PCM bytes from fillPCM into a temp object.
-//  2. Writes the 44-byte WAV header (with corrected size fields) to a second temp object.
+//  2. Writes the WAV header (with corrected size fields, RF64 beyond 4 GiB, and
+//     any cue/LIST chunks from the last setHeader) to a second temp object.
 //  3. Composes [header, pcm] → the final WAV object via GCS compose.
 //  4. Sets a public-read ACL on the final object and deletes the temp objects.
 //
@@ -34,6 +38,7 @@ func NewGCSAudioStorage(client *storage.Client) *GCSAudioStorage {
 func (s *GCSAudioStorage) UploadWAVStreaming(
 	ctx context.Context,
 	filename string,
+	opts UploadOptions,
 	fillPCM func(setHeader func([]byte), writePCM func([]byte)) error,
 ) (string, error) {
 	if s.bucketName == "" {
@@ -48,12 +53,11 @@ func (s *GCSAudioStorage) UploadWAVStreaming(
 	pw.ContentType = "application/octet-stream"
 
 	var pcmSize int64
-	var firstHeader []byte
+	var lastHeader []byte
 
 	setHeaderFn := func(h []byte) {
-		if len(h) >= 44 {
-			firstHeader = make([]byte, 44)
-			copy(firstHeader, h[:44])
+		if _, err := wav.Parse(h); err == nil {
+			lastHeader = append(lastHeader[:0], h...)
 		}
 	}
 	writePCMFn := func(data []byte) {
@@ -72,20 +76,23 @@ func (s *GCSAudioStorage) UploadWAVStreaming(
 		bucket.Object(pcmName).Delete(ctx)
 		return "", fmt.Errorf("close PCM GCS writer: %w", err)
 	}
-	if firstHeader == nil {
+	if lastHeader == nil {
 		bucket.Object(pcmName).Delete(ctx)
 		return "", fmt.Errorf("no audio data produced")
 	}
 
-	// --- 2. Build a correct 44-byte WAV header ---
-	binary.LittleEndian.PutUint32(firstHeader[4:8], uint32(36+pcmSize))
-	binary.LittleEndian.PutUint32(firstHeader[40:44], uint32(pcmSize))
+	// --- 2. Build a correct WAV header (RF64 past the 32-bit size limit) ---
+	header, err := wav.ResizeHeader(lastHeader, pcmSize)
+	if err != nil {
+		bucket.Object(pcmName).Delete(ctx)
+		return "", fmt.Errorf("build WAV header: %w", err)
+	}
 
 	hdrName := filename + ".hdr.tmp"
 	hdrObj := bucket.Object(hdrName)
 	hw := hdrObj.NewWriter(ctx)
-	hw.ContentType = "audio/wav" // compose inherits ContentType from first source
-	if _, err := hw.Write(firstHeader); err != nil {
+	hw.ContentType = "audio/wav"
+	if _, err := hw.Write(header); err != nil {
 		hw.Close()
 		bucket.Object(hdrName).Delete(ctx)
 		bucket.Object(pcmName).Delete(ctx)
@@ -99,7 +106,10 @@ func (s *GCSAudioStorage) UploadWAVStreaming(
 
 	// --- 3. Compose [header, pcm] → final WAV object ---
 	finalObj := bucket.Object(filename)
-	if _, err := finalObj.ComposerFrom(hdrObj, pcmObj).Run(ctx); err != nil {
+	composer := finalObj.ComposerFrom(hdrObj, pcmObj)
+	composer.ContentType = contentTypeOrWAV(opts.ContentType)
+	composer.Metadata = opts.Metadata
+	if _, err := composer.Run(ctx); err != nil {
 		bucket.Object(hdrName).Delete(ctx)
 		bucket.Object(pcmName).Delete(ctx)
 		return "", fmt.Errorf("GCS compose WAV: %w", err)
@@ -119,14 +129,16 @@ func (s *GCSAudioStorage) UploadWAVStreaming(
 	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", s.bucketName, filename), nil
 }
 
-func (s *GCSAudioStorage) Upload(ctx context.Context, data []byte, filename string) (string, error) {
+func (s *GCSAudioStorage) Upload(ctx context.Context, data []byte, filename string, opts UploadOptions) (string, error) {
 	if s.bucketName == "" {
 		return "", fmt.Errorf("STORAGE_BUCKET_NAME not set")
 	}
 
 	obj := s.client.Bucket(s.bucketName).Object(filename)
 	w := obj.NewWriter(ctx)
-	w.ContentType = "audio/wav"
+	w.ContentType = contentTypeOrWAV(opts.ContentType)
+	w.Metadata = opts.Metadata
+	w.CacheControl = opts.CacheControl
 
 	if _, err := w.Write(data); err != nil {
 		w.Close()
@@ -142,3 +154,119 @@ func (s *GCSAudioStorage) Upload(ctx context.Context, data []byte, filename stri
 
 	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", s.bucketName, filename), nil
 }
+
+func contentTypeOrWAV(contentType string) string {
+	if contentType == "" {
+		return "audio/wav"
+	}
+	return contentType
+}
+
+// Stat returns size, content type and ETag of a stored object.
+func (s *GCSAudioStorage) Stat(ctx context.Context, filename string) (*AudioObjectInfo, error) {
+	if s.bucketName == "" {
+		return nil, fmt.Errorf("STORAGE_BUCKET_NAME not set")
+	}
+	attrs, err := s.client.Bucket(s.bucketName).Object(filename).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("stat GCS %s: %w", filename, ErrAudioNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("stat GCS %s: %w", filename, err)
+	}
+	return &AudioObjectInfo{
+		Size:        attrs.Size,
+		ContentType: attrs.ContentType,
+		ETag:        attrs.Etag,
+		Updated:     attrs.Updated,
+	}, nil
+}
+
+// OpenRange opens a reader over [offset, offset+length) of a stored object.
+func (s *GCSAudioStorage) OpenRange(ctx context.Context, filename string, offset, length int64) (io.ReadCloser, error) {
+	if s.bucketName == "" {
+		return nil, fmt.Errorf("STORAGE_BUCKET_NAME not set")
+	}
+	r, err := s.client.Bucket(s.bucketName).Object(filename).NewRangeReader(ctx, offset, length)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("open GCS %s: %w", filename, ErrAudioNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open GCS %s at %d: %w", filename, offset, err)
+	}
+	return r, nil
+}
+
+// UploadStreaming streams an encoded object written by fill straight to the
+// final GCS object. If fill supplies a header (known only once the stream is
+// complete), it is written to a temp object and composed in front of the
+// body in place. Metadata (which fill may extend) and a public-read ACL are
+// applied last. If any step fails, the object is deleted.
+func (s *GCSAudioStorage) UploadStreaming(
+	ctx context.Context,
+	filename string,
+	opts UploadOptions,
+	fill func(w io.Writer, setHeader func([]byte)) error,
+) (_ string, err error) {
+	if s.bucketName == "" {
+		return "", fmt.Errorf("STORAGE_BUCKET_NAME not set")
+	}
+	bucket := s.client.Bucket(s.bucketName)
+	obj := bucket.Object(filename)
+	w := obj.NewWriter(ctx)
+	w.ContentType = contentTypeOrWAV(opts.ContentType)
+	defer func() {
+		if err != nil {
+			// Best-effort cleanup of a partial or unpublished object, even
+			// when ctx was cancelled.
+			obj.Delete(context.WithoutCancel(ctx))
+		}
+	}()
+
+	var header []byte
+	setHeaderFn := func(h []byte) {
+		header = append([]byte(nil), h...)
+	}
+	if err := fill(w, setHeaderFn); err != nil {
+		w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("close GCS writer %s: %w", filename, err)
+	}
+
+	if len(header) > 0 {
+		hdrName := filename + ".hdr.tmp"
+		hdrObj := bucket.Object(hdrName)
+		hw := hdrObj.NewWriter(ctx)
+		hw.ContentType = "application/octet-stream"
+		if _, err := hw.Write(header); err != nil {
+			hw.Close()
+			hdrObj.Delete(ctx)
+			return "", fmt.Errorf("write header to GCS: %w", err)
+		}
+		if err := hw.Close(); err != nil {
+			hdrObj.Delete(ctx)
+			return "", fmt.Errorf("close header GCS writer: %w", err)
+		}
+		// The destination may be one of the compose sources, so this prepends in place.
+		composer := obj.ComposerFrom(hdrObj, obj)
+		composer.ContentType = contentTypeOrWAV(opts.ContentType)
+		composer.Metadata = opts.Metadata
+		_, err := composer.Run(ctx)
+		hdrObj.Delete(ctx)
+		if err != nil {
+			return "", fmt.Errorf("GCS compose %s: %w", filename, err)
+		}
+	} else if len(opts.Metadata) > 0 {
+		// Metadata derived from the audio is only known once fill has finished.
+		if _, err := obj.Update(ctx, storage.ObjectAttrsToUpdate{Metadata: opts.Metadata}); err != nil {
+			return "", fmt.Errorf("set metadata %s: %w", filename, err)
+		}
+	}
+	if err := obj.ACL().Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
+		return "", fmt.Errorf("set public ACL %s: %w", filename, err)
+	}
+
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", s.bucketName, filename), nil
+}
@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+// Background tracks are WAV objects in AudioStorage under this prefix, so a
+// job cannot pull in another job's audio.
+const BackgroundPrefix = "audio/backgrounds/"
+
+// MaxBackgroundBytes caps the background track read into memory; it loops,
+// so a minute or two is plenty.
+const MaxBackgroundBytes = 50 << 20
+
+// Defaults and bounds for Background levels, in dB.
+const (
+	defaultBackgroundGainDB = -18.0
+	defaultBackgroundDuckDB = -12.0
+	MinBackgroundGainDB     = -40.0
+	MinBackgroundDuckDB     = -40.0
+)
+
+// Ducking envelope: speech above the threshold pulls the background down
+// quickly and it recovers slowly once the speech stops.
+const (
+	backgroundThresholdDB = -45.0
+	backgroundAttack      = 50 * time.Millisecond
+	backgroundRelease     = 400 * time.Millisecond
+)
+
+// Background loops a stored music or ambience track under the speech,
+// ducked while the narrator is speaking.
+type Background struct {
+	Path   string  `firestore:"path"             json:"path"`             // object name under BackgroundPrefix, e.g. "audio/backgrounds/lullaby.wav"
+	GainDB float64 `firestore:"gainDb,omitempty" json:"gainDb,omitempty"` // background level; 0 means -18 dB
+	DuckDB float64 `firestore:"duckDb,omitempty" json:"duckDb,omitempty"` // further attenuation under speech; 0 means -12 dB
+}
+
+// Validate checks the track lives under BackgroundPrefix and the levels are
+// within range.
+func (b Background) Validate() error {
+	if !strings.HasPrefix(b.Path, BackgroundPrefix) || path.Clean(b.Path) != b.Path || !strings.HasSuffix(b.Path, ".wav") {
+		return fmt.Errorf("background path %q must be a .wav object under %s", b.Path, BackgroundPrefix)
+	}
+	if b.GainDB < MinBackgroundGainDB || b.GainDB > 0 {
+		return fmt.Errorf("gainDb %.1f out of range %.0f to 0", b.GainDB, MinBackgroundGainDB)
+	}
+	if b.DuckDB < MinBackgroundDuckDB || b.DuckDB > 0 {
+		return fmt.Errorf("duckDb %.1f out of range %.0f to 0", b.DuckDB, MinBackgroundDuckDB)
+	}
+	return nil
+}
+
+func (b Background) options() wav.MixOptions {
+	o := wav.MixOptions{
+		GainDB:      b.GainDB,
+		DuckDB:      b.DuckDB,
+		ThresholdDB: backgroundThresholdDB,
+		Attack:      backgroundAttack,
+		Release:     backgroundRelease,
+	}
+	if o.GainDB == 0 {
+		o.GainDB = defaultBackgroundGainDB
+	}
+	if o.DuckDB == 0 {
+		o.DuckDB = defaultBackgroundDuckDB
+	}
+	return o
+}
+
+// loadBackground reads and parses the background track from storage.
+func loadBackground(ctx context.Context, storage AudioStorage, b Background) (wav.Format, []byte, error) {
+	reader, ok := storage.(ReadableAudioStorage)
+	if !ok {
+		return wav.Format{}, nil, fmt.Errorf("background %s: storage is not readable", b.Path)
+	}
+	r, err := reader.OpenRange(ctx, b.Path, 0, -1)
+	if err != nil {
+		return wav.Format{}, nil, fmt.Errorf("background: %w", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, MaxBackgroundBytes+1))
+	if err != nil {
+		return wav.Format{}, nil, fmt.Errorf("background %s: %w", b.Path, err)
+	}
+	if len(data) > MaxBackgroundBytes {
+		return wav.Format{}, nil, fmt.Errorf("background %s larger than %d bytes", b.Path, MaxBackgroundBytes)
+	}
+	info, err := wav.Parse(data)
+	if err != nil {
+		return wav.Format{}, nil, fmt.Errorf("background %s: %w", b.Path, err)
+	}
+	return info.Format, info.Data(data), nil
+}
+
+// mixBackground mixes the looping background under src. The output has the
+// same length as src, so durations and timepoints are unchanged.
+func mixBackground(src pcmSource, bf wav.Format, bed []byte, opts wav.MixOptions) pcmSource {
+	return func(emit func(f wav.Format, pcm []byte) error) error {
+		var m *wav.Mixer
+		return src(func(f wav.Format, pcm []byte) error {
+			if m == nil {
+				var err error
+				if m, err = wav.NewMixer(f, bed, bf, opts); err != nil {
+					return fmt.Errorf("background: %w", err)
+				}
+			}
+			return emit(f, m.Write(pcm))
+		})
+	}
+}
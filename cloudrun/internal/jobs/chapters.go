@@ -0,0 +1,144 @@
+package jobs
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/id3"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+// MaxChapters caps explicit and detected chapters; the MP3 table of
+// contents counts its entries in one byte.
+const MaxChapters = 255
+
+// maxChapterTitle caps chapter titles in bytes.
+const maxChapterTitle = 200
+
+// Chapter marks where a chapter starts in the text and in the audio.
+type Chapter struct {
+	Title       string  `firestore:"title"       json:"title"`
+	CharOffset  int     `firestore:"charOffset"  json:"charOffset"`  // rune offset into the text
+	TimeSeconds float64 `firestore:"timeSeconds" json:"timeSeconds"` // set when the job completes
+}
+
+// ValidateChapters checks chapters supplied with a request: each has a
+// title and they start at strictly increasing offsets inside a text of
+// textLen runes.
+func ValidateChapters(chapters []Chapter, textLen int) error {
+	if len(chapters) > MaxChapters {
+		return fmt.Errorf("%d chapters, at most %d allowed", len(chapters), MaxChapters)
+	}
+	for i, c := range chapters {
+		if c.Title == "" || len(c.Title) > maxChapterTitle {
+			return fmt.Errorf("chapter %d: title must be 1-%d bytes", i, maxChapterTitle)
+		}
+		if c.CharOffset < 0 || c.CharOffset >= textLen {
+			return fmt.Errorf("chapter %d: charOffset %d outside the text", i, c.CharOffset)
+		}
+		if i > 0 && c.CharOffset <= chapters[i-1].CharOffset {
+			return fmt.Errorf("chapter %d: charOffset %d not after the previous chapter", i, c.CharOffset)
+		}
+	}
+	return nil
+}
+
+// DetectChapters returns a chapter for every heading line in text (the
+// lines that get heading pauses), titled with the line less any Markdown
+// markers. At most MaxChapters are returned.
+func DetectChapters(text string) []Chapter {
+	var chapters []Chapter
+	pos := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		body := strings.TrimRight(line, "\r\n")
+		if isHeading(body) && len(chapters) < MaxChapters {
+			lead := len(body) - len(strings.TrimLeft(body, " \t　"))
+			title := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(body), "#"))
+			if len(title) > maxChapterTitle {
+				title = strings.ToValidUTF8(title[:maxChapterTitle], "")
+			}
+			chapters = append(chapters, Chapter{Title: title, CharOffset: pos + utf8.RuneCountInString(body[:lead])})
+		}
+		pos += utf8.RuneCountInString(line)
+	}
+	return chapters
+}
+
+// chunkStart records where a chunk's audio begins: the time offset passed
+// to AdjustTimepoints for it and the index of its first timepoint.
+type chunkStart struct {
+	charOffset int
+	text       string
+	time       float64
+	timepoint  int
+}
+
+// placeChapters sets each chapter's time from the chunk containing it. A
+// chapter at the start of a chunk (ignoring whitespace) starts with the
+// chunk's audio; otherwise it starts at the chunk's first timepoint at or
+// after the chapter's offset, falling back to the chunk start.
+func placeChapters(chapters []Chapter, starts []chunkStart, tps []TTSTimepoint) []Chapter {
+	placed := make([]Chapter, 0, len(chapters))
+	for _, c := range chapters {
+		i := len(starts) - 1
+		for i > 0 && starts[i].charOffset > c.CharOffset {
+			i--
+		}
+		s := starts[i]
+		c.TimeSeconds = s.time
+		runes := []rune(s.text)
+		if into := c.CharOffset - s.charOffset; into > 0 && strings.TrimSpace(string(runes[:min(into, len(runes))])) != "" {
+			end := len(tps)
+			if i+1 < len(starts) {
+				end = starts[i+1].timepoint
+			}
+			for _, tp := range tps[s.timepoint:end] {
+				var idx, start, stop int
+				if _, err := fmt.Sscanf(tp.MarkName, "%d:%d:%d", &idx, &start, &stop); err == nil && start >= c.CharOffset {
+					c.TimeSeconds = tp.TimeSeconds
+					break
+				}
+			}
+		}
+		placed = append(placed, c)
+	}
+	return placed
+}
+
+// retimeChapters divides every chapter time by speed and then adds delay,
+// mirroring scaleTimepoints and shiftTimepoints.
+func retimeChapters(chapters []Chapter, speed, delay float64) []Chapter {
+	for i := range chapters {
+		chapters[i].TimeSeconds = chapters[i].TimeSeconds/speed + delay
+	}
+	return chapters
+}
+
+// chapterCues returns the WAV cue and label chunks for chapters.
+func chapterCues(chapters []Chapter, f wav.Format) []byte {
+	cues := make([]wav.Cue, len(chapters))
+	for i, c := range chapters {
+		cues[i] = wav.Cue{Frame: uint32(math.Round(c.TimeSeconds * float64(f.SampleRate))), Label: c.Title}
+	}
+	return wav.CueChunks(cues)
+}
+
+// chapterTag returns an ID3 tag with the chapters as CHAP frames, each
+// ending where the next starts and the last at end seconds.
+func chapterTag(chapters []Chapter, end float64) []byte {
+	if len(chapters) == 0 {
+		return nil
+	}
+	seconds := func(s float64) time.Duration { return time.Duration(math.Round(s * float64(time.Second))) }
+	list := make([]id3.Chapter, len(chapters))
+	for i, c := range chapters {
+		list[i] = id3.Chapter{Title: c.Title, Start: seconds(c.TimeSeconds), End: seconds(end)}
+		if i > 0 {
+			list[i-1].End = list[i].Start
+		}
+	}
+	return id3.Tag(id3.Chapters(list)...)
+}
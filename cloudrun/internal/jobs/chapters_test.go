@@ -0,0 +1,43 @@
+package jobs_test
+
+import (
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+)
+
+func TestDetectChapters(t *testing.T) {
+	text := "吾輩は猫である。\n\n## 第一章　出会い\n本文。\n　第2話\nChapter 3 begins\nchapters are fun\n"
+	got := jobs.DetectChapters(text)
+	want := []jobs.Chapter{
+		{Title: "第一章　出会い", CharOffset: 10},
+		{Title: "第2話", CharOffset: 26},
+		{Title: "Chapter 3 begins", CharOffset: 30},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("chapters = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("chapter %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestValidateChapters(t *testing.T) {
+	ok := []jobs.Chapter{{Title: "一", CharOffset: 0}, {Title: "二", CharOffset: 5}}
+	if err := jobs.ValidateChapters(ok, 10); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, bad := range [][]jobs.Chapter{
+		{{Title: "", CharOffset: 0}},
+		{{Title: "一", CharOffset: 10}},
+		{{Title: "一", CharOffset: -1}},
+		{{Title: "一", CharOffset: 5}, {Title: "二", CharOffset: 5}},
+		make([]jobs.Chapter, jobs.MaxChapters+1),
+	} {
+		if err := jobs.ValidateChapters(bad, 10); err == nil {
+			t.Errorf("%+v: expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package jobs
+
+import "fmt"
+
+// MaxCrossfadeMs caps Job.CrossfadeMs; longer overlaps would blur words.
+const MaxCrossfadeMs = 200
+
+// ValidateCrossfadeMs checks ms is within [0, MaxCrossfadeMs].
+func ValidateCrossfadeMs(ms int) error {
+	if ms < 0 || ms > MaxCrossfadeMs {
+		return fmt.Errorf("crossfadeMs %d out of range 0-%d", ms, MaxCrossfadeMs)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package jobs_test
+
+import (
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+)
+
+func TestValidateCrossfadeMs(t *testing.T) {
+	for _, ms := range []int{0, 20, jobs.MaxCrossfadeMs} {
+		if err := jobs.ValidateCrossfadeMs(ms); err != nil {
+			t.Errorf("%d: unexpected error: %v", ms, err)
+		}
+	}
+	for _, ms := range []int{-1, jobs.MaxCrossfadeMs + 1} {
+		if err := jobs.ValidateCrossfadeMs(ms); err == nil {
+			t.Errorf("%d: expected error", ms)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const deviceTokensCollection = "ttsDeviceTokens"
+
+// deviceTokensDoc is the per-owner document holding its tokens.
+type deviceTokensDoc struct {
+	Tokens []string `firestore:"tokens"`
+}
+
+// FirestoreDeviceTokenStore is the Firestore-backed implementation of
+// DeviceTokenStore, with one document per owner.
+type FirestoreDeviceTokenStore struct {
+	client *firestore.Client
+}
+
+// NewFirestoreDeviceTokenStore creates a new FirestoreDeviceTokenStore.
+func NewFirestoreDeviceTokenStore(client *firestore.Client) *FirestoreDeviceTokenStore {
+	return &FirestoreDeviceTokenStore{client: client}
+}
+
+func (s *FirestoreDeviceTokenStore) List(ctx context.Context, ownerID string) ([]string, error) {
+	doc, err := s.client.Collection(deviceTokensCollection).Doc(ownerID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("firestore get device tokens %s: %w", ownerID, err)
+	}
+	var d deviceTokensDoc
+	if err := doc.DataTo(&d); err != nil {
+		return nil, fmt.Errorf("firestore decode device tokens %s: %w", ownerID, err)
+	}
+	return d.Tokens, nil
+}
+
+func (s *FirestoreDeviceTokenStore) Add(ctx context.Context, ownerID, token string) error {
+	_, err := s.client.Collection(deviceTokensCollection).Doc(ownerID).Set(ctx, map[string]interface{}{
+		"tokens":    firestore.ArrayUnion(token),
+		"updatedAt": time.Now(),
+	}, firestore.MergeAll)
+	if err != nil {
+		return fmt.Errorf("firestore add device token %s: %w", ownerID, err)
+	}
+	return nil
+}
+
+func (s *FirestoreDeviceTokenStore) Remove(ctx context.Context, ownerID string, tokens ...string) error {
+	values := make([]interface{}, len(tokens))
+	for i, t := range tokens {
+		values[i] = t
+	}
+	_, err := s.client.Collection(deviceTokensCollection).Doc(ownerID).Set(ctx, map[string]interface{}{
+		"tokens":    firestore.ArrayRemove(values...),
+		"updatedAt": time.Now(),
+	}, firestore.MergeAll)
+	if err != nil {
+		return fmt.Errorf("firestore remove device tokens %s: %w", ownerID, err)
+	}
+	return nil
+}
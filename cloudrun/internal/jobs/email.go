@@ -0,0 +1,176 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	maxEmailAddress = 254
+	smtpTimeout     = 30 * time.Second
+)
+
+// Email is a rendered plain-text email.
+type Email struct {
+	Subject string
+	Body    string
+}
+
+// emailTemplate lays out job emails; the labels come from the locale's
+// notificationMessages.
+var emailTemplate = template.Must(template.New("email").Parse(`{{.Message}}
+{{- if .AudioURL}}
+
+{{.Listen}}: {{.AudioURL}}
+{{- end}}
+{{- if .Duration}}
+{{.DurationLabel}}: {{.Duration}}
+{{- end}}
+`))
+
+// ValidateEmailAddress checks that s is a bare address such as
+// "user@example.com", without a display name or angle brackets.
+func ValidateEmailAddress(s string) error {
+	if len(s) > maxEmailAddress {
+		return fmt.Errorf("email address longer than %d bytes", maxEmailAddress)
+	}
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return fmt.Errorf("email address: %w", err)
+	}
+	if addr.Name != "" || addr.Address != s {
+		return fmt.Errorf("email address %q must not have a display name", s)
+	}
+	return nil
+}
+
+// JobEmail renders the email announcing that job reached status (completed
+// or failed) in the job's notification locale. Completed emails carry the
+// audio link and its duration.
+func JobEmail(job *Job, status JobStatus, audioURL string, durationSeconds float64) Email {
+	locale := job.NotificationLocale()
+	m := notificationCatalog[ResolveLocale(locale)]
+	subject, message := NotificationText(locale, status, job.Title)
+
+	data := struct {
+		Message, Listen, AudioURL, DurationLabel, Duration string
+	}{Message: message, Listen: m.Listen, DurationLabel: m.Duration}
+	if status == JobStatusCompleted {
+		data.AudioURL = audioURL
+		if durationSeconds > 0 {
+			data.Duration = formatDuration(durationSeconds)
+		}
+	}
+	var body strings.Builder
+	emailTemplate.Execute(&body, data)
+	return Email{Subject: subject, Body: body.String()}
+}
+
+// formatDuration formats seconds as m:ss or h:mm:ss.
+func formatDuration(seconds float64) string {
+	s := int(math.Round(seconds))
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// SMTPNotifier is the SMTP implementation of EmailNotifier. It upgrades to
+// TLS when the server offers STARTTLS.
+type SMTPNotifier struct {
+	addr string // host:port
+	from string
+	auth smtp.Auth // nil to send without authenticating
+}
+
+// NewSMTPNotifier creates an SMTPNotifier that sends through the server at
+// addr (host:port) as from.
+func NewSMTPNotifier(addr, from string, auth smtp.Auth) *SMTPNotifier {
+	return &SMTPNotifier{addr: addr, from: from, auth: auth}
+}
+
+func (n *SMTPNotifier) Send(ctx context.Context, to string, email Email) error {
+	msg, err := n.message(to, email)
+	if err != nil {
+		return err
+	}
+
+	conn, err := (&net.Dialer{Timeout: smtpTimeout}).DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return fmt.Errorf("smtp dial %s: %w", n.addr, err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	host, _, _ := net.SplitHostPort(n.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp hello %s: %w", n.addr, err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+	if n.auth != nil {
+		if err := c.Auth(n.auth); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := c.Mail(n.from); err != nil {
+		return fmt.Errorf("smtp mail from: %w", err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return fmt.Errorf("smtp rcpt to: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("smtp write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp send: %w", err)
+	}
+	return c.Quit()
+}
+
+// message builds the RFC 5322 message: a UTF-8 plain-text body in
+// quoted-printable, with the subject as an encoded word.
+func (n *SMTPNotifier) message(to string, email Email) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", n.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(email.Body, "\n", "\r\n"))); err != nil {
+		return nil, fmt.Errorf("encode email: %w", err)
+	}
+	if err := qp.Close(); err != nil {
+		return nil, fmt.Errorf("encode email: %w", err)
+	}
+	return buf.Bytes(), nil
+}
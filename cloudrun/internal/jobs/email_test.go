@@ -0,0 +1,177 @@
+package jobs_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+)
+
+// smtpEnvelope is one message received by the SMTP stand-in.
+type smtpEnvelope struct {
+	auth, from, to string
+	data           string
+}
+
+// startSMTP runs a minimal SMTP server on a loopback port that accepts
+// AUTH PLAIN and every message, and returns its address and the messages.
+func startSMTP(t *testing.T) (string, <-chan smtpEnvelope) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan smtpEnvelope, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, received)
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func serveSMTP(conn net.Conn, received chan<- smtpEnvelope) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+
+	var env smtpEnvelope
+	reply("220 localhost ESMTP stand-in")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			env.auth = line
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			env.from = line
+			reply("250 OK")
+		case "RCPT":
+			env.to = line
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			env.data = data.String()
+			received <- env
+			reply("250 OK queued")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestSMTPNotifier_Send(t *testing.T) {
+	addr, received := startSMTP(t)
+	auth := smtp.PlainAuth("", "mailer", "secret", "127.0.0.1")
+	n := jobs.NewSMTPNotifier(addr, "noreply@example.com", auth)
+
+	job := &jobs.Job{ID: "job-1", Title: "四半期レポート", Language: "ja-JP"}
+	email := jobs.JobEmail(job, jobs.JobStatusCompleted, "https://storage.example.com/audio/job-1.mp3", 754.4)
+	if err := n.Send(context.Background(), "reader@example.com", email); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	env := <-received
+	if env.auth == "" || env.from != "MAIL FROM:<noreply@example.com>" || env.to != "RCPT TO:<reader@example.com>" {
+		t.Errorf("envelope = %+v", env)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(env.data))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "音声生成完了" {
+		t.Errorf("subject = %q (%v)", subject, err)
+	}
+	if got := msg.Header.Get("To"); got != "reader@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	body, _ := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	for _, want := range []string{"「四半期レポート」の読み上げ音声が生成されました", "音声: https://storage.example.com/audio/job-1.mp3", "再生時間: 12:34"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("body missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestSMTPNotifier_Unreachable(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+
+	n := jobs.NewSMTPNotifier(addr, "noreply@example.com", nil)
+	if err := n.Send(context.Background(), "reader@example.com", jobs.Email{Subject: "s", Body: "b"}); err == nil {
+		t.Error("Send to a closed port succeeded")
+	}
+}
+
+func TestJobEmail(t *testing.T) {
+	job := &jobs.Job{ID: "job-1", Locale: "de", Title: "Bericht"}
+	done := jobs.JobEmail(job, jobs.JobStatusCompleted, "https://example.com/a.mp3", 3725)
+	if done.Subject != "Audio fertig" {
+		t.Errorf("subject = %q", done.Subject)
+	}
+	want := "Die Audiodatei für „Bericht“ ist fertig.\n\nAnhören: https://example.com/a.mp3\nDauer: 1:02:05\n"
+	if done.Body != want {
+		t.Errorf("body = %q, want %q", done.Body, want)
+	}
+
+	failed := jobs.JobEmail(&jobs.Job{ID: "job-2", Locale: "xx"}, jobs.JobStatusFailed, "https://ignored", 10)
+	if failed.Subject != "Audio generation failed" || failed.Body != "We couldn't generate your audio.\n" {
+		t.Errorf("failed email = %+v", failed)
+	}
+}
+
+func TestValidateEmailAddress(t *testing.T) {
+	if err := jobs.ValidateEmailAddress("reader@example.com"); err != nil {
+		t.Errorf("valid address: %v", err)
+	}
+	for _, s := range []string{
+		"Reader <reader@example.com>",
+		"<reader@example.com>",
+		"reader",
+		"reader@example.com\r\nBcc: x@example.com",
+		"a@b.com, c@d.com",
+		strings.Repeat("a", 250) + "@example.com",
+	} {
+		if err := jobs.ValidateEmailAddress(s); err == nil {
+			t.Errorf("%q: accepted, want an error", s)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// jobEventsCollection is the subcollection of a job document holding its events.
+const jobEventsCollection = "events"
+
+// jobEventTTL is how long event documents are kept. Firestore only
+// deletes them with a TTL policy on the expireAt field of the events
+// collection group; see the README for the gcloud command.
+const jobEventTTL = 24 * time.Hour
+
+// progressWriteInterval is the least time between stored progress events of
+// a job. Progress published in between is merged into the next write, so a
+// long job stores a few documents a minute rather than one per chunk.
+const progressWriteInterval = 2 * time.Second
+
+// jobEventDoc is the stored form of a JobEvent.
+type jobEventDoc struct {
+	JobEvent
+	Seq      int64     `firestore:"seq"` // publish order
+	ExpireAt time.Time `firestore:"expireAt"`
+}
+
+// FirestoreJobEvents is a JobEvents shared by all instances: events are
+// written under the job document and subscribers follow them with a
+// snapshot listener, so the SSE connection and the Cloud Tasks request
+// processing the job may land on different instances.
+type FirestoreJobEvents struct {
+	client *firestore.Client
+
+	mu       sync.Mutex
+	progress map[string]*pendingProgress // by job ID, until it finishes
+}
+
+// pendingProgress is a job's progress not yet written.
+type pendingProgress struct {
+	event   JobEvent // zero when nothing is pending
+	written time.Time
+}
+
+// NewFirestoreJobEvents creates a new FirestoreJobEvents.
+func NewFirestoreJobEvents(client *firestore.Client) *FirestoreJobEvents {
+	return &FirestoreJobEvents{client: client, progress: map[string]*pendingProgress{}}
+}
+
+func (s *FirestoreJobEvents) events(jobID string) *firestore.CollectionRef {
+	return s.client.Collection(jobsCollection).Doc(jobID).Collection(jobEventsCollection)
+}
+
+// Publish orders events by the publisher's clock, which is enough because
+// a job is processed by one request at a time. Progress events are
+// coalesced per progressWriteInterval: the stored event carries the latest
+// chunk and the timepoints of every chunk since the previous write. The
+// last chunk and status events are written at once, after any pending
+// progress.
+func (s *FirestoreJobEvents) Publish(ctx context.Context, jobID string, event JobEvent) error {
+	s.mu.Lock()
+	p := s.progress[jobID]
+	if p == nil {
+		p = &pendingProgress{}
+		s.progress[jobID] = p
+	}
+	var flush JobEvent
+	if event.Type == JobEventProgress {
+		event.Timepoints = append(p.event.Timepoints, event.Timepoints...)
+		if event.Chunk != event.Chunks && time.Since(p.written) < progressWriteInterval {
+			p.event = event
+			s.mu.Unlock()
+			return nil
+		}
+		p.written = time.Now()
+	} else {
+		flush = p.event
+	}
+	p.event = JobEvent{}
+	if event.Status == JobStatusCompleted || event.Status == JobStatusFailed {
+		delete(s.progress, jobID)
+	}
+	s.mu.Unlock()
+
+	if flush.Type != "" {
+		if err := s.write(ctx, jobID, flush); err != nil {
+			return err
+		}
+	}
+	return s.write(ctx, jobID, event)
+}
+
+func (s *FirestoreJobEvents) write(ctx context.Context, jobID string, event JobEvent) error {
+	now := time.Now()
+	doc := jobEventDoc{JobEvent: event, Seq: now.UnixNano(), ExpireAt: now.Add(jobEventTTL)}
+	if _, _, err := s.events(jobID).Add(ctx, doc); err != nil {
+		return fmt.Errorf("firestore publish event %s: %w", jobID, err)
+	}
+	return nil
+}
+
+// Subscribe skips the events already stored when the listener starts (the
+// first snapshot) and delivers documents added after it. Like
+// MemoryJobEvents it never blocks on a slow subscriber: once its buffer is
+// full the listener stops and the channel is closed.
+func (s *FirestoreJobEvents) Subscribe(ctx context.Context, jobID string) (<-chan JobEvent, error) {
+	it := s.events(jobID).OrderBy("seq", firestore.Asc).Snapshots(ctx)
+	if _, err := it.Next(); err != nil {
+		it.Stop()
+		return nil, fmt.Errorf("firestore subscribe events %s: %w", jobID, err)
+	}
+
+	ch := make(chan JobEvent, subscriberBuffer)
+	go func() {
+		defer close(ch)
+		defer it.Stop()
+		for {
+			snap, err := it.Next()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("FirestoreJobEvents: listen %s: %v", jobID, err)
+				}
+				return
+			}
+			for _, change := range snap.Changes {
+				if change.Kind != firestore.DocumentAdded {
+					continue
+				}
+				var doc jobEventDoc
+				if err := change.Doc.DataTo(&doc); err != nil {
+					log.Printf("FirestoreJobEvents: decode event %s: %v", jobID, err)
+					continue
+				}
+				select {
+				case ch <- doc.JobEvent:
+				default:
+					log.Printf("FirestoreJobEvents: subscriber to %s fell behind, closing", jobID)
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,91 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+)
+
+// JobEventType is the kind of a JobEvent, sent as the SSE event name.
+type JobEventType string
+
+const (
+	JobEventStatus   JobEventType = "status"   // the job moved to Status
+	JobEventProgress JobEventType = "progress" // chunk Chunk of Chunks was synthesized
+)
+
+// JobEvent is a change in a job's state published while it is processed.
+type JobEvent struct {
+	Type     JobEventType `firestore:"type"               json:"type"`
+	Status   JobStatus    `firestore:"status,omitempty"   json:"status,omitempty"`
+	AudioURL string       `firestore:"audioUrl,omitempty" json:"audioUrl,omitempty"` // completed only
+	ErrorMsg string       `firestore:"errorMsg,omitempty" json:"errorMsg,omitempty"` // failed only
+	Chunk    int          `firestore:"chunk,omitempty"    json:"chunk,omitempty"`    // 1-based
+	Chunks   int          `firestore:"chunks,omitempty"   json:"chunks,omitempty"`
+	// Timepoints of the chunk, on the timeline of the finished audio.
+	Timepoints []TTSTimepoint `firestore:"timepoints,omitempty" json:"timepoints,omitempty"`
+}
+
+// ProgressFunc is called by ProcessJob after each chunk is synthesized with
+// its 1-based index, the chunk count and the chunk's timepoints.
+type ProgressFunc func(chunk, chunks int, timepoints []TTSTimepoint)
+
+// subscriberBuffer is how many events a subscriber may lag behind before
+// it is dropped.
+const subscriberBuffer = 64
+
+// MemoryJobEvents is an in-process JobEvents for a single instance, where
+// the job is processed by the same process that serves its subscribers.
+type MemoryJobEvents struct {
+	mu   sync.Mutex
+	subs map[string]map[chan JobEvent]struct{}
+}
+
+// NewMemoryJobEvents creates an empty MemoryJobEvents.
+func NewMemoryJobEvents() *MemoryJobEvents {
+	return &MemoryJobEvents{subs: map[string]map[chan JobEvent]struct{}{}}
+}
+
+// Publish never blocks: a subscriber whose buffer is full is closed, so it
+// can resubscribe and read the current state instead of missing events.
+func (m *MemoryJobEvents) Publish(_ context.Context, jobID string, event JobEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for ch := range m.subs[jobID] {
+		select {
+		case ch <- event:
+		default:
+			m.remove(jobID, ch)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryJobEvents) Subscribe(ctx context.Context, jobID string) (<-chan JobEvent, error) {
+	ch := make(chan JobEvent, subscriberBuffer)
+	m.mu.Lock()
+	if m.subs[jobID] == nil {
+		m.subs[jobID] = map[chan JobEvent]struct{}{}
+	}
+	m.subs[jobID][ch] = struct{}{}
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		m.remove(jobID, ch)
+		m.mu.Unlock()
+	}()
+	return ch, nil
+}
+
+// remove closes and forgets ch if it is still subscribed. m.mu must be held.
+func (m *MemoryJobEvents) remove(jobID string, ch chan JobEvent) {
+	if _, ok := m.subs[jobID][ch]; !ok {
+		return
+	}
+	delete(m.subs[jobID], ch)
+	if len(m.subs[jobID]) == 0 {
+		delete(m.subs, jobID)
+	}
+	close(ch)
+}
@@ -0,0 +1,52 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+)
+
+func TestMemoryJobEvents_PublishSubscribe(t *testing.T) {
+	bus := jobs.NewMemoryJobEvents()
+	ctx, cancel := context.WithCancel(context.Background())
+	a, _ := bus.Subscribe(ctx, "job-1")
+	b, _ := bus.Subscribe(context.Background(), "job-2")
+
+	bus.Publish(ctx, "job-1", jobs.JobEvent{Type: jobs.JobEventStatus, Status: jobs.JobStatusProcessing})
+	bus.Publish(ctx, "job-1", jobs.JobEvent{Type: jobs.JobEventProgress, Chunk: 1, Chunks: 2})
+
+	if e := <-a; e.Status != jobs.JobStatusProcessing {
+		t.Errorf("first event = %+v, want processing status", e)
+	}
+	if e := <-a; e.Type != jobs.JobEventProgress || e.Chunk != 1 {
+		t.Errorf("second event = %+v, want progress 1", e)
+	}
+	select {
+	case e := <-b:
+		t.Errorf("job-2 subscriber got %+v", e)
+	default:
+	}
+
+	cancel()
+	if _, ok := <-a; ok {
+		t.Error("channel still open after the subscriber's context ended")
+	}
+}
+
+func TestMemoryJobEvents_DropsSlowSubscriber(t *testing.T) {
+	bus := jobs.NewMemoryJobEvents()
+	ch, _ := bus.Subscribe(context.Background(), "job-1")
+	for i := range 100 {
+		if err := bus.Publish(context.Background(), "job-1", jobs.JobEvent{Type: jobs.JobEventProgress, Chunk: i + 1}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n == 0 || n >= 100 {
+		t.Errorf("read %d events before close, want the buffered ones only", n)
+	}
+}
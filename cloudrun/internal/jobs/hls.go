@@ -0,0 +1,225 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/id3"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/mp3"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+// HLS output is a directory of MP3 segments and an EVENT playlist that is
+// rewritten as segments complete, so clients can start playing after the
+// first segment.
+const (
+	hlsSegmentSeconds = 6.0
+	hlsPlaylistName   = "playlist.m3u8"
+	hlsContentType    = "application/vnd.apple.mpegurl"
+	hlsSegmentType    = "audio/mpeg"
+
+	// Players poll the playlist, so it must not be cached; GCS also limits
+	// writes to one object to about one per second.
+	hlsPlaylistCache       = "no-cache, max-age=0"
+	hlsMinPlaylistInterval = 2 * time.Second
+)
+
+// HLSPlaylistPath returns the storage object name of a job's HLS playlist.
+// It depends only on the job ID, so the URL can be handed out before the
+// job runs.
+func HLSPlaylistPath(jobID string) string {
+	return "audio/hls/" + jobID + "/" + hlsPlaylistName
+}
+
+// StartHLSPlaylist stores an empty EVENT playlist for a job and returns its
+// URL, which stays valid while ProcessJob appends segments.
+func StartHLSPlaylist(ctx context.Context, storage AudioStorage, jobID string) (string, error) {
+	return uploadHLSPlaylist(ctx, storage, HLSPlaylistPath(jobID), &hlsPlaylist{})
+}
+
+func uploadHLSPlaylist(ctx context.Context, storage AudioStorage, playlistPath string, p *hlsPlaylist) (string, error) {
+	url, err := storage.Upload(ctx, p.render(), playlistPath, UploadOptions{
+		ContentType:  hlsContentType,
+		CacheControl: hlsPlaylistCache,
+	})
+	if err != nil {
+		return "", fmt.Errorf("upload HLS playlist: %w", err)
+	}
+	return url, nil
+}
+
+type hlsSegment struct {
+	name     string
+	duration float64
+}
+
+type hlsPlaylist struct {
+	segments []hlsSegment
+	ended    bool
+}
+
+func (p *hlsPlaylist) render() []byte {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(hlsSegmentSeconds)))
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n#EXT-X-MEDIA-SEQUENCE:0\n")
+	for _, s := range p.segments {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", s.duration, s.name)
+	}
+	if p.ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return []byte(b.String())
+}
+
+// hlsWriter receives MP3 frames, one per Write, and uploads them as
+// segments of at most hlsSegmentSeconds, each starting with the ID3
+// timestamp HLS requires for packed audio. The playlist is re-uploaded
+// after each segment, at most every hlsMinPlaylistInterval, and always on
+// Close.
+type hlsWriter struct {
+	ctx          context.Context
+	storage      AudioStorage
+	playlistPath string
+	rate         int
+	frameDur     float64
+
+	seg       bytes.Buffer
+	segFrames int
+	samples   int64 // samples per channel in finished segments
+	playlist  hlsPlaylist
+	bytes     int64 // total size of uploaded segments
+	published time.Time
+}
+
+func newHLSWriter(ctx context.Context, storage AudioStorage, playlistPath string, rate int) *hlsWriter {
+	return &hlsWriter{
+		ctx:          ctx,
+		storage:      storage,
+		playlistPath: playlistPath,
+		rate:         rate,
+		frameDur:     float64(mp3.FrameSamples(rate)) / float64(rate),
+	}
+}
+
+func (h *hlsWriter) Write(frame []byte) (int, error) {
+	if h.segFrames > 0 && float64(h.segFrames+1)*h.frameDur > hlsSegmentSeconds {
+		if err := h.flush(); err != nil {
+			return 0, err
+		}
+	}
+	h.seg.Write(frame)
+	h.segFrames++
+	return len(frame), nil
+}
+
+// flush uploads the pending segment and, unless it was published very
+// recently, the playlist.
+func (h *hlsWriter) flush() error {
+	name := fmt.Sprintf("segment%05d.mp3", len(h.playlist.segments))
+	data := append(id3Timestamp(h.samples*90000/int64(h.rate)), h.seg.Bytes()...)
+	if _, err := h.storage.Upload(h.ctx, data, path.Join(path.Dir(h.playlistPath), name), UploadOptions{ContentType: hlsSegmentType}); err != nil {
+		return fmt.Errorf("upload HLS segment: %w", err)
+	}
+	h.bytes += int64(len(data))
+	h.samples += int64(h.segFrames * mp3.FrameSamples(h.rate))
+	h.playlist.segments = append(h.playlist.segments, hlsSegment{name, float64(h.segFrames) * h.frameDur})
+	h.seg.Reset()
+	h.segFrames = 0
+
+	if time.Since(h.published) < hlsMinPlaylistInterval {
+		return nil
+	}
+	if _, err := uploadHLSPlaylist(h.ctx, h.storage, h.playlistPath, &h.playlist); err != nil {
+		return err
+	}
+	h.published = time.Now()
+	return nil
+}
+
+// Close uploads the last segment and the final playlist with ENDLIST and
+// returns the playlist URL.
+func (h *hlsWriter) Close() (string, error) {
+	if h.segFrames > 0 {
+		if err := h.flush(); err != nil {
+			return "", err
+		}
+	}
+	h.playlist.ended = true
+	return uploadHLSPlaylist(h.ctx, h.storage, h.playlistPath, &h.playlist)
+}
+
+// encodeHLS runs synthesize into an MP3 encoder whose frames are cut into
+// HLS segments. It returns the encoder delay, the playlist URL and the
+// total size of the segments. On failure the playlist is ended after the
+// segments uploaded so far, so players stop polling it.
+func encodeHLS(
+	ctx context.Context,
+	storage AudioStorage,
+	playlistPath string,
+	opts EncodeOptions,
+	synthesize func(emit func(f wav.Format, pcm []byte) error) error,
+) (delay float64, url string, size int64, err error) {
+	var hw *hlsWriter
+	var enc audioEncoder
+	defer func() {
+		if err != nil {
+			endFailedHLS(ctx, storage, playlistPath, hw)
+		}
+	}()
+	err = synthesize(func(f wav.Format, pcm []byte) error {
+		if enc == nil {
+			hw = newHLSWriter(ctx, storage, playlistPath, f.SampleRate)
+			var err error
+			if enc, err = newAudioEncoder(hw, OutputFormatMP3, opts, f); err != nil {
+				return fmt.Errorf("create hls encoder: %w", err)
+			}
+			delay = encoderDelay(OutputFormatMP3, f)
+		}
+		if _, err := enc.Write(pcm); err != nil {
+			return fmt.Errorf("encode hls: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, "", 0, err
+	}
+	if enc == nil {
+		return 0, "", 0, fmt.Errorf("no audio data produced")
+	}
+	if err := enc.Close(); err != nil {
+		return 0, "", 0, fmt.Errorf("encode hls: %w", err)
+	}
+	if url, err = hw.Close(); err != nil {
+		return 0, "", 0, err
+	}
+	return delay, url, hw.bytes, nil
+}
+
+// endFailedHLS ends the playlist of a failed job, listing the segments hw
+// uploaded (none when hw is nil). The job fails either way, so an upload
+// error is only logged.
+func endFailedHLS(ctx context.Context, storage AudioStorage, playlistPath string, hw *hlsWriter) {
+	p := &hlsPlaylist{}
+	if hw != nil {
+		p = &hw.playlist
+	}
+	p.ended = true
+	if _, err := uploadHLSPlaylist(context.WithoutCancel(ctx), storage, playlistPath, p); err != nil {
+		log.Printf("ProcessJob: end HLS playlist %s: %v", playlistPath, err)
+	}
+}
+
+// id3Timestamp returns the ID3 tag with the PRIV frame that HLS packed
+// audio segments carry to give the 90 kHz timestamp of their first sample.
+func id3Timestamp(pts int64) []byte {
+	ts := binary.BigEndian.AppendUint64(nil, uint64(pts)&(1<<33-1))
+	return id3.Tag(id3.PRIV("com.apple.streaming.transportStreamTimestamp", ts))
+}
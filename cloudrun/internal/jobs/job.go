@@ -2,6 +2,8 @@ package jobs
 
 import (
 	"context"
+	"errors"
+	"io"
 	"time"
 
 	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
@@ -19,20 +21,44 @@ const (
 
 // Job holds the request parameters and current state of a TTS generation job.
 type Job struct {
-	ID          string         `firestore:"id"          json:"id"`
-	Status      JobStatus      `firestore:"status"      json:"status"`
-	Text        string         `firestore:"text"        json:"text"`
-	TextURL     string         `firestore:"textUrl,omitempty" json:"textUrl,omitempty"` // GCS URL for large texts
-	VoiceID     string         `firestore:"voiceId"     json:"voiceId"`
-	Language    string         `firestore:"language"    json:"language"`
-	Style       string         `firestore:"style"       json:"style"`
-	FileID      string         `firestore:"fileId"      json:"fileId"`
-	DeviceToken string         `firestore:"deviceToken" json:"-"` // never expose token in API response
-	AudioURL    string         `firestore:"audioUrl,omitempty"   json:"audioUrl,omitempty"`
-	Timepoints  []TTSTimepoint `firestore:"timepoints,omitempty" json:"timepoints,omitempty"`
-	ErrorMsg    string         `firestore:"errorMsg,omitempty"   json:"errorMsg,omitempty"`
-	CreatedAt   time.Time      `firestore:"createdAt"   json:"createdAt"`
-	UpdatedAt   time.Time      `firestore:"updatedAt"   json:"updatedAt"`
+	ID              string           `firestore:"id"          json:"id"`
+	Status          JobStatus        `firestore:"status"      json:"status"`
+	Text            string           `firestore:"text"        json:"text"`
+	TextURL         string           `firestore:"textUrl,omitempty" json:"textUrl,omitempty"` // GCS URL for large texts
+	VoiceID         string           `firestore:"voiceId"     json:"voiceId"`
+	Language        string           `firestore:"language"    json:"language"`
+	Style           string           `firestore:"style"       json:"style"`
+	FileID          string           `firestore:"fileId"      json:"fileId"`
+	DeviceToken     string           `firestore:"deviceToken" json:"-"`                                 // never expose token in API response
+	OwnerID         string           `firestore:"ownerId,omitempty" json:"ownerId,omitempty"`           // signed-in creator (Firebase UID); the quota account
+	Title           string           `firestore:"title,omitempty"      json:"title,omitempty"`          // document title, used for audio tags
+	Locale          string           `firestore:"locale,omitempty" json:"locale,omitempty"`             // language of notification texts; falls back to the voice language
+	OutputFormat    OutputFormat     `firestore:"outputFormat,omitempty" json:"outputFormat,omitempty"` // empty means wav
+	Bitrate         int              `firestore:"bitrate,omitempty"    json:"bitrate,omitempty"`        // kbps, mp3 only
+	Pauses          *Pauses          `firestore:"pauses,omitempty"     json:"pauses,omitempty"`         // extra silence at paragraphs, headings and chunk joins
+	TrimSilence     *SilenceTrim     `firestore:"trimSilence,omitempty" json:"trimSilence,omitempty"`   // cut TTS lead-in/tail silence from each chunk
+	CrossfadeMs     int              `firestore:"crossfadeMs,omitempty" json:"crossfadeMs,omitempty"`   // overlap at every join, 0 for hard cuts
+	Loudness        *Loudness        `firestore:"loudness,omitempty" json:"loudness,omitempty"`         // EBU R128 normalization; nil leaves TTS levels
+	RenderSpeed     float64          `firestore:"renderSpeed,omitempty" json:"renderSpeed,omitempty"`   // server-side tempo change keeping pitch; 0 or 1 is unchanged
+	Background      *Background      `firestore:"background,omitempty" json:"background,omitempty"`     // looping music/ambience ducked under the speech
+	AudioURL        string           `firestore:"audioUrl,omitempty"   json:"audioUrl,omitempty"`
+	AudioPath       string           `firestore:"audioPath,omitempty"  json:"-"` // storage object name, served by GET /jobs/{jobId}/audio
+	DurationSeconds float64          `firestore:"durationSeconds,omitempty" json:"durationSeconds,omitempty"`
+	PeaksURL        string           `firestore:"peaksUrl,omitempty"   json:"peaksUrl,omitempty"`  // waveform peaks JSON (audiowaveform format) for the player
+	PCMSHA256       string           `firestore:"pcmSha256,omitempty"  json:"pcmSha256,omitempty"` // hex SHA-256 of the concatenated PCM samples
+	PCMBytes        int64            `firestore:"pcmBytes,omitempty"   json:"pcmBytes,omitempty"`
+	Timepoints      []TTSTimepoint   `firestore:"timepoints,omitempty" json:"timepoints,omitempty"`
+	PlaylistURL     string           `firestore:"playlistUrl,omitempty" json:"playlistUrl,omitempty"`   // HLS only; set at creation so playback can start with the first segment
+	CallbackURL     string           `firestore:"callbackUrl,omitempty" json:"callbackUrl,omitempty"`   // signed webhook target for completion/failure
+	NotifyEmail     string           `firestore:"notifyEmail,omitempty" json:"-"`                       // address emailed on completion/failure; never exposed in API responses
+	Chapters        []Chapter        `firestore:"chapters,omitempty" json:"chapters,omitempty"`         // requested or detected chapters, timed on completion
+	AutoChapters    bool             `firestore:"autoChapters,omitempty" json:"autoChapters,omitempty"` // without chapters, detect one per heading line
+	ErrorMsg        string           `firestore:"errorMsg,omitempty"   json:"errorMsg,omitempty"`
+	OutputLUFS      float64          `firestore:"outputLufs,omitempty" json:"outputLufs,omitempty"`                   // measured loudness of normalized audio
+	LoudnessApprox  bool             `firestore:"loudnessApproximate,omitempty" json:"loudnessApproximate,omitempty"` // levelled on a running estimate while streaming; may miss the target by ~1 LU
+	WebhookAttempts []WebhookAttempt `firestore:"webhookAttempts,omitempty" json:"webhookAttempts,omitempty"`
+	CreatedAt       time.Time        `firestore:"createdAt"   json:"createdAt"`
+	UpdatedAt       time.Time        `firestore:"updatedAt"   json:"updatedAt"`
 }
 
 // TTSTimepoint mirrors the iOS model: markName encodes char indices as
@@ -49,8 +75,16 @@ type JobStore interface {
 	Create(ctx context.Context, job *Job) error
 	Get(ctx context.Context, jobID string) (*Job, error)
 	SetProcessing(ctx context.Context, jobID string) error
-	SetCompleted(ctx context.Context, jobID, audioURL string, timepoints []TTSTimepoint) error
+	SetCompleted(ctx context.Context, jobID string, result *ProcessResult) error
 	SetFailed(ctx context.Context, jobID, errMsg string) error
+	AddWebhookAttempts(ctx context.Context, jobID string, attempts []WebhookAttempt) error
+}
+
+// UsageStore accumulates generated audio per owner for quota enforcement.
+// Get returns a zero free-tier Usage for owners with no recorded usage.
+type UsageStore interface {
+	Get(ctx context.Context, ownerID string) (*Usage, error)
+	Add(ctx context.Context, ownerID string, bytes int64, seconds float64) error
 }
 
 // TaskQueue enqueues a job ID for asynchronous processing.
@@ -58,9 +92,58 @@ type TaskQueue interface {
 	Enqueue(ctx context.Context, jobID string) error
 }
 
-// Notifier sends push notifications to a device.
+// Push is a push notification and how it should be delivered.
+type Push struct {
+	Title string // ignored when Silent
+	Body  string // ignored when Silent
+	Data  map[string]string
+	// Silent sends a data-only message without alert or sound that wakes
+	// the app in the background (APNs content-available), e.g. for progress.
+	Silent bool
+	// CollapseKey makes a newer notification replace a pending or shown one
+	// with the same key, so redeliveries don't stack. At most 64 bytes.
+	CollapseKey string
+	// ThreadID groups notifications in the iOS notification center.
+	ThreadID string
+	// TTL is how long an undelivered notification is kept; 0 leaves the
+	// push service's default.
+	TTL time.Duration
+}
+
+// Notifier sends push notifications to devices.
 type Notifier interface {
-	Send(ctx context.Context, deviceToken, title, body string, data map[string]string) error
+	// Send delivers push to every device in deviceTokens. It returns the
+	// tokens the push service reported as no longer registered, which
+	// callers should forget, and an error if any other delivery failed.
+	Send(ctx context.Context, deviceTokens []string, push Push) (unregistered []string, err error)
+}
+
+// EmailNotifier sends job notification emails.
+type EmailNotifier interface {
+	Send(ctx context.Context, to string, email Email) error
+}
+
+// DeviceTokenStore keeps the push tokens registered for each owner, so
+// notifications reach all of an owner's devices and not only the one that
+// submitted the job.
+type DeviceTokenStore interface {
+	List(ctx context.Context, ownerID string) ([]string, error)
+	Add(ctx context.Context, ownerID, token string) error
+	Remove(ctx context.Context, ownerID string, tokens ...string) error
+}
+
+// WebhookNotifier delivers a signed event to a job's callbackUrl, retrying
+// as it sees fit, and returns every attempt it made.
+type WebhookNotifier interface {
+	Deliver(ctx context.Context, callbackURL string, event WebhookEvent) ([]WebhookAttempt, error)
+}
+
+// JobEvents fans job events out to subscribers such as SSE clients.
+// Subscribe delivers events published for jobID after it returns, until ctx
+// is done or the subscriber falls too far behind; the channel is then closed.
+type JobEvents interface {
+	Publish(ctx context.Context, jobID string, event JobEvent) error
+	Subscribe(ctx context.Context, jobID string) (<-chan JobEvent, error)
 }
 
 // TTSGenerator generates audio and returns raw WAV bytes plus timepoints.
@@ -68,19 +151,35 @@ type TTSGenerator interface {
 	Generate(ctx context.Context, text string, voice *config.VoiceOption, language string) (audioWAV []byte, timepoints []TTSTimepoint, err error)
 }
 
+// UploadOptions carries the object attributes attached to an upload.
+type UploadOptions struct {
+	ContentType  string            // defaults to "audio/wav"
+	Metadata     map[string]string // custom object metadata, e.g. jobId and pcmSha256
+	CacheControl string            // empty leaves the storage default
+}
+
 // AudioStorage stores a WAV file and returns its public URL.
 type AudioStorage interface {
-	Upload(ctx context.Context, data []byte, filename string) (audioURL string, err error)
+	Upload(ctx context.Context, data []byte, filename string, opts UploadOptions) (audioURL string, err error)
 }
 
-// StreamingAudioStorage extends AudioStorage with a memory-efficient streaming
-// WAV upload. Instead of buffering all audio chunks in memory before uploading,
+// StreamingAudioStorage extends AudioStorage with memory-efficient streaming
+// uploads. Instead of buffering all audio chunks in memory before uploading,
 // implementations should stream PCM data directly to the backing store.
 //
 // fillPCM is invoked with two callbacks:
-//   - setHeader(wav44 []byte): called once with the first chunk's 44-byte WAV
-//     header so the implementation can record sample-rate / format info.
-//   - writePCM(pcm []byte): called for each chunk's raw PCM bytes (after offset 44).
+//   - setHeader(header []byte): called with a WAV header (wav.Header) before
+//     the first PCM so the implementation can record sample-rate / format
+//     info, and possibly again at the end with extra chunks such as chapter
+//     cues (wav.HeaderWithChunks). The last call wins.
+//   - writePCM(pcm []byte): called with each chunk's raw PCM (its data chunk).
+//
+// The final header should come from wav.ResizeHeader on the last header,
+// which fixes the sizes and switches to RF64 once the PCM no longer fits
+// 32-bit RIFF sizes.
+//
+// opts.Metadata is applied to the final object after fillPCM returns, so
+// fillPCM may add entries derived from the audio (duration, hash).
 //
 // Use this interface for production storage (e.g. GCS) to avoid OOM on large texts.
 type StreamingAudioStorage interface {
@@ -88,6 +187,52 @@ type StreamingAudioStorage interface {
 	UploadWAVStreaming(
 		ctx context.Context,
 		filename string,
+		opts UploadOptions,
 		fillPCM func(setHeader func([]byte), writePCM func([]byte)) error,
 	) (audioURL string, err error)
+
+	// UploadStreaming streams an already-encoded object (e.g. MP3, FLAC)
+	// written by fill. fill may call setHeader once with bytes to place in
+	// front of everything written to w, for formats whose header depends on
+	// the whole stream (FLAC STREAMINFO). As with UploadWAVStreaming,
+	// opts.Metadata is applied after fill returns.
+	UploadStreaming(
+		ctx context.Context,
+		filename string,
+		opts UploadOptions,
+		fill func(w io.Writer, setHeader func([]byte)) error,
+	) (audioURL string, err error)
+}
+
+// ErrAudioNotFound is returned by ReadableAudioStorage when the object does not exist.
+var ErrAudioNotFound = errors.New("audio object not found")
+
+// AudioObjectInfo describes a stored object for conditional and range requests.
+type AudioObjectInfo struct {
+	Size        int64
+	ContentType string
+	ETag        string
+	Updated     time.Time
+}
+
+// ReadableAudioStorage extends AudioStorage with random read access so audio
+// can be proxied to clients (e.g. AVPlayer seeking inside a long audiobook)
+// instead of handing out raw storage URLs.
+//
+// OpenRange returns a reader for length bytes starting at offset; a negative
+// length reads to the end of the object. Only the requested bytes should be
+// fetched from the backing store.
+type ReadableAudioStorage interface {
+	AudioStorage
+	Stat(ctx context.Context, filename string) (*AudioObjectInfo, error)
+	OpenRange(ctx context.Context, filename string, offset, length int64) (io.ReadCloser, error)
+}
+
+// NotificationLocale returns the locale for the job's notification texts:
+// the requested locale, else the language it is spoken in.
+func (j *Job) NotificationLocale() string {
+	if j.Locale != "" {
+		return j.Locale
+	}
+	return j.Language
 }
@@ -0,0 +1,178 @@
+package jobs
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/loudness"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+// Loudness target and limiter ceiling bounds accepted by Validate.
+const (
+	MinTargetLUFS       = -40.0
+	MaxTargetLUFS       = -5.0
+	MinTruePeakDBTP     = -9.0
+	defaultTruePeakDBTP = -1.0 // EBU R128 maximum true peak
+
+	// gainRampTime is how long a streaming gain change takes at the start
+	// of a piece. It is short, as the new gain already includes the piece.
+	gainRampTime = 0.25 // seconds
+)
+
+// Loudness enables EBU R128 loudness normalization of the generated
+// audio with a true-peak limiter.
+type Loudness struct {
+	TargetLUFS   float64 `firestore:"targetLufs"             json:"targetLufs"`             // e.g. -16 for mobile listening, -23 for broadcast
+	TruePeakDBTP float64 `firestore:"truePeakDbtp,omitempty" json:"truePeakDbtp,omitempty"` // limiter ceiling; 0 means -1 dBTP
+}
+
+// Validate checks the target and ceiling are within the supported ranges.
+func (o Loudness) Validate() error {
+	if o.TargetLUFS < MinTargetLUFS || o.TargetLUFS > MaxTargetLUFS {
+		return fmt.Errorf("targetLufs %.1f out of range %.0f to %.0f", o.TargetLUFS, MinTargetLUFS, MaxTargetLUFS)
+	}
+	if o.TruePeakDBTP < MinTruePeakDBTP || o.TruePeakDBTP > 0 {
+		return fmt.Errorf("truePeakDbtp %.1f out of range %.0f to 0", o.TruePeakDBTP, MinTruePeakDBTP)
+	}
+	return nil
+}
+
+func (o Loudness) ceiling() float64 {
+	if o.TruePeakDBTP == 0 {
+		return defaultTruePeakDBTP
+	}
+	return o.TruePeakDBTP
+}
+
+// pcmSource produces a job's audio by passing each piece of PCM, in order,
+// to emit.
+type pcmSource func(emit func(f wav.Format, pcm []byte) error) error
+
+// normalizeStreaming normalizes src one piece at a time, using the
+// integrated loudness of everything up to and including the current piece.
+// The gain settles on the job's loudness after the first few chunks without
+// holding any audio back beyond the limiter's lookahead, so the result only
+// approximates the target: early chunks are levelled on a partial
+// measurement. Each change of
+// gain is ramped in over gainRampTime, so the level glides instead of
+// stepping at chunk joins.
+func normalizeStreaming(src pcmSource, o Loudness) pcmSource {
+	return func(emit func(f wav.Format, pcm []byte) error) error {
+		var (
+			meter   *loudness.Meter
+			limiter *loudness.Limiter
+			format  wav.Format
+			gain    float64 // linear gain at the end of the previous piece
+		)
+		err := src(func(f wav.Format, pcm []byte) error {
+			chans, err := wav.Decode(pcm, f)
+			if err != nil {
+				return fmt.Errorf("loudness: %w", err)
+			}
+			if meter == nil {
+				meter = loudness.NewMeter(f.SampleRate, f.Channels)
+				limiter = loudness.NewLimiter(f.SampleRate, o.ceiling())
+				format = f
+			}
+			meter.Write(chans)
+			target := math.Pow(10, loudness.Gain(meter.Integrated(), o.TargetLUFS)/20)
+			if gain == 0 {
+				gain = target
+			}
+			rampGain(chans, gain, target, int(gainRampTime*float64(f.SampleRate)))
+			gain = target
+			return emitChannels(emit, f, limiter.Process(chans))
+		})
+		if err != nil || limiter == nil {
+			return err
+		}
+		return emitChannels(emit, format, limiter.Flush())
+	}
+}
+
+// normalizeBuffered measures the integrated loudness of the whole job
+// first and then applies one gain to every piece.
+func normalizeBuffered(src pcmSource, o Loudness) pcmSource {
+	return func(emit func(f wav.Format, pcm []byte) error) error {
+		type piece struct {
+			f   wav.Format
+			pcm []byte
+		}
+		var pieces []piece
+		var meter *loudness.Meter
+		err := src(func(f wav.Format, pcm []byte) error {
+			chans, err := wav.Decode(pcm, f)
+			if err != nil {
+				return fmt.Errorf("loudness: %w", err)
+			}
+			if meter == nil {
+				meter = loudness.NewMeter(f.SampleRate, f.Channels)
+			}
+			meter.Write(chans)
+			pieces = append(pieces, piece{f, pcm})
+			return nil
+		})
+		if err != nil || meter == nil {
+			return err
+		}
+
+		gain := math.Pow(10, loudness.Gain(meter.Integrated(), o.TargetLUFS)/20)
+		limiter := loudness.NewLimiter(pieces[0].f.SampleRate, o.ceiling())
+		for _, p := range pieces {
+			chans, err := wav.Decode(p.pcm, p.f)
+			if err != nil {
+				return fmt.Errorf("loudness: %w", err)
+			}
+			rampGain(chans, gain, gain, 0)
+			if err := emitChannels(emit, p.f, limiter.Process(chans)); err != nil {
+				return err
+			}
+		}
+		return emitChannels(emit, pieces[len(pieces)-1].f, limiter.Flush())
+	}
+}
+
+// meterSource passes src through unchanged, measuring its loudness into
+// *m, which is created for the format of the first piece.
+func meterSource(src pcmSource, m **loudness.Meter) pcmSource {
+	return func(emit func(f wav.Format, pcm []byte) error) error {
+		return src(func(f wav.Format, pcm []byte) error {
+			chans, err := wav.Decode(pcm, f)
+			if err != nil {
+				return fmt.Errorf("loudness: %w", err)
+			}
+			if *m == nil {
+				*m = loudness.NewMeter(f.SampleRate, f.Channels)
+			}
+			(*m).Write(chans)
+			return emit(f, pcm)
+		})
+	}
+}
+
+// rampGain scales the samples in place by a gain moving linearly from
+// `from` to `to` over the first n samples and holding `to` after that.
+func rampGain(chans [][]float64, from, to float64, n int) {
+	for _, x := range chans {
+		for i := range x {
+			g := to
+			if i < n {
+				g = from + (to-from)*float64(i+1)/float64(n)
+			}
+			x[i] *= g
+		}
+	}
+}
+
+// emitChannels encodes and emits limiter output, skipping empty output.
+func emitChannels(emit func(f wav.Format, pcm []byte) error, f wav.Format, chans [][]float64) error {
+	if len(chans) == 0 || len(chans[0]) == 0 {
+		return nil
+	}
+	out, err := wav.Encode(chans, f)
+	if err != nil {
+		return fmt.Errorf("loudness: %w", err)
+	}
+	return emit(f, out)
+}
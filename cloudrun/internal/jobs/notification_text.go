@@ -0,0 +1,228 @@
+package jobs
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultLocale is used when a job's locale is not in the message catalog.
+const DefaultLocale = "en"
+
+const (
+	maxLocaleLength = 35
+	// maxTitleRunes keeps a long document title from pushing the rest of
+	// the notification body off the lock screen.
+	maxTitleRunes = 60
+)
+
+// notificationMessages holds the push and email texts for one language.
+// The *Titled bodies are format strings taking the document title.
+type notificationMessages struct {
+	CompletedTitle  string
+	Completed       string
+	CompletedTitled string
+	FailedTitle     string
+	Failed          string
+	FailedTitled    string
+	Listen          string // email label for the audio link
+	Duration        string // email label for the audio length
+}
+
+// notificationCatalog is keyed by primary language subtag and covers every
+// language in config.AvailableVoices.
+var notificationCatalog = map[string]notificationMessages{
+	"en": {
+		CompletedTitle:  "Audio ready",
+		Completed:       "Your text-to-speech audio is ready.",
+		CompletedTitled: "Audio for \"%s\" is ready.",
+		FailedTitle:     "Audio generation failed",
+		Failed:          "We couldn't generate your audio.",
+		FailedTitled:    "We couldn't generate audio for \"%s\".",
+		Listen:          "Listen",
+		Duration:        "Duration",
+	},
+	"ja": {
+		CompletedTitle:  "音声生成完了",
+		Completed:       "テキストの読み上げ音声が生成されました",
+		CompletedTitled: "「%s」の読み上げ音声が生成されました",
+		FailedTitle:     "音声生成失敗",
+		Failed:          "音声の生成に失敗しました",
+		FailedTitled:    "「%s」の音声の生成に失敗しました",
+		Listen:          "音声",
+		Duration:        "再生時間",
+	},
+	"zh": {
+		CompletedTitle:  "音频已生成",
+		Completed:       "文本朗读音频已生成。",
+		CompletedTitled: "《%s》的朗读音频已生成。",
+		FailedTitle:     "音频生成失败",
+		Failed:          "无法生成音频。",
+		FailedTitled:    "无法生成《%s》的音频。",
+		Listen:          "音频",
+		Duration:        "时长",
+	},
+	"ko": {
+		CompletedTitle:  "오디오 생성 완료",
+		Completed:       "텍스트 읽기 오디오가 생성되었습니다.",
+		CompletedTitled: "\"%s\"의 읽기 오디오가 생성되었습니다.",
+		FailedTitle:     "오디오 생성 실패",
+		Failed:          "오디오를 생성하지 못했습니다.",
+		FailedTitled:    "\"%s\"의 오디오를 생성하지 못했습니다.",
+		Listen:          "오디오",
+		Duration:        "재생 시간",
+	},
+	"de": {
+		CompletedTitle:  "Audio fertig",
+		Completed:       "Deine vorgelesene Audiodatei ist fertig.",
+		CompletedTitled: "Die Audiodatei für „%s“ ist fertig.",
+		FailedTitle:     "Audioerstellung fehlgeschlagen",
+		Failed:          "Die Audiodatei konnte nicht erstellt werden.",
+		FailedTitled:    "Die Audiodatei für „%s“ konnte nicht erstellt werden.",
+		Listen:          "Anhören",
+		Duration:        "Dauer",
+	},
+	"es": {
+		CompletedTitle:  "Audio listo",
+		Completed:       "Tu audio de lectura está listo.",
+		CompletedTitled: "El audio de «%s» está listo.",
+		FailedTitle:     "Error al generar el audio",
+		Failed:          "No se pudo generar el audio.",
+		FailedTitled:    "No se pudo generar el audio de «%s».",
+		Listen:          "Escuchar",
+		Duration:        "Duración",
+	},
+	"fr": {
+		CompletedTitle:  "Audio prêt",
+		Completed:       "Votre audio de lecture est prêt.",
+		CompletedTitled: "L’audio de « %s » est prêt.",
+		FailedTitle:     "Échec de la génération audio",
+		Failed:          "Impossible de générer l’audio.",
+		FailedTitled:    "Impossible de générer l’audio de « %s ».",
+		Listen:          "Écouter",
+		Duration:        "Durée",
+	},
+	"it": {
+		CompletedTitle:  "Audio pronto",
+		Completed:       "Il tuo audio di lettura è pronto.",
+		CompletedTitled: "L'audio di \"%s\" è pronto.",
+		FailedTitle:     "Generazione audio non riuscita",
+		Failed:          "Impossibile generare l'audio.",
+		FailedTitled:    "Impossibile generare l'audio di \"%s\".",
+		Listen:          "Ascolta",
+		Duration:        "Durata",
+	},
+	"pt": {
+		CompletedTitle:  "Áudio pronto",
+		Completed:       "Seu áudio de leitura está pronto.",
+		CompletedTitled: "O áudio de \"%s\" está pronto.",
+		FailedTitle:     "Falha ao gerar o áudio",
+		Failed:          "Não foi possível gerar o áudio.",
+		FailedTitled:    "Não foi possível gerar o áudio de \"%s\".",
+		Listen:          "Ouvir",
+		Duration:        "Duração",
+	},
+	"ru": {
+		CompletedTitle:  "Аудио готово",
+		Completed:       "Озвучка текста готова.",
+		CompletedTitled: "Озвучка «%s» готова.",
+		FailedTitle:     "Не удалось создать аудио",
+		Failed:          "Не удалось создать озвучку.",
+		FailedTitled:    "Не удалось создать озвучку «%s».",
+		Listen:          "Слушать",
+		Duration:        "Длительность",
+	},
+	"th": {
+		CompletedTitle:  "เสียงพร้อมแล้ว",
+		Completed:       "เสียงอ่านข้อความของคุณพร้อมแล้ว",
+		CompletedTitled: "เสียงอ่านของ \"%s\" พร้อมแล้ว",
+		FailedTitle:     "สร้างเสียงไม่สำเร็จ",
+		Failed:          "ไม่สามารถสร้างเสียงได้",
+		FailedTitled:    "ไม่สามารถสร้างเสียงของ \"%s\" ได้",
+		Listen:          "ฟังเสียง",
+		Duration:        "ความยาว",
+	},
+	"tr": {
+		CompletedTitle:  "Ses hazır",
+		Completed:       "Metin okuma sesiniz hazır.",
+		CompletedTitled: "\"%s\" için ses hazır.",
+		FailedTitle:     "Ses oluşturulamadı",
+		Failed:          "Sesiniz oluşturulurken bir hata oluştu.",
+		FailedTitled:    "\"%s\" için ses oluşturulurken bir hata oluştu.",
+		Listen:          "Dinle",
+		Duration:        "Süre",
+	},
+	"vi": {
+		CompletedTitle:  "Âm thanh đã sẵn sàng",
+		Completed:       "Âm thanh đọc văn bản của bạn đã sẵn sàng.",
+		CompletedTitled: "Âm thanh cho \"%s\" đã sẵn sàng.",
+		FailedTitle:     "Tạo âm thanh thất bại",
+		Failed:          "Không thể tạo âm thanh.",
+		FailedTitled:    "Không thể tạo âm thanh cho \"%s\".",
+		Listen:          "Nghe",
+		Duration:        "Thời lượng",
+	},
+}
+
+// ValidateLocale checks that s looks like a BCP 47 language tag such as
+// "de", "pt-BR" or "zh-Hans-CN"; underscores are accepted as separators.
+// Unknown languages are valid and get DefaultLocale texts.
+func ValidateLocale(s string) error {
+	if len(s) > maxLocaleLength {
+		return fmt.Errorf("locale longer than %d bytes", maxLocaleLength)
+	}
+	for i, sub := range localeSubtags(s) {
+		if len(sub) > 8 || len(sub) < 1 || i == 0 && len(sub) < 2 || strings.IndexFunc(sub, notAlnum) >= 0 {
+			return fmt.Errorf("invalid locale %q", s)
+		}
+	}
+	return nil
+}
+
+func localeSubtags(s string) []string {
+	return strings.Split(strings.ReplaceAll(s, "_", "-"), "-")
+}
+
+func notAlnum(c rune) bool {
+	return !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9')
+}
+
+// ResolveLocale returns the catalog language for locale: its primary
+// language subtag when the catalog has it, else DefaultLocale. "cmn", as in
+// the cmn-CN voices, is treated as "zh".
+func ResolveLocale(locale string) string {
+	primary := strings.ToLower(localeSubtags(locale)[0])
+	if primary == "cmn" {
+		primary = "zh"
+	}
+	if _, ok := notificationCatalog[primary]; ok {
+		return primary
+	}
+	return DefaultLocale
+}
+
+// NotificationText returns the push title and body announcing that a job
+// reached status (completed or failed), in locale. A non-empty docTitle is
+// quoted in the body, shortened if it is long.
+func NotificationText(locale string, status JobStatus, docTitle string) (title, body string) {
+	m := notificationCatalog[ResolveLocale(locale)]
+	docTitle = shortenTitle(strings.TrimSpace(docTitle))
+	switch {
+	case status == JobStatusFailed && docTitle != "":
+		return m.FailedTitle, fmt.Sprintf(m.FailedTitled, docTitle)
+	case status == JobStatusFailed:
+		return m.FailedTitle, m.Failed
+	case docTitle != "":
+		return m.CompletedTitle, fmt.Sprintf(m.CompletedTitled, docTitle)
+	default:
+		return m.CompletedTitle, m.Completed
+	}
+}
+
+func shortenTitle(s string) string {
+	if utf8.RuneCountInString(s) <= maxTitleRunes {
+		return s
+	}
+	r := []rune(s)
+	return strings.TrimSpace(string(r[:maxTitleRunes-1])) + "…"
+}
@@ -0,0 +1,73 @@
+package jobs_test
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+)
+
+func TestNotificationText_CoversVoiceLanguages(t *testing.T) {
+	enTitle, _ := jobs.NotificationText("en", jobs.JobStatusCompleted, "")
+	for _, v := range config.AvailableVoices {
+		if strings.HasPrefix(v.Language, "en-") {
+			continue
+		}
+		if got := jobs.ResolveLocale(v.Language); got == jobs.DefaultLocale {
+			t.Errorf("%s (%s): no catalog entry", v.Language, v.ID)
+		}
+		for _, status := range []jobs.JobStatus{jobs.JobStatusCompleted, jobs.JobStatusFailed} {
+			title, body := jobs.NotificationText(v.Language, status, "Doc")
+			if title == "" || title == enTitle || !strings.Contains(body, "Doc") || strings.Contains(body, "%!") {
+				t.Errorf("%s %s: %q / %q", v.Language, status, title, body)
+			}
+		}
+	}
+}
+
+func TestNotificationText(t *testing.T) {
+	tests := []struct {
+		locale   string
+		status   jobs.JobStatus
+		docTitle string
+		title    string
+		body     string
+	}{
+		{"ja-JP", jobs.JobStatusCompleted, "", "音声生成完了", "テキストの読み上げ音声が生成されました"},
+		{"ja", jobs.JobStatusFailed, "議事録", "音声生成失敗", "「議事録」の音声の生成に失敗しました"},
+		{"de_AT", jobs.JobStatusCompleted, " Bericht ", "Audio fertig", "Die Audiodatei für „Bericht“ ist fertig."},
+		{"PT-br", jobs.JobStatusFailed, "", "Falha ao gerar o áudio", "Não foi possível gerar o áudio."},
+		{"zh-Hans-CN", jobs.JobStatusCompleted, "", "音频已生成", "文本朗读音频已生成。"},
+		{"cmn-CN", jobs.JobStatusCompleted, "", "音频已生成", "文本朗读音频已生成。"},
+		{"nl-NL", jobs.JobStatusCompleted, "Notes", "Audio ready", `Audio for "Notes" is ready.`},
+		{"", jobs.JobStatusFailed, "", "Audio generation failed", "We couldn't generate your audio."},
+	}
+	for _, tt := range tests {
+		title, body := jobs.NotificationText(tt.locale, tt.status, tt.docTitle)
+		if title != tt.title || body != tt.body {
+			t.Errorf("NotificationText(%q, %s, %q) = %q, %q; want %q, %q", tt.locale, tt.status, tt.docTitle, title, body, tt.title, tt.body)
+		}
+	}
+}
+
+func TestNotificationText_LongTitle(t *testing.T) {
+	_, body := jobs.NotificationText("en", jobs.JobStatusCompleted, strings.Repeat("長", 200))
+	if n := utf8.RuneCountInString(body); n > 100 || !strings.Contains(body, "…") {
+		t.Errorf("body = %q (%d runes), want the title shortened", body, n)
+	}
+}
+
+func TestValidateLocale(t *testing.T) {
+	for _, s := range []string{"en", "ja-JP", "pt_BR", "zh-Hans-CN", "es-419", "fil"} {
+		if err := jobs.ValidateLocale(s); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+	for _, s := range []string{"e", "en-", "-en", "en--US", "en US", "ja-JP;q=1", "日本語", strings.Repeat("a", 40)} {
+		if err := jobs.ValidateLocale(s); err == nil {
+			t.Errorf("%q: accepted, want an error", s)
+		}
+	}
+}
@@ -3,10 +3,15 @@ package jobs
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"time"
 
 	"firebase.google.com/go/v4/messaging"
 )
 
+// fcmMulticastLimit is the most tokens FCM accepts in one multicast request.
+const fcmMulticastLimit = 500
+
 // FCMNotifier is the FCM-backed implementation of Notifier.
 type FCMNotifier struct {
 	client *messaging.Client
@@ -17,26 +22,68 @@ func NewFCMNotifier(client *messaging.Client) *FCMNotifier {
 	return &FCMNotifier{client: client}
 }
 
-func (n *FCMNotifier) Send(ctx context.Context, deviceToken, title, body string, data map[string]string) error {
-	msg := &messaging.Message{
-		Token: deviceToken,
-		Notification: &messaging.Notification{
-			Title: title,
-			Body:  body,
-		},
-		Data: data,
-		APNS: &messaging.APNSConfig{
-			Payload: &messaging.APNSPayload{
-				Aps: &messaging.Aps{
-					Sound: "default",
-				},
-			},
-		},
+func (n *FCMNotifier) Send(ctx context.Context, deviceTokens []string, push Push) ([]string, error) {
+	var unregistered []string
+	var failed int
+	var firstErr error
+	for len(deviceTokens) > 0 {
+		batch := deviceTokens[:min(len(deviceTokens), fcmMulticastLimit)]
+		deviceTokens = deviceTokens[len(batch):]
+
+		resp, err := n.client.SendEachForMulticast(ctx, fcmMessage(batch, push))
+		if err != nil {
+			return unregistered, fmt.Errorf("FCM multicast to %d devices: %w", len(batch), err)
+		}
+		for i, r := range resp.Responses {
+			switch {
+			case r.Success:
+			case messaging.IsUnregistered(r.Error):
+				unregistered = append(unregistered, batch[i])
+			default:
+				failed++
+				if firstErr == nil {
+					firstErr = r.Error
+				}
+			}
+		}
+	}
+	if firstErr != nil {
+		return unregistered, fmt.Errorf("FCM multicast: %d devices failed: %w", failed, firstErr)
+	}
+	return unregistered, nil
+}
+
+// fcmMessage maps push onto the FCM Android and APNs options.
+func fcmMessage(tokens []string, push Push) *messaging.MulticastMessage {
+	android := &messaging.AndroidConfig{CollapseKey: push.CollapseKey}
+	aps := &messaging.Aps{}
+	headers := map[string]string{}
+	msg := &messaging.MulticastMessage{
+		Tokens:  tokens,
+		Data:    push.Data,
+		Android: android,
+		APNS:    &messaging.APNSConfig{Headers: headers, Payload: &messaging.APNSPayload{Aps: aps}},
 	}
 
-	_, err := n.client.Send(ctx, msg)
-	if err != nil {
-		return fmt.Errorf("FCM send to %s: %w", deviceToken, err)
+	if push.Silent {
+		// Background pushes must be sent at low priority on both platforms.
+		aps.ContentAvailable = true
+		android.Priority = "normal"
+		headers["apns-push-type"] = "background"
+		headers["apns-priority"] = "5"
+	} else {
+		msg.Notification = &messaging.Notification{Title: push.Title, Body: push.Body}
+		aps.Sound = "default"
+		aps.ThreadID = push.ThreadID
+		headers["apns-push-type"] = "alert"
+	}
+	if push.CollapseKey != "" {
+		headers["apns-collapse-id"] = push.CollapseKey
+	}
+	if push.TTL > 0 {
+		ttl := push.TTL
+		android.TTL = &ttl
+		headers["apns-expiration"] = strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
 	}
-	return nil
+	return msg
 }
@@ -0,0 +1,146 @@
+package jobs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	firebase "firebase.google.com/go/v4"
+	"google.golang.org/api/option"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+)
+
+// fcmRequest is the subset of an FCM v1 send request the tests inspect.
+type fcmRequest struct {
+	Message struct {
+		Token        string            `json:"token"`
+		Data         map[string]string `json:"data"`
+		Notification *struct {
+			Title string `json:"title"`
+			Body  string `json:"body"`
+		} `json:"notification"`
+		Android struct {
+			CollapseKey string `json:"collapse_key"`
+			Priority    string `json:"priority"`
+			TTL         string `json:"ttl"`
+		} `json:"android"`
+		APNS struct {
+			Headers map[string]string `json:"headers"`
+			Payload struct {
+				Aps map[string]any `json:"aps"`
+			} `json:"payload"`
+		} `json:"apns"`
+	} `json:"message"`
+}
+
+// newFakeFCM returns an FCMNotifier talking to a local FCM stand-in that
+// records requests and reports the tokens in dead as unregistered.
+func newFakeFCM(t *testing.T, dead ...string) (*jobs.FCMNotifier, func() []fcmRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var reqs []fcmRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req fcmRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode FCM request: %v", err)
+		}
+		mu.Lock()
+		reqs = append(reqs, req)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if slices.Contains(dead, req.Message.Token) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"status":"NOT_FOUND","message":"Requested entity was not found.",` +
+				`"details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`))
+			return
+		}
+		w.Write([]byte(`{"name":"projects/test/messages/1"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx := context.Background()
+	app, err := firebase.NewApp(ctx, &firebase.Config{ProjectID: "test"}, option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("firebase.NewApp: %v", err)
+	}
+	client, err := app.Messaging(ctx)
+	if err != nil {
+		t.Fatalf("Messaging: %v", err)
+	}
+	return jobs.NewFCMNotifier(client), func() []fcmRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(reqs)
+	}
+}
+
+func TestFCMNotifier_Alert(t *testing.T) {
+	n, requests := newFakeFCM(t, "dead")
+	push := jobs.Push{
+		Title:       "Audio ready",
+		Body:        "Your text-to-speech audio is ready.",
+		Data:        map[string]string{"jobId": "job-1"},
+		CollapseKey: "job-job-1",
+		ThreadID:    "file-1",
+		TTL:         time.Hour,
+	}
+
+	unregistered, err := n.Send(context.Background(), []string{"iphone", "dead", "ipad"}, push)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if !slices.Equal(unregistered, []string{"dead"}) {
+		t.Errorf("unregistered = %v, want [dead]", unregistered)
+	}
+
+	reqs := requests()
+	if len(reqs) != 3 {
+		t.Fatalf("FCM got %d requests, want one per token", len(reqs))
+	}
+	m := reqs[0].Message
+	if m.Notification == nil || m.Notification.Title != "Audio ready" || m.Data["jobId"] != "job-1" {
+		t.Errorf("notification = %+v, data = %v", m.Notification, m.Data)
+	}
+	if m.Android.CollapseKey != "job-job-1" || m.Android.TTL != "3600s" {
+		t.Errorf("android = %+v", m.Android)
+	}
+	h := m.APNS.Headers
+	if h["apns-collapse-id"] != "job-job-1" || h["apns-push-type"] != "alert" {
+		t.Errorf("apns headers = %v", h)
+	}
+	if exp, _ := strconv.ParseInt(h["apns-expiration"], 10, 64); time.Until(time.Unix(exp, 0)) < 59*time.Minute {
+		t.Errorf("apns-expiration = %q, want about an hour from now", h["apns-expiration"])
+	}
+	if aps := m.APNS.Payload.Aps; aps["sound"] != "default" || aps["thread-id"] != "file-1" || aps["content-available"] != nil {
+		t.Errorf("aps = %v", aps)
+	}
+}
+
+func TestFCMNotifier_Silent(t *testing.T) {
+	n, requests := newFakeFCM(t)
+	push := jobs.Push{Title: "ignored", Data: map[string]string{"chunk": "1"}, Silent: true, ThreadID: "file-1"}
+
+	if _, err := n.Send(context.Background(), []string{"iphone"}, push); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	m := requests()[0].Message
+	if m.Notification != nil || m.Data["chunk"] != "1" {
+		t.Errorf("notification = %+v, data = %v, want data only", m.Notification, m.Data)
+	}
+	if m.Android.Priority != "normal" || m.Android.TTL != "" || m.Android.CollapseKey != "" {
+		t.Errorf("android = %+v", m.Android)
+	}
+	if h := m.APNS.Headers; h["apns-push-type"] != "background" || h["apns-priority"] != "5" || h["apns-expiration"] != "" {
+		t.Errorf("apns headers = %v", h)
+	}
+	if aps := m.APNS.Payload.Aps; aps["content-available"] != float64(1) || aps["sound"] != nil || aps["thread-id"] != nil {
+		t.Errorf("aps = %v", aps)
+	}
+}
@@ -0,0 +1,220 @@
+package jobs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/flac"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/mp3"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+// OutputFormat selects the container/codec of the stored audio.
+type OutputFormat string
+
+const (
+	OutputFormatWAV  OutputFormat = "wav"
+	OutputFormatMP3  OutputFormat = "mp3"
+	OutputFormatFLAC OutputFormat = "flac"
+	OutputFormatHLS  OutputFormat = "hls" // MP3 segments behind an EVENT playlist
+)
+
+// ParseOutputFormat validates a client-supplied format name. An empty string
+// selects WAV, the historical default.
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch f := OutputFormat(strings.ToLower(s)); f {
+	case "":
+		return OutputFormatWAV, nil
+	case OutputFormatWAV, OutputFormatMP3, OutputFormatFLAC, OutputFormatHLS:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unsupported output format %q", s)
+	}
+}
+
+// ResolveBitrate returns the encoder bitrate (kbps) for format, applying the
+// default when kbps is zero. Bitrates only apply to MP3 (including HLS,
+// whose segments are MP3).
+func ResolveBitrate(format OutputFormat, kbps int) (int, error) {
+	if format != OutputFormatMP3 && format != OutputFormatHLS {
+		if kbps != 0 {
+			return 0, fmt.Errorf("bitrate is only supported for mp3 output")
+		}
+		return 0, nil
+	}
+	if kbps == 0 {
+		return mp3.DefaultBitrate, nil
+	}
+	if !mp3.IsSupportedBitrate(kbps) {
+		return 0, fmt.Errorf("unsupported mp3 bitrate %d kbps (supported: %v)", kbps, mp3.SupportedBitrates)
+	}
+	return kbps, nil
+}
+
+// ContentType returns the MIME type stored with the object.
+func (f OutputFormat) ContentType() string {
+	switch f {
+	case OutputFormatMP3:
+		return "audio/mpeg"
+	case OutputFormatFLAC:
+		return "audio/flac"
+	case OutputFormatHLS:
+		return hlsContentType
+	default:
+		return "audio/wav"
+	}
+}
+
+// Extension returns the file extension without the leading dot.
+func (f OutputFormat) Extension() string {
+	switch f {
+	case OutputFormatMP3, OutputFormatFLAC:
+		return string(f)
+	case OutputFormatHLS:
+		return "m3u8"
+	default:
+		return "wav"
+	}
+}
+
+// EncodeOptions configures encoding to a compressed output format.
+type EncodeOptions struct {
+	Bitrate  int                 // kbps, mp3 only; 0 selects the default
+	Title    string              // tagged where the format supports it
+	Voice    *config.VoiceOption // tagged as artist and voice ID
+	Language string
+}
+
+// vorbisComments returns the FLAC tags describing the audio.
+func (o EncodeOptions) vorbisComments() []string {
+	var c []string
+	if o.Title != "" {
+		c = append(c, "TITLE="+o.Title)
+	}
+	if o.Voice != nil {
+		c = append(c, "ARTIST="+o.Voice.Name, "VOICE="+o.Voice.ID)
+	}
+	if o.Language != "" {
+		c = append(c, "LANGUAGE="+o.Language)
+	}
+	return c
+}
+
+// audioEncoder is a streaming encoder for one output format. Header returns
+// bytes that must precede everything written to the underlying writer; it is
+// only valid after Close (FLAC's STREAMINFO needs the sample count and MD5).
+type audioEncoder interface {
+	io.WriteCloser
+	Header() []byte
+}
+
+// newAudioEncoder returns an encoder writing PCM in pcmFormat to w in format.
+func newAudioEncoder(w io.Writer, format OutputFormat, opts EncodeOptions, pcmFormat wav.Format) (audioEncoder, error) {
+	if pcmFormat.AudioFormat != wav.FormatPCM || pcmFormat.BitsPerSample != 16 {
+		return nil, fmt.Errorf("%s encoding requires 16-bit integer PCM, got format %d at %d bits",
+			format, pcmFormat.AudioFormat, pcmFormat.BitsPerSample)
+	}
+
+	switch format {
+	case OutputFormatMP3:
+		bitrate := opts.Bitrate
+		if bitrate == 0 {
+			bitrate = mp3.DefaultBitrate
+		}
+		enc, err := mp3.NewEncoder(w, pcmFormat.SampleRate, pcmFormat.Channels, bitrate)
+		if err != nil {
+			return nil, err
+		}
+		return noHeader{enc}, nil
+	case OutputFormatFLAC:
+		return flac.NewEncoder(w, pcmFormat.SampleRate, pcmFormat.Channels, pcmFormat.BitsPerSample, opts.vorbisComments()...)
+	default:
+		return nil, fmt.Errorf("no encoder for output format %q", format)
+	}
+}
+
+// noHeader adapts encoders whose output is self-contained.
+type noHeader struct{ io.WriteCloser }
+
+func (noHeader) Header() []byte { return nil }
+
+// encoderDelay returns how far (in seconds) the decoded output of format
+// lags its input, so timepoints can be shifted to match.
+func encoderDelay(format OutputFormat, pcmFormat wav.Format) float64 {
+	if format != OutputFormatMP3 || pcmFormat.SampleRate == 0 {
+		return 0
+	}
+	return float64(mp3.EncoderDelay) / float64(pcmFormat.SampleRate)
+}
+
+// encodeChunks runs synthesize with a sink that feeds every chunk's PCM into
+// one format encoder on w. The encoder is created from the first chunk's
+// format, since the sample rate is only known once TTS has run. It returns
+// the encoder delay in seconds and the stream header to prepend to w.
+func encodeChunks(
+	w io.Writer,
+	format OutputFormat,
+	opts EncodeOptions,
+	synthesize func(emit func(f wav.Format, pcm []byte) error) error,
+) (delay float64, header []byte, err error) {
+	var enc audioEncoder
+	err = synthesize(func(f wav.Format, pcm []byte) error {
+		if enc == nil {
+			var err error
+			if enc, err = newAudioEncoder(w, format, opts, f); err != nil {
+				return fmt.Errorf("create %s encoder: %w", format, err)
+			}
+			delay = encoderDelay(format, f)
+		}
+		if _, err := enc.Write(pcm); err != nil {
+			return fmt.Errorf("encode %s: %w", format, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if enc == nil {
+		return 0, nil, fmt.Errorf("no audio data produced")
+	}
+	if err := enc.Close(); err != nil {
+		return 0, nil, fmt.Errorf("encode %s: %w", format, err)
+	}
+	return delay, enc.Header(), nil
+}
+
+// EncodeAudio converts a complete WAV file into format and returns the
+// encoder delay in seconds, which callers add to timepoints. WAV input is
+// returned unchanged when format is WAV.
+func EncodeAudio(wavData []byte, format OutputFormat, opts EncodeOptions) ([]byte, float64, error) {
+	if format == OutputFormatWAV || format == "" {
+		return wavData, 0, nil
+	}
+	info, err := wav.Parse(wavData)
+	if err != nil {
+		return nil, 0, err
+	}
+	var buf bytes.Buffer
+	delay, header, err := encodeChunks(&buf, format, opts, func(emit func(wav.Format, []byte) error) error {
+		return emit(info.Format, info.Data(wavData))
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return append(header, buf.Bytes()...), delay, nil
+}
+
+// countingWriter tracks how many bytes pass through to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,84 @@
+package jobs_test
+
+import (
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/mp3"
+)
+
+func TestParseOutputFormat(t *testing.T) {
+	cases := map[string]jobs.OutputFormat{
+		"":     jobs.OutputFormatWAV,
+		"wav":  jobs.OutputFormatWAV,
+		"MP3":  jobs.OutputFormatMP3,
+		"flac": jobs.OutputFormatFLAC,
+	}
+	for in, want := range cases {
+		got, err := jobs.ParseOutputFormat(in)
+		if err != nil || got != want {
+			t.Errorf("ParseOutputFormat(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := jobs.ParseOutputFormat("ogg"); err == nil {
+		t.Error("expected error for unsupported format")
+	}
+}
+
+func TestResolveBitrate(t *testing.T) {
+	if got, err := jobs.ResolveBitrate(jobs.OutputFormatMP3, 0); err != nil || got != mp3.DefaultBitrate {
+		t.Errorf("default mp3 bitrate = %d, %v", got, err)
+	}
+	if got, err := jobs.ResolveBitrate(jobs.OutputFormatMP3, 128); err != nil || got != 128 {
+		t.Errorf("128 kbps = %d, %v", got, err)
+	}
+	if _, err := jobs.ResolveBitrate(jobs.OutputFormatMP3, 100); err == nil {
+		t.Error("expected error for unsupported bitrate")
+	}
+	if _, err := jobs.ResolveBitrate(jobs.OutputFormatFLAC, 64); err == nil {
+		t.Error("expected error for bitrate on flac output")
+	}
+	if _, err := jobs.ResolveBitrate(jobs.OutputFormatWAV, 64); err == nil {
+		t.Error("expected error for bitrate on wav output")
+	}
+}
+
+func TestOutputFormat_ContentTypeAndExtension(t *testing.T) {
+	if jobs.OutputFormatMP3.ContentType() != "audio/mpeg" || jobs.OutputFormatMP3.Extension() != "mp3" {
+		t.Error("unexpected mp3 content type or extension")
+	}
+	if jobs.OutputFormatFLAC.ContentType() != "audio/flac" || jobs.OutputFormatFLAC.Extension() != "flac" {
+		t.Error("unexpected flac content type or extension")
+	}
+	if jobs.OutputFormatWAV.ContentType() != "audio/wav" || jobs.OutputFormatWAV.Extension() != "wav" {
+		t.Error("unexpected wav content type or extension")
+	}
+}
+
+func TestEncodeAudio(t *testing.T) {
+	src := makeWAV(24000, 1, 16, 24000)
+	out, delay, err := jobs.EncodeAudio(src, jobs.OutputFormatWAV, jobs.EncodeOptions{})
+	if err != nil || len(out) != len(src) || delay != 0 {
+		t.Fatalf("wav passthrough: %d bytes, %v", len(out), err)
+	}
+	out, delay, err = jobs.EncodeAudio(src, jobs.OutputFormatMP3, jobs.EncodeOptions{Bitrate: 64})
+	if err != nil {
+		t.Fatalf("EncodeAudio: %v", err)
+	}
+	if want := float64(mp3.EncoderDelay) / 24000; delay != want {
+		t.Errorf("delay = %f, want %f", delay, want)
+	}
+	if len(out) < 2 || out[0] != 0xFF || out[1]&0xE0 != 0xE0 {
+		t.Error("output does not start with an MPEG frame sync")
+	}
+	out, _, err = jobs.EncodeAudio(src, jobs.OutputFormatFLAC, jobs.EncodeOptions{})
+	if err != nil {
+		t.Fatalf("EncodeAudio flac: %v", err)
+	}
+	if string(out[:4]) != "fLaC" {
+		t.Error("flac output does not start with the fLaC marker")
+	}
+	if _, _, err := jobs.EncodeAudio(makeWAV(24000, 1, 8, 100), jobs.OutputFormatMP3, jobs.EncodeOptions{}); err == nil {
+		t.Error("expected error for 8-bit PCM")
+	}
+}
@@ -0,0 +1,139 @@
+package jobs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// MaxPauseMs caps each configured pause.
+const MaxPauseMs = 10_000
+
+// Pauses configures silence (in milliseconds) inserted into the generated
+// audio. Zero leaves the TTS engine's own spacing.
+type Pauses struct {
+	ParagraphMs int `firestore:"paragraphMs,omitempty" json:"paragraphMs,omitempty"` // after each paragraph
+	HeadingMs   int `firestore:"headingMs,omitempty"   json:"headingMs,omitempty"`   // before and after chapter headings
+	ChunkMs     int `firestore:"chunkMs,omitempty"     json:"chunkMs,omitempty"`     // at every other chunk join
+}
+
+// Validate checks every pause is within [0, MaxPauseMs].
+func (p Pauses) Validate() error {
+	for _, ms := range []int{p.ParagraphMs, p.HeadingMs, p.ChunkMs} {
+		if ms < 0 || ms > MaxPauseMs {
+			return fmt.Errorf("pause %dms out of range 0-%d", ms, MaxPauseMs)
+		}
+	}
+	return nil
+}
+
+// headingRegex matches lines that start a chapter or section: Markdown
+// headings, 第N章/話/節/部/幕, "Chapter N", and prologue/epilogue markers.
+var headingRegex = regexp.MustCompile(`^(#{1,6}\s|第[0-9０-９一二三四五六七八九十百千]+[章話節部幕]|(?i:chapter)\s+[0-9ivxlc]+\b|(?i:prologue|epilogue)\b|プロローグ|エピローグ)`)
+
+// isHeading reports whether line (without its newline) looks like a heading.
+func isHeading(line string) bool {
+	line = strings.TrimSpace(line)
+	return line != "" && len(line) <= 200 && headingRegex.MatchString(line)
+}
+
+// startsParagraph reports whether line begins an indented paragraph
+// (full-width space, tab or two spaces), the convention in Japanese text.
+func startsParagraph(line string) bool {
+	return strings.HasPrefix(line, "　") || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "  ")
+}
+
+// SplitTextWithPauses splits text like SplitText and sets each chunk's
+// Pause. With paragraph or heading pauses configured, chunks also end at
+// those boundaries so the silence can be inserted between TTS calls; a
+// paragraph break is a blank line or a newline followed by an indented line.
+// Where several pauses coincide the longest one wins.
+func SplitTextWithPauses(text string, maxBytes int, p Pauses) []TextChunk {
+	var chunks []TextChunk
+	for _, b := range splitBlocks(text, p) {
+		for i, c := range SplitText(b.text, maxBytes) {
+			c.CharOffset += b.offset
+			if i == 0 {
+				c.Pause = b.pause
+			}
+			chunks = append(chunks, c)
+		}
+	}
+	for i := range chunks {
+		if i == 0 {
+			chunks[i].Pause = 0
+		} else {
+			chunks[i].Pause = max(chunks[i].Pause, millis(p.ChunkMs))
+		}
+	}
+	return chunks
+}
+
+type textBlock struct {
+	text   string
+	offset int           // rune offset into the original text
+	pause  time.Duration // silence before this block
+}
+
+// splitBlocks cuts text at paragraph breaks and around headings, keeping
+// whitespace-only runs attached to the preceding block.
+func splitBlocks(text string, p Pauses) []textBlock {
+	if (p.ParagraphMs == 0 && p.HeadingMs == 0) || text == "" {
+		return []textBlock{{text: text}}
+	}
+
+	// cuts maps a rune offset to the pause starting there.
+	cuts := map[int]time.Duration{}
+	cut := func(at int, d time.Duration) {
+		if d > 0 {
+			cuts[at] = max(cuts[at], d)
+		}
+	}
+	lines := strings.SplitAfter(text, "\n")
+	pos := 0
+	for i, line := range lines {
+		n := len([]rune(line))
+		body := strings.TrimRight(line, "\r\n")
+		switch {
+		case isHeading(body):
+			cut(pos, millis(p.HeadingMs))
+			cut(pos+n, millis(p.HeadingMs))
+		case strings.TrimSpace(body) == "":
+			cut(pos+n, millis(p.ParagraphMs))
+		case i+1 < len(lines) && startsParagraph(lines[i+1]):
+			cut(pos+n, millis(p.ParagraphMs))
+		}
+		pos += n
+	}
+
+	runes := []rune(text)
+	var blocks []textBlock
+	var pending time.Duration
+	start := 0
+	for end := 1; end <= len(runes); end++ {
+		d, isCut := cuts[end]
+		if !isCut && end < len(runes) {
+			continue
+		}
+		part := string(runes[start:end])
+		if len(blocks) > 0 && strings.TrimFunc(part, unicode.IsSpace) == "" {
+			// Nothing to speak: fold into the previous block and carry the pause.
+			blocks[len(blocks)-1].text += part
+			pending = max(pending, d)
+		} else if len(blocks) == 0 && strings.TrimFunc(part, unicode.IsSpace) == "" && end < len(runes) {
+			// Leading whitespace: keep it with the first spoken block.
+			continue
+		} else {
+			blocks = append(blocks, textBlock{text: part, offset: start, pause: pending})
+			pending = d
+		}
+		start = end
+	}
+	return blocks
+}
+
+func millis(n int) time.Duration {
+	return time.Duration(n) * time.Millisecond
+}
@@ -0,0 +1,104 @@
+package jobs_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+)
+
+func joinChunks(chunks []jobs.TextChunk) string {
+	var b strings.Builder
+	for _, c := range chunks {
+		b.WriteString(c.Text)
+	}
+	return b.String()
+}
+
+func TestSplitTextWithPauses_NoPausesMatchesSplitText(t *testing.T) {
+	text := strings.Repeat("あいうえお。\n\n", 300)
+	got := jobs.SplitTextWithPauses(text, 4500, jobs.Pauses{})
+	want := jobs.SplitText(text, 4500)
+	if len(got) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("chunk %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSplitTextWithPauses_ChunkPause(t *testing.T) {
+	text := strings.Repeat("あいうえお。", 400)
+	chunks := jobs.SplitTextWithPauses(text, 1000, jobs.Pauses{ChunkMs: 300})
+	if len(chunks) < 2 {
+		t.Fatalf("expected multiple chunks, got %d", len(chunks))
+	}
+	for i, c := range chunks {
+		want := 300 * time.Millisecond
+		if i == 0 {
+			want = 0
+		}
+		if c.Pause != want {
+			t.Errorf("chunk %d pause = %v, want %v", i, c.Pause, want)
+		}
+	}
+}
+
+func TestSplitTextWithPauses_Paragraphs(t *testing.T) {
+	text := "First paragraph.\nstill first.\n\n\nSecond paragraph.\n\n"
+	chunks := jobs.SplitTextWithPauses(text, 4500, jobs.Pauses{ParagraphMs: 700, ChunkMs: 100})
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %+v", chunks)
+	}
+	if chunks[0].Text != "First paragraph.\nstill first.\n\n\n" || chunks[0].Pause != 0 {
+		t.Errorf("chunk 0 = %+v", chunks[0])
+	}
+	if chunks[1].Text != "Second paragraph.\n\n" || chunks[1].CharOffset != 32 || chunks[1].Pause != 700*time.Millisecond {
+		t.Errorf("chunk 1 = %+v", chunks[1])
+	}
+	if joinChunks(chunks) != text {
+		t.Error("reconstructed text does not match original")
+	}
+}
+
+func TestSplitTextWithPauses_IndentedParagraphsAndHeadings(t *testing.T) {
+	text := "　前書き。\n第一章　始まり\n　一段落目。\n　二段落目。\n## Chapter notes\nおわり。"
+	chunks := jobs.SplitTextWithPauses(text, 4500, jobs.Pauses{ParagraphMs: 500, HeadingMs: 2000})
+
+	want := []struct {
+		text  string
+		pause time.Duration
+	}{
+		{"　前書き。\n", 0},
+		{"第一章　始まり\n", 2 * time.Second},
+		{"　一段落目。\n", 2 * time.Second},
+		{"　二段落目。\n", 500 * time.Millisecond},
+		{"## Chapter notes\n", 2 * time.Second},
+		{"おわり。", 2 * time.Second},
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks %+v, want %d", len(chunks), chunks, len(want))
+	}
+	offset := 0
+	for i, w := range want {
+		c := chunks[i]
+		if c.Text != w.text || c.Pause != w.pause || c.CharOffset != offset {
+			t.Errorf("chunk %d = %+v, want text %q pause %v offset %d", i, c, w.text, w.pause, offset)
+		}
+		offset += len([]rune(c.Text))
+	}
+}
+
+func TestPauses_Validate(t *testing.T) {
+	if err := (jobs.Pauses{ParagraphMs: 800, HeadingMs: jobs.MaxPauseMs}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, p := range []jobs.Pauses{{ParagraphMs: -1}, {HeadingMs: jobs.MaxPauseMs + 1}, {ChunkMs: -5}} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("%+v: expected error", p)
+		}
+	}
+}
@@ -1,14 +1,25 @@
 package jobs
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
+	"log"
+	"math"
 	"net/http"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/loudness"
 	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
 	"github.com/google/uuid"
 )
@@ -21,7 +32,8 @@ const MaxChunkBytes = 1000
 // TextChunk is a slice of the original text with its character offset.
 type TextChunk struct {
 	Text       string
-	CharOffset int // rune (character) offset into the original text
+	CharOffset int           // rune (character) offset into the original text
+	Pause      time.Duration // silence to insert before this chunk
 }
 
 // SplitText splits text into chunks of at most maxBytes UTF-8 bytes.
@@ -101,8 +113,62 @@ func AdjustTimepoints(tps []TTSTimepoint, charOffset int, timeOffset float64) []
 
 // ProcessResult holds the output of a completed job.
 type ProcessResult struct {
-	AudioURL   string
-	Timepoints []TTSTimepoint
+	AudioURL        string
+	AudioPath       string // storage object name of the generated audio
+	Timepoints      []TTSTimepoint
+	AudioBytes      int64 // size of the stored audio object
+	DurationSeconds float64
+	PCMSHA256       string // hex SHA-256 of the concatenated PCM samples
+	PCMBytes        int64
+	PeaksURL        string // waveform peaks sidecar; empty if it could not be stored
+	Chapters        []Chapter
+
+	// OutputLUFS is the measured integrated loudness of the stored audio,
+	// set when the job asked for loudness normalization.
+	// LoudnessApproximate reports that the gain followed a running
+	// estimate (streamed uploads and HLS), so OutputLUFS may be off the
+	// target by up to about 1 LU.
+	OutputLUFS          float64
+	LoudnessApproximate bool
+}
+
+// pcmDigest accumulates the SHA-256 and byte size of the PCM stream so the
+// uploaded audio can be checked for integrity later.
+type pcmDigest struct {
+	h hash.Hash
+	n int64
+}
+
+func newPCMDigest() *pcmDigest {
+	return &pcmDigest{h: sha256.New()}
+}
+
+func (d *pcmDigest) Write(p []byte) {
+	d.h.Write(p)
+	d.n += int64(len(p))
+}
+
+func (d *pcmDigest) Sum() string {
+	return hex.EncodeToString(d.h.Sum(nil))
+}
+
+// audioMetadata builds the object metadata that ties an uploaded file back to its job.
+func audioMetadata(job *Job) map[string]string {
+	return map[string]string{
+		"jobId":    job.ID,
+		"voiceId":  job.VoiceID,
+		"language": job.Language,
+	}
+}
+
+// setAudioDigest records duration, hash and size in both the object metadata and the result.
+func setAudioDigest(meta map[string]string, result *ProcessResult, duration float64, digest *pcmDigest) {
+	result.DurationSeconds = duration
+	result.PCMSHA256 = digest.Sum()
+	result.PCMBytes = digest.n
+	meta["durationSeconds"] = strconv.FormatFloat(duration, 'f', 3, 64)
+	meta["pcmSha256"] = result.PCMSHA256
+	meta["pcmBytes"] = strconv.FormatInt(result.PCMBytes, 10)
 }
 
 // downloadText fetches text from a URL (used when text is stored in GCS).
@@ -130,12 +196,20 @@ func downloadText(ctx context.Context, url string) (string, error) {
 // When storage implements StreamingAudioStorage, PCM data is streamed directly
 // to GCS one chunk at a time (constant memory usage regardless of text length).
 // Otherwise it falls back to accumulating all chunks in memory before upload.
+//
+// For compressed output formats the concatenated PCM stream is fed through a
+// single encoder, so timepoints computed on the PCM stay valid; they are only
+// shifted by the encoder's constant delay.
+//
+// progress, if not nil, is called after each chunk is synthesized with the
+// chunk's timepoints as they will appear in the result.
 func ProcessJob(
 	ctx context.Context,
 	job *Job,
 	voice *config.VoiceOption,
 	gen TTSGenerator,
 	storage AudioStorage,
+	progress ProgressFunc,
 ) (*ProcessResult, error) {
 	text := job.Text
 	if text == "" && job.TextURL != "" {
@@ -145,64 +219,369 @@ func ProcessJob(
 		}
 		text = downloaded
 	}
-	chunks := SplitText(text, MaxChunkBytes)
+	var pauses Pauses
+	if job.Pauses != nil {
+		pauses = *job.Pauses
+	}
+	chunks := SplitTextWithPauses(text, MaxChunkBytes, pauses)
+	chapters := job.Chapters
+	if len(chapters) == 0 && job.AutoChapters {
+		chapters = DetectChapters(text)
+	}
 
-	filename := fmt.Sprintf("audio/jobs/%s_%s.wav", job.VoiceID, uuid.New().String())
+	format := job.OutputFormat
+	if format == "" {
+		format = OutputFormatWAV
+	}
+	filename := fmt.Sprintf("audio/jobs/%s_%s.%s", job.VoiceID, uuid.New().String(), format.Extension())
+	if format == OutputFormatHLS {
+		filename = HLSPlaylistPath(job.ID)
+	}
 
 	var allTimepoints []TTSTimepoint
 	var cumulativeTime float64
+	var starts []chunkStart
+	result := &ProcessResult{AudioPath: filename}
+	digest := newPCMDigest()
+	var peaks *wav.Peaks
+	opts := UploadOptions{ContentType: format.ContentType(), Metadata: audioMetadata(job)}
+	encodeOpts := EncodeOptions{Bitrate: job.Bitrate, Title: job.Title, Voice: voice, Language: job.Language}
 
-	// Prefer streaming upload to avoid OOM on large texts.
-	if streamer, ok := storage.(StreamingAudioStorage); ok {
-		audioURL, err := streamer.UploadWAVStreaming(ctx, filename, func(setHeader func([]byte), writePCM func([]byte)) error {
-			headerSet := false
-			for _, chunk := range chunks {
-				audioData, tps, err := gen.Generate(ctx, chunk.Text, voice, job.Language)
+	streamer, streaming := storage.(StreamingAudioStorage)
+
+	// Stretching scales the whole timeline by 1/speed; timepoints and the
+	// duration are rescaled in synthesize.
+	stretched := job.RenderSpeed != 0 && job.RenderSpeed != 1
+
+	var pcmFormat *wav.Format // set by the first chunk
+
+	// report passes a chunk's timepoints to progress with the stretch and
+	// encoder delay that the final timepoints get applied up front.
+	report := func(i int, tps []TTSTimepoint) {
+		if progress == nil {
+			return
+		}
+		tps = slices.Clone(tps)
+		if stretched {
+			tps = scaleTimepoints(tps, job.RenderSpeed)
+		}
+		delayFormat := format
+		if format == OutputFormatHLS {
+			delayFormat = OutputFormatMP3
+		}
+		progress(i+1, len(chunks), shiftTimepoints(tps, encoderDelay(delayFormat, *pcmFormat)))
+	}
+
+	// generate synthesizes each chunk in order and passes its sample format
+	// and PCM (the WAV data chunk) to emit, in the first chunk's format (see
+	// synthesizeChunk).
+	var generate pcmSource = func(emit func(f wav.Format, pcm []byte) error) error {
+		// join passes each piece to emit, through a crossfader when the job
+		// asks for one, and returns how many seconds at the start of pcm now
+		// overlap the end of the previous piece.
+		var fader *wav.Crossfader
+		join := func(pcm []byte) (float64, error) {
+			if job.CrossfadeMs == 0 {
+				return 0, emit(*pcmFormat, pcm)
+			}
+			if fader == nil {
+				var err error
+				if fader, err = wav.NewCrossfader(*pcmFormat, millis(job.CrossfadeMs)); err != nil {
+					return 0, fmt.Errorf("crossfade: %w", err)
+				}
+			}
+			out, overlap := fader.Write(pcm)
+			if len(out) > 0 {
+				if err := emit(*pcmFormat, out); err != nil {
+					return 0, err
+				}
+			}
+			return pcmFormat.Duration(overlap), nil
+		}
+
+		for i, chunk := range chunks {
+			if chunk.Pause > 0 && pcmFormat != nil {
+				silence := wav.Silence(*pcmFormat, chunk.Pause)
+				overlap, err := join(silence)
 				if err != nil {
-					return fmt.Errorf("TTS generation failed at offset %d: %w", chunk.CharOffset, err)
+					return err
 				}
-				if !headerSet && len(audioData) >= 44 {
-					setHeader(audioData[:44])
-					headerSet = true
+				cumulativeTime += pcmFormat.Duration(int64(len(silence))) - overlap
+			}
+			f, pcm, tps, err := synthesizeChunk(ctx, gen, chunk, voice, job.Language, pcmFormat)
+			if err != nil {
+				return err
+			}
+			pcmFormat = f
+			if job.TrimSilence != nil {
+				var lead int64
+				pcm, lead, err = wav.TrimSilence(pcm, *pcmFormat, job.TrimSilence.options())
+				if err != nil {
+					return fmt.Errorf("TTS audio at offset %d: %w", chunk.CharOffset, err)
 				}
-				allTimepoints = append(allTimepoints, AdjustTimepoints(tps, chunk.CharOffset, cumulativeTime)...)
-				cumulativeTime += wav.Duration(audioData)
-				if len(audioData) > 44 {
-					writePCM(audioData[44:])
+				tps = trimTimepoints(tps, pcmFormat.Duration(lead), pcmFormat.Duration(int64(len(pcm))))
+			}
+			overlap, err := join(pcm)
+			if err != nil {
+				return err
+			}
+			cumulativeTime -= overlap
+			starts = append(starts, chunkStart{chunk.CharOffset, chunk.Text, cumulativeTime, len(allTimepoints)})
+			adjusted := AdjustTimepoints(tps, chunk.CharOffset, cumulativeTime)
+			allTimepoints = append(allTimepoints, adjusted...)
+			cumulativeTime += pcmFormat.Duration(int64(len(pcm)))
+			report(i, adjusted)
+		}
+		if fader != nil {
+			if tail := fader.Flush(); len(tail) > 0 {
+				return emit(*pcmFormat, tail)
+			}
+		}
+		return nil
+	}
+
+	if stretched {
+		generate = stretchSource(generate, job.RenderSpeed)
+	}
+
+	// The background is mixed after stretching so its tempo is untouched,
+	// and before loudness normalization so the mix is what gets measured.
+	if job.Background != nil {
+		bf, bed, err := loadBackground(ctx, storage, *job.Background)
+		if err != nil {
+			return nil, err
+		}
+		generate = mixBackground(generate, bf, bed, job.Background.options())
+	}
+
+	// Loudness normalization only changes levels, so durations and
+	// timepoints computed in generate stay valid. Streamed output cannot be
+	// held back for a first measuring pass, so its gain tracks the running
+	// loudness; the delivered loudness is measured either way.
+	var outputMeter *loudness.Meter
+	if job.Loudness != nil {
+		result.LoudnessApproximate = streaming || format == OutputFormatHLS
+		if result.LoudnessApproximate {
+			generate = normalizeStreaming(generate, *job.Loudness)
+		} else {
+			generate = normalizeBuffered(generate, *job.Loudness)
+		}
+		generate = meterSource(generate, &outputMeter)
+	}
+
+	// synthesize runs the pipeline and records the digest and waveform
+	// peaks of the final PCM.
+	synthesize := func(emit func(f wav.Format, pcm []byte) error) error {
+		err := generate(func(f wav.Format, pcm []byte) error {
+			digest.Write(pcm)
+			if peaks == nil {
+				var err error
+				if peaks, err = wav.NewPeaks(f, peaksInterval); err != nil {
+					return fmt.Errorf("waveform peaks: %w", err)
 				}
 			}
-			return nil
+			peaks.Write(pcm)
+			return emit(f, pcm)
 		})
 		if err != nil {
-			return nil, fmt.Errorf("streaming WAV upload failed: %w", err)
+			return err
 		}
-		return &ProcessResult{AudioURL: audioURL, Timepoints: allTimepoints}, nil
+		duration := cumulativeTime
+		chapters = placeChapters(chapters, starts, allTimepoints)
+		if stretched && pcmFormat != nil {
+			allTimepoints = scaleTimepoints(allTimepoints, job.RenderSpeed)
+			chapters = retimeChapters(chapters, job.RenderSpeed, 0)
+			duration = pcmFormat.Duration(digest.n)
+		}
+		setAudioDigest(opts.Metadata, result, duration, digest)
+		return nil
 	}
 
-	// Fallback: accumulate all WAV data in memory (used in unit tests with mock storage).
-	var wavFiles [][]byte
-	for _, chunk := range chunks {
-		audioData, tps, err := gen.Generate(ctx, chunk.Text, voice, job.Language)
+	var audioURL string
+	var err error
+	switch {
+	case format == OutputFormatHLS:
+		// Segments are published as they are encoded. The playlist is not
+		// a single audio object, so there is nothing for the audio proxy.
+		var delay float64
+		delay, audioURL, result.AudioBytes, err = encodeHLS(ctx, storage, filename, encodeOpts, synthesize)
+		if err != nil {
+			return nil, fmt.Errorf("HLS upload failed: %w", err)
+		}
+		result.AudioPath = ""
+		allTimepoints = shiftTimepoints(allTimepoints, delay)
+		chapters = retimeChapters(chapters, 1, delay)
+
+	case format == OutputFormatWAV && streaming:
+		// Prefer streaming upload to avoid OOM on large texts.
+		audioURL, err = streamer.UploadWAVStreaming(ctx, filename, opts, func(setHeader func([]byte), writePCM func([]byte)) error {
+			headerSet := false
+			err := synthesize(func(f wav.Format, pcm []byte) error {
+				if !headerSet {
+					setHeader(wav.Header(f, 0))
+					headerSet = true
+				}
+				writePCM(pcm)
+				return nil
+			})
+			if err == nil && headerSet && len(chapters) > 0 {
+				setHeader(wav.HeaderWithChunks(*pcmFormat, 0, chapterCues(chapters, *pcmFormat)))
+			}
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("streaming WAV upload failed: %w", err)
+		}
+		header := wav.HeaderWithChunks(*pcmFormat, result.PCMBytes, chapterCues(chapters, *pcmFormat))
+		result.AudioBytes = int64(len(header)) + result.PCMBytes
+
+	case streaming:
+		audioURL, err = streamer.UploadStreaming(ctx, filename, opts, func(w io.Writer, setHeader func([]byte)) error {
+			cw := &countingWriter{w: w}
+			delay, header, err := encodeChunks(cw, format, encodeOpts, synthesize)
+			if err != nil {
+				return err
+			}
+			allTimepoints = shiftTimepoints(allTimepoints, delay)
+			chapters = retimeChapters(chapters, 1, delay)
+			if format == OutputFormatMP3 {
+				header = append(chapterTag(chapters, result.DurationSeconds+delay), header...)
+			}
+			if len(header) > 0 {
+				setHeader(header)
+			}
+			result.AudioBytes = int64(len(header)) + cw.n
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("streaming %s upload failed: %w", format, err)
+		}
+
+	case format == OutputFormatWAV:
+		// Fallback: accumulate all WAV data in memory (used in unit tests with mock storage).
+		var pcmData bytes.Buffer
+		err := synthesize(func(_ wav.Format, pcm []byte) error {
+			pcmData.Write(pcm)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if pcmFormat == nil {
+			return nil, fmt.Errorf("no audio data produced")
+		}
+		header := wav.HeaderWithChunks(*pcmFormat, int64(pcmData.Len()), chapterCues(chapters, *pcmFormat))
+		combined := append(header, pcmData.Bytes()...)
+		audioURL, err = storage.Upload(ctx, combined, filename, opts)
+		if err != nil {
+			return nil, fmt.Errorf("audio upload failed: %w", err)
+		}
+		result.AudioBytes = int64(len(combined))
+
+	default:
+		var buf bytes.Buffer
+		delay, header, err := encodeChunks(&buf, format, encodeOpts, synthesize)
 		if err != nil {
-			return nil, fmt.Errorf("TTS generation failed at offset %d: %w", chunk.CharOffset, err)
+			return nil, err
 		}
-		wavFiles = append(wavFiles, audioData)
-		allTimepoints = append(allTimepoints, AdjustTimepoints(tps, chunk.CharOffset, cumulativeTime)...)
-		cumulativeTime += wav.Duration(audioData)
+		allTimepoints = shiftTimepoints(allTimepoints, delay)
+		chapters = retimeChapters(chapters, 1, delay)
+		if format == OutputFormatMP3 {
+			header = append(chapterTag(chapters, result.DurationSeconds+delay), header...)
+		}
+		data := append(header, buf.Bytes()...)
+		audioURL, err = storage.Upload(ctx, data, filename, opts)
+		if err != nil {
+			return nil, fmt.Errorf("audio upload failed: %w", err)
+		}
+		result.AudioBytes = int64(len(data))
 	}
 
-	combined, err := wav.Concatenate(wavFiles)
-	if err != nil {
-		return nil, fmt.Errorf("WAV concatenation failed: %w", err)
+	result.AudioURL = audioURL
+	result.Timepoints = allTimepoints
+	result.Chapters = chapters
+	if outputMeter != nil {
+		if l := outputMeter.Integrated(); !math.IsInf(l, -1) {
+			result.OutputLUFS = l
+		}
+	}
+	if peaks != nil {
+		result.PeaksURL = uploadPeaks(ctx, storage, peaksFilename(filename), peaks)
 	}
+	return result, nil
+}
 
-	audioURL, err := storage.Upload(ctx, combined, filename)
+// synthesizeChunk generates one chunk and returns its PCM with its sample
+// format. The first chunk (format nil) keeps its own format; later chunks
+// in another format (e.g. a fallback voice at a different sample rate) are
+// converted to format, so every chunk of a job can be concatenated.
+func synthesizeChunk(
+	ctx context.Context,
+	gen TTSGenerator,
+	chunk TextChunk,
+	voice *config.VoiceOption,
+	language string,
+	format *wav.Format,
+) (*wav.Format, []byte, []TTSTimepoint, error) {
+	audioData, tps, err := gen.Generate(ctx, chunk.Text, voice, language)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("TTS generation failed at offset %d: %w", chunk.CharOffset, err)
+	}
+	info, err := wav.Parse(audioData)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("TTS audio at offset %d: %w", chunk.CharOffset, err)
+	}
+	if format == nil {
+		format = &info.Format
+	}
+	pcm, err := wav.Convert(info.Data(audioData), info.Format, *format)
 	if err != nil {
-		return nil, fmt.Errorf("audio upload failed: %w", err)
+		return nil, nil, nil, fmt.Errorf("TTS audio at offset %d: %w", chunk.CharOffset, err)
+	}
+	return format, pcm, tps, nil
+}
+
+// peaksInterval is the waveform resolution: fine enough to scrub by word,
+// coarse enough that a multi-hour audiobook's sidecar stays around a
+// megabyte.
+const peaksInterval = 50 * time.Millisecond
+
+// peaksFilename returns the sidecar object name for an audio object.
+func peaksFilename(audioPath string) string {
+	return strings.TrimSuffix(audioPath, path.Ext(audioPath)) + ".peaks.json"
+}
+
+// uploadPeaks stores the waveform sidecar and returns its URL. The audio is
+// already stored by then, so a failure only loses the waveform and is
+// logged rather than failing the job.
+func uploadPeaks(ctx context.Context, storage AudioStorage, filename string, peaks *wav.Peaks) string {
+	data, err := json.Marshal(peaks)
+	if err == nil {
+		var url string
+		if url, err = storage.Upload(ctx, data, filename, UploadOptions{ContentType: "application/json"}); err == nil {
+			return url
+		}
 	}
+	log.Printf("ProcessJob: waveform peaks %s: %v", filename, err)
+	return ""
+}
 
-	return &ProcessResult{
-		AudioURL:   audioURL,
-		Timepoints: allTimepoints,
-	}, nil
+// trimTimepoints moves chunk-relative timepoints back by the lead seconds
+// trimmed from the chunk's start, clamping them into the remaining length.
+func trimTimepoints(tps []TTSTimepoint, lead, length float64) []TTSTimepoint {
+	for i := range tps {
+		tps[i].TimeSeconds = min(max(tps[i].TimeSeconds-lead, 0), length)
+	}
+	return tps
+}
+
+// shiftTimepoints delays every timepoint by seconds.
+func shiftTimepoints(tps []TTSTimepoint, seconds float64) []TTSTimepoint {
+	if seconds == 0 {
+		return tps
+	}
+	for i := range tps {
+		tps[i].TimeSeconds += seconds
+	}
+	return tps
 }
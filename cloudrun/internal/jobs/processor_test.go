@@ -1,15 +1,27 @@
 package jobs_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"strings"
 	"testing"
 
+	mflac "github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/meta"
+
 	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
 	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/loudness"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/mp3"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
 )
 
 // --- helpers ---
@@ -51,15 +63,76 @@ func (m *mockTTSGenerator) Generate(_ context.Context, text string, _ *config.Vo
 	return audio, tps, nil
 }
 
-// mockAudioStorage records the last uploaded data.
+// mockAudioStorage records the last uploaded audio and any JSON sidecars.
 type mockAudioStorage struct {
 	uploadedData []byte
 	uploadedName string
+	uploadedOpts jobs.UploadOptions
+	sidecars     map[string][]byte
 }
 
-func (m *mockAudioStorage) Upload(_ context.Context, data []byte, filename string) (string, error) {
+func (m *mockAudioStorage) Upload(_ context.Context, data []byte, filename string, opts jobs.UploadOptions) (string, error) {
+	if opts.ContentType == "application/json" {
+		if m.sidecars == nil {
+			m.sidecars = map[string][]byte{}
+		}
+		m.sidecars[filename] = data
+		return "https://storage.example.com/" + filename, nil
+	}
 	m.uploadedData = data
 	m.uploadedName = filename
+	m.uploadedOpts = opts
+	return "https://storage.example.com/" + filename, nil
+}
+
+// The production storage must keep satisfying the optional extensions.
+var (
+	_ jobs.StreamingAudioStorage = (*jobs.GCSAudioStorage)(nil)
+	_ jobs.ReadableAudioStorage  = (*jobs.GCSAudioStorage)(nil)
+)
+
+// mockStreamingStorage assembles the streamed header and PCM in memory.
+type mockStreamingStorage struct {
+	mockAudioStorage
+}
+
+func (m *mockStreamingStorage) UploadWAVStreaming(
+	_ context.Context,
+	filename string,
+	opts jobs.UploadOptions,
+	fillPCM func(setHeader func([]byte), writePCM func([]byte)) error,
+) (string, error) {
+	var header, pcm []byte
+	err := fillPCM(
+		func(h []byte) { header = append([]byte(nil), h...) },
+		func(p []byte) { pcm = append(pcm, p...) },
+	)
+	if err != nil {
+		return "", err
+	}
+	if header, err = wav.ResizeHeader(header, int64(len(pcm))); err != nil {
+		return "", err
+	}
+	m.uploadedData = append(header, pcm...)
+	m.uploadedName = filename
+	m.uploadedOpts = opts
+	return "https://storage.example.com/" + filename, nil
+}
+
+func (m *mockStreamingStorage) UploadStreaming(
+	_ context.Context,
+	filename string,
+	opts jobs.UploadOptions,
+	fill func(w io.Writer, setHeader func([]byte)) error,
+) (string, error) {
+	var header []byte
+	var buf bytes.Buffer
+	if err := fill(&buf, func(h []byte) { header = h }); err != nil {
+		return "", err
+	}
+	m.uploadedData = append(header, buf.Bytes()...)
+	m.uploadedName = filename
+	m.uploadedOpts = opts
 	return "https://storage.example.com/" + filename, nil
 }
 
@@ -181,7 +254,7 @@ func TestProcessJob_SingleChunk(t *testing.T) {
 		Language: "ja-JP",
 	}
 
-	result, err := jobs.ProcessJob(context.Background(), job, voice, gen, store)
+	result, err := jobs.ProcessJob(context.Background(), job, voice, gen, store, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -206,7 +279,7 @@ func TestProcessJob_MultipleChunks(t *testing.T) {
 		Language: "ja-JP",
 	}
 
-	result, err := jobs.ProcessJob(context.Background(), job, voice, gen, store)
+	result, err := jobs.ProcessJob(context.Background(), job, voice, gen, store, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -235,8 +308,1015 @@ func TestProcessJob_TTSError(t *testing.T) {
 		VoiceID: "ja-jp-female-a",
 	}
 
-	_, err := jobs.ProcessJob(context.Background(), job, voice, gen, store)
+	_, err := jobs.ProcessJob(context.Background(), job, voice, gen, store, nil)
 	if err == nil {
 		t.Error("expected error when TTS fails")
 	}
 }
+
+func TestProcessJob_RecordsDigestAndMetadata(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{
+		ID:       "test-job-4",
+		Text:     strings.Repeat("あいうえお。", 400),
+		VoiceID:  "ja-jp-female-a",
+		Language: "ja-JP",
+	}
+
+	buffered := &mockAudioStorage{}
+	streaming := &mockStreamingStorage{}
+	for _, tc := range []struct {
+		name     string
+		storage  jobs.AudioStorage
+		uploaded *mockAudioStorage
+	}{
+		{"buffered", buffered, buffered},
+		{"streaming", streaming, &streaming.mockAudioStorage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gen := &mockTTSGenerator{}
+			result, err := jobs.ProcessJob(context.Background(), job, voice, gen, tc.storage, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			uploaded := tc.uploaded
+
+			sum := sha256.Sum256(uploaded.uploadedData[44:])
+			wantHash := hex.EncodeToString(sum[:])
+			if result.PCMSHA256 != wantHash {
+				t.Errorf("PCMSHA256 = %s, want %s", result.PCMSHA256, wantHash)
+			}
+			if result.PCMBytes != int64(len(uploaded.uploadedData)-44) {
+				t.Errorf("PCMBytes = %d, want %d", result.PCMBytes, len(uploaded.uploadedData)-44)
+			}
+			if math.Abs(result.DurationSeconds-float64(gen.callCount)) > 0.001 {
+				t.Errorf("DurationSeconds = %f, want %d", result.DurationSeconds, gen.callCount)
+			}
+			if result.AudioPath != uploaded.uploadedName {
+				t.Errorf("AudioPath = %q, want %q", result.AudioPath, uploaded.uploadedName)
+			}
+
+			meta := uploaded.uploadedOpts.Metadata
+			if meta["jobId"] != job.ID || meta["voiceId"] != job.VoiceID || meta["language"] != job.Language {
+				t.Errorf("missing job metadata: %v", meta)
+			}
+			if meta["pcmSha256"] != wantHash {
+				t.Errorf("metadata pcmSha256 = %q, want %q", meta["pcmSha256"], wantHash)
+			}
+			if meta["durationSeconds"] == "" || meta["pcmBytes"] == "" {
+				t.Errorf("missing duration/size metadata: %v", meta)
+			}
+		})
+	}
+}
+
+// listChunkTTSGenerator returns WAVs with a LIST chunk between fmt and data,
+// as some TTS backends do, filled with a non-silent ramp.
+type listChunkTTSGenerator struct{}
+
+func (listChunkTTSGenerator) Generate(_ context.Context, _ string, _ *config.VoiceOption, _ string) ([]byte, []jobs.TTSTimepoint, error) {
+	canonical := makeWAV(16000, 1, 16, 8000) // 0.5 seconds
+	for i := 44; i+1 < len(canonical); i += 2 {
+		binary.LittleEndian.PutUint16(canonical[i:], uint16(i))
+	}
+	list := []byte("LIST\x0a\x00\x00\x00INFOabcdef")
+	audio := append(append(append([]byte(nil), canonical[:36]...), list...), canonical[36:]...)
+	binary.LittleEndian.PutUint32(audio[4:8], uint32(len(audio)-8))
+	return audio, []jobs.TTSTimepoint{{MarkName: "0:0:1", TimeSeconds: 0.1}}, nil
+}
+
+func TestProcessJob_SkipsNonDataChunks(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{ID: "test-job-list", Text: strings.Repeat("あいうえお。", 400), VoiceID: "ja-jp-female-a"}
+	chunks := len(jobs.SplitText(job.Text, jobs.MaxChunkBytes))
+	expected, _, _ := listChunkTTSGenerator{}.Generate(context.Background(), "", nil, "")
+	wantPCM := expected[len(expected)-16000:]
+
+	buffered := &mockAudioStorage{}
+	streaming := &mockStreamingStorage{}
+	for _, tc := range []struct {
+		name     string
+		storage  jobs.AudioStorage
+		uploaded *mockAudioStorage
+	}{
+		{"buffered", buffered, buffered},
+		{"streaming", streaming, &streaming.mockAudioStorage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := jobs.ProcessJob(context.Background(), job, voice, listChunkTTSGenerator{}, tc.storage, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			data := tc.uploaded.uploadedData
+			if want := 44 + chunks*len(wantPCM); len(data) != want {
+				t.Fatalf("uploaded %d bytes, want %d", len(data), want)
+			}
+			if string(data[36:40]) != "data" {
+				t.Errorf("output header is not canonical: %q", data[36:40])
+			}
+			for i := 0; i < chunks; i++ {
+				got := data[44+i*len(wantPCM) : 44+(i+1)*len(wantPCM)]
+				if !bytes.Equal(got, wantPCM) {
+					t.Fatalf("chunk %d PCM does not match the source data chunk", i)
+				}
+			}
+			if want := 0.5 * float64(chunks); math.Abs(result.DurationSeconds-want) > 0.001 {
+				t.Errorf("DurationSeconds = %f, want %f", result.DurationSeconds, want)
+			}
+			if last := result.Timepoints[len(result.Timepoints)-1]; math.Abs(last.TimeSeconds-(0.5*float64(chunks-1)+0.1)) > 0.001 {
+				t.Errorf("last timepoint at %f, want %f", last.TimeSeconds, 0.5*float64(chunks-1)+0.1)
+			}
+		})
+	}
+}
+
+// alternatingRateTTSGenerator returns one second of audio per call, switching
+// between 24 kHz and 22.05 kHz as a fallback voice would.
+type alternatingRateTTSGenerator struct{ callCount int }
+
+func (m *alternatingRateTTSGenerator) Generate(_ context.Context, _ string, _ *config.VoiceOption, _ string) ([]byte, []jobs.TTSTimepoint, error) {
+	m.callCount++
+	rate := uint32(24000)
+	if m.callCount%2 == 0 {
+		rate = 22050
+	}
+	return makeWAV(rate, 1, 16, int(rate)), []jobs.TTSTimepoint{{MarkName: "0:0:1", TimeSeconds: 0}}, nil
+}
+
+func TestProcessJob_ConvertsMismatchedChunks(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{ID: "test-job-rates", Text: strings.Repeat("あいうえお。", 400), VoiceID: "ja-jp-female-a"}
+
+	buffered := &mockAudioStorage{}
+	streaming := &mockStreamingStorage{}
+	for _, tc := range []struct {
+		name     string
+		storage  jobs.AudioStorage
+		uploaded *mockAudioStorage
+	}{
+		{"buffered", buffered, buffered},
+		{"streaming", streaming, &streaming.mockAudioStorage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gen := &alternatingRateTTSGenerator{}
+			result, err := jobs.ProcessJob(context.Background(), job, voice, gen, tc.storage, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gen.callCount < 2 {
+				t.Fatalf("expected multiple chunks, got %d", gen.callCount)
+			}
+			info, err := wav.Parse(tc.uploaded.uploadedData)
+			if err != nil {
+				t.Fatalf("output is not a valid WAV: %v", err)
+			}
+			if info.Format.SampleRate != 24000 {
+				t.Errorf("SampleRate = %d, want 24000", info.Format.SampleRate)
+			}
+			if want := int64(gen.callCount) * 48000; info.DataLength != want {
+				t.Errorf("data length = %d, want %d", info.DataLength, want)
+			}
+			if math.Abs(result.DurationSeconds-float64(gen.callCount)) > 0.001 {
+				t.Errorf("DurationSeconds = %f, want %d", result.DurationSeconds, gen.callCount)
+			}
+			for i, tp := range result.Timepoints {
+				if math.Abs(tp.TimeSeconds-float64(i)) > 0.001 {
+					t.Errorf("timepoint %d at %f, want %d", i, tp.TimeSeconds, i)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessJob_InsertsPauses(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{
+		ID:      "test-job-pauses",
+		Text:    "第一章\n　一段落目。\n　二段落目。",
+		VoiceID: "ja-jp-female-a",
+		Pauses:  &jobs.Pauses{ParagraphMs: 500, HeadingMs: 1500},
+	}
+
+	buffered := &mockAudioStorage{}
+	streaming := &mockStreamingStorage{}
+	for _, tc := range []struct {
+		name     string
+		storage  jobs.AudioStorage
+		uploaded *mockAudioStorage
+	}{
+		{"buffered", buffered, buffered},
+		{"streaming", streaming, &streaming.mockAudioStorage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gen := &mockTTSGenerator{}
+			result, err := jobs.ProcessJob(context.Background(), job, voice, gen, tc.storage, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gen.callCount != 3 {
+				t.Fatalf("expected one TTS call per block, got %d", gen.callCount)
+			}
+			// heading 1s, 1.5s pause, paragraph 1s, 0.5s pause, paragraph 1s
+			if math.Abs(result.DurationSeconds-5) > 0.001 {
+				t.Errorf("DurationSeconds = %f, want 5", result.DurationSeconds)
+			}
+			if got := wav.Duration(tc.uploaded.uploadedData); math.Abs(got-5) > 0.001 {
+				t.Errorf("uploaded duration = %f, want 5", got)
+			}
+			want := []float64{0.1, 2.6, 4.1}
+			for i, tp := range result.Timepoints {
+				if math.Abs(tp.TimeSeconds-want[i]) > 0.001 {
+					t.Errorf("timepoint %d at %f, want %f", i, tp.TimeSeconds, want[i])
+				}
+			}
+		})
+	}
+}
+
+// toneTTSGenerator returns one second of a 1 kHz tone per call, alternating
+// between a quiet and a loud level as two mismatched voices would.
+type toneTTSGenerator struct{ callCount int }
+
+func (m *toneTTSGenerator) Generate(_ context.Context, _ string, _ *config.VoiceOption, _ string) ([]byte, []jobs.TTSTimepoint, error) {
+	m.callCount++
+	amp := 0.05
+	if m.callCount%2 == 0 {
+		amp = 0.5
+	}
+	audio := makeWAV(24000, 1, 16, 24000)
+	for i := 0; i < 24000; i++ {
+		v := int16(amp * 32767 * math.Sin(2*math.Pi*1000*float64(i)/24000))
+		binary.LittleEndian.PutUint16(audio[44+2*i:], uint16(v))
+	}
+	return audio, []jobs.TTSTimepoint{{MarkName: "0:0:1", TimeSeconds: 0.5}}, nil
+}
+
+func TestProcessJob_NormalizesLoudness(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{
+		ID:       "test-job-loudness",
+		Text:     strings.Repeat("あいうえお。", 1000),
+		VoiceID:  "ja-jp-female-a",
+		Loudness: &jobs.Loudness{TargetLUFS: -16},
+	}
+
+	buffered := &mockAudioStorage{}
+	streaming := &mockStreamingStorage{}
+	for _, tc := range []struct {
+		name      string
+		storage   jobs.AudioStorage
+		uploaded  *mockAudioStorage
+		tolerance float64
+		approx    bool
+	}{
+		{"buffered", buffered, buffered, 0.1, false},
+		// The running estimate converges on the job loudness as chunks arrive.
+		{"streaming", streaming, &streaming.mockAudioStorage, 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gen := &toneTTSGenerator{}
+			result, err := jobs.ProcessJob(context.Background(), job, voice, gen, tc.storage, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			data := tc.uploaded.uploadedData
+			info, err := wav.Parse(data)
+			if err != nil {
+				t.Fatalf("output is not a valid WAV: %v", err)
+			}
+			chans, err := wav.Decode(info.Data(data), info.Format)
+			if err != nil {
+				t.Fatalf("decode output: %v", err)
+			}
+			m := loudness.NewMeter(info.Format.SampleRate, info.Format.Channels)
+			m.Write(chans)
+			got := m.Integrated()
+			if math.Abs(got+16) > tc.tolerance {
+				t.Errorf("integrated loudness = %.2f LUFS, want -16 ± %.1f", got, tc.tolerance)
+			}
+			if math.Abs(result.OutputLUFS-got) > 0.01 || result.LoudnessApproximate != tc.approx {
+				t.Errorf("reported %.2f LUFS (approximate %v), want %.2f (%v)", result.OutputLUFS, result.LoudnessApproximate, got, tc.approx)
+			}
+			if tp := loudness.TruePeak(chans); tp > -0.9 {
+				t.Errorf("true peak = %.2f dBTP, want <= -1", tp)
+			}
+
+			sum := sha256.Sum256(info.Data(data))
+			if result.PCMSHA256 != hex.EncodeToString(sum[:]) {
+				t.Error("PCMSHA256 does not match the normalized PCM")
+			}
+			if math.Abs(result.DurationSeconds-float64(gen.callCount)) > 0.001 {
+				t.Errorf("DurationSeconds = %f, want %d", result.DurationSeconds, gen.callCount)
+			}
+		})
+	}
+}
+
+// paddedTTSGenerator returns 300 ms of silence, 400 ms of tone and 300 ms
+// of silence per call, with a mark 50 ms into the tone.
+type paddedTTSGenerator struct{ callCount int }
+
+func (m *paddedTTSGenerator) Generate(_ context.Context, _ string, _ *config.VoiceOption, _ string) ([]byte, []jobs.TTSTimepoint, error) {
+	m.callCount++
+	audio := makeWAV(16000, 1, 16, 16000)
+	for i := 4800; i < 11200; i++ {
+		binary.LittleEndian.PutUint16(audio[44+2*i:], uint16(int16(8000)))
+	}
+	return audio, []jobs.TTSTimepoint{{MarkName: "0:0:1", TimeSeconds: 0.35}}, nil
+}
+
+func TestProcessJob_TrimsSilence(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{
+		ID:          "test-job-trim",
+		Text:        strings.Repeat("あいうえお。", 400),
+		VoiceID:     "ja-jp-female-a",
+		TrimSilence: &jobs.SilenceTrim{KeepMs: 50},
+	}
+
+	buffered := &mockAudioStorage{}
+	streaming := &mockStreamingStorage{}
+	for _, tc := range []struct {
+		name     string
+		storage  jobs.AudioStorage
+		uploaded *mockAudioStorage
+	}{
+		{"buffered", buffered, buffered},
+		{"streaming", streaming, &streaming.mockAudioStorage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gen := &paddedTTSGenerator{}
+			result, err := jobs.ProcessJob(context.Background(), job, voice, gen, tc.storage, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// Each chunk keeps 50 ms + 400 ms tone + 50 ms.
+			want := 0.5 * float64(gen.callCount)
+			if math.Abs(result.DurationSeconds-want) > 0.001 {
+				t.Errorf("DurationSeconds = %f, want %f", result.DurationSeconds, want)
+			}
+			if got := wav.Duration(tc.uploaded.uploadedData); math.Abs(got-want) > 0.001 {
+				t.Errorf("uploaded duration = %f, want %f", got, want)
+			}
+			for i, tp := range result.Timepoints {
+				if w := 0.5*float64(i) + 0.1; math.Abs(tp.TimeSeconds-w) > 0.001 {
+					t.Errorf("timepoint %d at %f, want %f", i, tp.TimeSeconds, w)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessJob_Crossfade(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{
+		ID:          "test-job-crossfade",
+		Text:        strings.Repeat("あいうえお。", 400),
+		VoiceID:     "ja-jp-female-a",
+		CrossfadeMs: 20,
+	}
+
+	buffered := &mockAudioStorage{}
+	streaming := &mockStreamingStorage{}
+	for _, tc := range []struct {
+		name     string
+		storage  jobs.AudioStorage
+		uploaded *mockAudioStorage
+	}{
+		{"buffered", buffered, buffered},
+		{"streaming", streaming, &streaming.mockAudioStorage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gen := &mockTTSGenerator{}
+			result, err := jobs.ProcessJob(context.Background(), job, voice, gen, tc.storage, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			joins := float64(gen.callCount - 1)
+			want := float64(gen.callCount) - 0.02*joins
+			if math.Abs(result.DurationSeconds-want) > 1e-6 {
+				t.Errorf("DurationSeconds = %f, want %f", result.DurationSeconds, want)
+			}
+			if got := wav.Duration(tc.uploaded.uploadedData); math.Abs(got-want) > 1e-6 {
+				t.Errorf("uploaded duration = %f, want %f", got, want)
+			}
+			for i, tp := range result.Timepoints {
+				if w := 0.98*float64(i) + 0.1; math.Abs(tp.TimeSeconds-w) > 1e-6 {
+					t.Errorf("timepoint %d at %f, want %f", i, tp.TimeSeconds, w)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessJob_RenderSpeed(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{
+		ID:          "test-job-speed",
+		Text:        strings.Repeat("あいうえお。", 400),
+		VoiceID:     "ja-jp-female-a",
+		RenderSpeed: 1.5,
+	}
+
+	buffered := &mockAudioStorage{}
+	streaming := &mockStreamingStorage{}
+	for _, tc := range []struct {
+		name     string
+		storage  jobs.AudioStorage
+		uploaded *mockAudioStorage
+	}{
+		{"buffered", buffered, buffered},
+		{"streaming", streaming, &streaming.mockAudioStorage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gen := &mockTTSGenerator{}
+			result, err := jobs.ProcessJob(context.Background(), job, voice, gen, tc.storage, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := math.Round(float64(gen.callCount)*16000/1.5) / 16000
+			if result.DurationSeconds != want {
+				t.Errorf("DurationSeconds = %f, want %f", result.DurationSeconds, want)
+			}
+			if got := wav.Duration(tc.uploaded.uploadedData); math.Abs(got-want) > 1e-9 {
+				t.Errorf("uploaded duration = %f, want %f", got, want)
+			}
+			for i, tp := range result.Timepoints {
+				if w := (float64(i) + 0.1) / 1.5; math.Abs(tp.TimeSeconds-w) > 1e-9 {
+					t.Errorf("timepoint %d at %f, want %f", i, tp.TimeSeconds, w)
+				}
+			}
+		})
+	}
+}
+
+// backgroundStorage is a readable mockAudioStorage serving fixed objects.
+type backgroundStorage struct {
+	mockAudioStorage
+	objects map[string][]byte
+}
+
+func (m *backgroundStorage) Stat(_ context.Context, filename string) (*jobs.AudioObjectInfo, error) {
+	data, ok := m.objects[filename]
+	if !ok {
+		return nil, jobs.ErrAudioNotFound
+	}
+	return &jobs.AudioObjectInfo{Size: int64(len(data))}, nil
+}
+
+func (m *backgroundStorage) OpenRange(_ context.Context, filename string, offset, length int64) (io.ReadCloser, error) {
+	data, ok := m.objects[filename]
+	if !ok {
+		return nil, jobs.ErrAudioNotFound
+	}
+	data = data[offset:]
+	if length >= 0 {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func TestProcessJob_Background(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	// A constant background at a different rate; the speech is silent, so
+	// the output is the background at the job's gain, never ducked.
+	bed := makeWAV(8000, 1, 16, 800)
+	for i := 44; i < len(bed); i += 2 {
+		binary.LittleEndian.PutUint16(bed[i:], 10000)
+	}
+	storage := &backgroundStorage{objects: map[string][]byte{"audio/backgrounds/hum.wav": bed}}
+	job := &jobs.Job{
+		ID:         "test-job-background",
+		Text:       "短いテキスト",
+		VoiceID:    "ja-jp-female-a",
+		Background: &jobs.Background{Path: "audio/backgrounds/hum.wav", GainDB: -6},
+	}
+
+	result, err := jobs.ProcessJob(context.Background(), job, voice, &mockTTSGenerator{}, storage, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.DurationSeconds != 1 || len(result.Timepoints) != 1 || result.Timepoints[0].TimeSeconds != 0.1 {
+		t.Errorf("duration %f, timepoints %+v; want them unchanged", result.DurationSeconds, result.Timepoints)
+	}
+	want := 10000 * math.Pow(10, -6.0/20)
+	pcm := storage.uploadedData[44:]
+	for _, i := range []int{100, 8000, 15900} {
+		if got := float64(int16(binary.LittleEndian.Uint16(pcm[2*i:]))); math.Abs(got-want) > 2 {
+			t.Errorf("sample %d = %.0f, want %.0f", i, got, want)
+		}
+	}
+
+	job.Background.Path = "audio/backgrounds/missing.wav"
+	if _, err := jobs.ProcessJob(context.Background(), job, voice, &mockTTSGenerator{}, storage, nil); !errors.Is(err, jobs.ErrAudioNotFound) {
+		t.Errorf("err = %v, want ErrAudioNotFound", err)
+	}
+	if _, err := jobs.ProcessJob(context.Background(), job, voice, &mockTTSGenerator{}, &mockAudioStorage{}, nil); err == nil {
+		t.Error("expected error when storage cannot be read")
+	}
+}
+
+func TestProcessJob_WaveformPeaks(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{ID: "test-job-peaks", Text: strings.Repeat("あいうえお。", 400), VoiceID: "ja-jp-female-a"}
+
+	buffered := &mockAudioStorage{}
+	streaming := &mockStreamingStorage{}
+	for _, tc := range []struct {
+		name     string
+		storage  jobs.AudioStorage
+		uploaded *mockAudioStorage
+	}{
+		{"buffered", buffered, buffered},
+		{"streaming", streaming, &streaming.mockAudioStorage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := jobs.ProcessJob(context.Background(), job, voice, &mockTTSGenerator{}, tc.storage, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			name := strings.TrimSuffix(tc.uploaded.uploadedName, ".wav") + ".peaks.json"
+			if result.PeaksURL != "https://storage.example.com/"+name {
+				t.Errorf("PeaksURL = %q, want sidecar %s", result.PeaksURL, name)
+			}
+			var peaks struct {
+				SampleRate      int    `json:"sample_rate"`
+				SamplesPerPixel int    `json:"samples_per_pixel"`
+				Length          int    `json:"length"`
+				Data            []int8 `json:"data"`
+			}
+			if err := json.Unmarshal(tc.uploaded.sidecars[name], &peaks); err != nil {
+				t.Fatalf("unmarshal peaks: %v", err)
+			}
+			// 50 ms peaks over the whole job.
+			if want := int(math.Round(result.DurationSeconds / 0.05)); peaks.Length != want || len(peaks.Data) != 2*want {
+				t.Errorf("length = %d with %d values, want %d peaks", peaks.Length, len(peaks.Data), want)
+			}
+			if peaks.SampleRate != 16000 || peaks.SamplesPerPixel != 800 {
+				t.Errorf("sample_rate %d samples_per_pixel %d, want 16000 and 800", peaks.SampleRate, peaks.SamplesPerPixel)
+			}
+		})
+	}
+}
+
+// recordingStorage keeps every uploaded object.
+type recordingStorage struct {
+	objects map[string][]byte
+	opts    map[string]jobs.UploadOptions
+	order   []string
+}
+
+func (m *recordingStorage) Upload(_ context.Context, data []byte, filename string, opts jobs.UploadOptions) (string, error) {
+	if m.objects == nil {
+		m.objects, m.opts = map[string][]byte{}, map[string]jobs.UploadOptions{}
+	}
+	m.objects[filename] = data
+	m.opts[filename] = opts
+	m.order = append(m.order, filename)
+	return "https://storage.example.com/" + filename, nil
+}
+
+func TestProcessJob_HLS(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{
+		ID:           "test-job-hls",
+		Text:         strings.Repeat("あいうえお。", 2000),
+		VoiceID:      "ja-jp-female-a",
+		OutputFormat: jobs.OutputFormatHLS,
+	}
+	storage := &recordingStorage{}
+	gen := &mockTTSGenerator{}
+
+	result, err := jobs.ProcessJob(context.Background(), job, voice, gen, storage, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	playlistPath := jobs.HLSPlaylistPath(job.ID)
+	if result.AudioURL != "https://storage.example.com/"+playlistPath || result.AudioPath != "" {
+		t.Errorf("AudioURL %q AudioPath %q, want the playlist and no single object", result.AudioURL, result.AudioPath)
+	}
+	if storage.opts[playlistPath].CacheControl == "" {
+		t.Error("playlist uploaded without Cache-Control")
+	}
+
+	playlist := string(storage.objects[playlistPath])
+	for _, want := range []string{"#EXTM3U\n", "#EXT-X-TARGETDURATION:6\n", "#EXT-X-PLAYLIST-TYPE:EVENT\n"} {
+		if !strings.Contains(playlist, want) {
+			t.Errorf("playlist missing %q:\n%s", want, playlist)
+		}
+	}
+	if !strings.HasSuffix(playlist, "#EXT-X-ENDLIST\n") {
+		t.Errorf("playlist not ended:\n%s", playlist)
+	}
+
+	// 576-sample frames at 16 kHz: 166 frames fit in 6 s. The stream
+	// covers every input sample plus the encoder delay.
+	frames := (gen.callCount*16000 + mp3.EncoderDelay + 575) / 576
+	var segments []string
+	var total float64
+	var size int64
+	for _, line := range strings.Split(playlist, "\n") {
+		if strings.HasPrefix(line, "#EXTINF:") {
+			var d float64
+			fmt.Sscanf(line, "#EXTINF:%f,", &d)
+			if d > 6 {
+				t.Errorf("segment of %.3f s exceeds the target duration", d)
+			}
+			total += d
+		} else if strings.HasSuffix(line, ".mp3") {
+			segments = append(segments, line)
+		}
+	}
+	if want := float64(frames) * 0.036; math.Abs(total-want) > 0.01*float64(len(segments)) {
+		t.Errorf("segments total %.3f s, want %.3f", total, want)
+	}
+	if want := (frames + 165) / 166; len(segments) != want {
+		t.Errorf("%d segments, want %d", len(segments), want)
+	}
+
+	for i, name := range segments {
+		data := storage.objects["audio/hls/test-job-hls/"+name]
+		size += int64(len(data))
+		// ID3 PRIV timestamp (10 + 10 + 45 + 8 bytes), then MP3 frames.
+		if len(data) < 75 || string(data[:3]) != "ID3" || data[73] != 0xff {
+			t.Fatalf("segment %s does not start with an ID3 timestamp and an MP3 frame", name)
+		}
+		if pts, want := binary.BigEndian.Uint64(data[65:73]), uint64(i*166*576*90000/16000); pts != want {
+			t.Errorf("segment %s timestamp %d, want %d", name, pts, want)
+		}
+	}
+	if result.AudioBytes != size {
+		t.Errorf("AudioBytes = %d, want %d", result.AudioBytes, size)
+	}
+
+	// Each segment is stored before the playlist that lists it.
+	seen := map[string]bool{}
+	for _, name := range storage.order {
+		seen[name] = true
+		if name == playlistPath && !seen["audio/hls/test-job-hls/"+segments[0]] {
+			t.Error("playlist published before its first segment")
+		}
+	}
+
+	delay := float64(mp3.EncoderDelay) / 16000
+	if math.Abs(result.Timepoints[0].TimeSeconds-(0.1+delay)) > 1e-9 {
+		t.Errorf("first timepoint at %f, want %f", result.Timepoints[0].TimeSeconds, 0.1+delay)
+	}
+}
+
+func TestProcessJob_HLSFailure(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{
+		ID:           "test-job-hls-failed",
+		Text:         strings.Repeat("あいうえお。", 2000),
+		VoiceID:      "ja-jp-female-a",
+		OutputFormat: jobs.OutputFormatHLS,
+	}
+	playlistPath := jobs.HLSPlaylistPath(job.ID)
+
+	for _, tc := range []struct {
+		name     string
+		failAt   int
+		segments int
+	}{
+		// 8 s of audio before the failure: one full 6 s segment.
+		{"after a segment", 9, 1},
+		{"before any audio", 1, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			storage := &recordingStorage{}
+			if _, err := jobs.ProcessJob(context.Background(), job, voice, &mockTTSGenerator{failAt: tc.failAt}, storage, nil); err == nil {
+				t.Fatal("expected an error")
+			}
+			playlist := string(storage.objects[playlistPath])
+			if !strings.HasSuffix(playlist, "#EXT-X-ENDLIST\n") {
+				t.Errorf("playlist of a failed job not ended:\n%s", playlist)
+			}
+			if got := strings.Count(playlist, "#EXTINF:"); got != tc.segments {
+				t.Errorf("playlist lists %d segments, want %d:\n%s", got, tc.segments, playlist)
+			}
+		})
+	}
+}
+
+// markTTSGenerator returns 1 s of silence per chunk with a timepoint every
+// 0.1 s for each of the chunk's first ten characters.
+type markTTSGenerator struct{}
+
+func (markTTSGenerator) Generate(_ context.Context, text string, _ *config.VoiceOption, _ string) ([]byte, []jobs.TTSTimepoint, error) {
+	var tps []jobs.TTSTimepoint
+	for i := range min(len([]rune(text)), 10) {
+		tps = append(tps, jobs.TTSTimepoint{MarkName: fmt.Sprintf("%d:%d:%d", i, i, i+1), TimeSeconds: 0.1 * float64(i)})
+	}
+	return makeWAV(16000, 1, 16, 16000), tps, nil
+}
+
+func TestProcessJob_Chapters(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	// Heading pauses put each heading in its own 1 s chunk with 0.5 s of
+	// silence either side: chunks start at 0, 1.5, 3 and 4.5 s.
+	text := "# はじめに\n本文です。\n# 第二部\n本文です。"
+	want := []jobs.Chapter{{Title: "はじめに", CharOffset: 0, TimeSeconds: 0}, {Title: "第二部", CharOffset: 13, TimeSeconds: 3}}
+	check := func(t *testing.T, got []jobs.Chapter, want []jobs.Chapter, delay float64) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("chapters = %+v, want %+v", got, want)
+		}
+		for i := range want {
+			w := want[i]
+			w.TimeSeconds += delay
+			if got[i].Title != w.Title || got[i].CharOffset != w.CharOffset || math.Abs(got[i].TimeSeconds-w.TimeSeconds) > 1e-9 {
+				t.Errorf("chapter %d = %+v, want %+v", i, got[i], w)
+			}
+		}
+	}
+
+	buffered := &mockAudioStorage{}
+	streaming := &mockStreamingStorage{}
+	for _, tc := range []struct {
+		name     string
+		storage  jobs.AudioStorage
+		uploaded *mockAudioStorage
+	}{
+		{"buffered", buffered, buffered},
+		{"streaming", streaming, &streaming.mockAudioStorage},
+	} {
+		t.Run("wav "+tc.name, func(t *testing.T) {
+			job := &jobs.Job{ID: "test-job-chapters", Text: text, VoiceID: "ja-jp-female-a", Pauses: &jobs.Pauses{HeadingMs: 500}, AutoChapters: true}
+			result, err := jobs.ProcessJob(context.Background(), job, voice, markTTSGenerator{}, tc.storage, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			check(t, result.Chapters, want, 0)
+			cues, err := wav.Cues(tc.uploaded.uploadedData)
+			if err != nil {
+				t.Fatalf("Cues: %v", err)
+			}
+			if len(cues) != 2 || cues[0] != (wav.Cue{Frame: 0, Label: "はじめに"}) || cues[1] != (wav.Cue{Frame: 48000, Label: "第二部"}) {
+				t.Errorf("cues = %+v", cues)
+			}
+			if got := wav.Duration(tc.uploaded.uploadedData); got != 5.5 {
+				t.Errorf("uploaded duration = %f, want 5.5", got)
+			}
+			if result.AudioBytes != int64(len(tc.uploaded.uploadedData)) {
+				t.Errorf("AudioBytes = %d, want %d", result.AudioBytes, len(tc.uploaded.uploadedData))
+			}
+		})
+
+		t.Run("mp3 "+tc.name, func(t *testing.T) {
+			job := &jobs.Job{ID: "test-job-chapters", Text: text, VoiceID: "ja-jp-female-a", Pauses: &jobs.Pauses{HeadingMs: 500}, OutputFormat: jobs.OutputFormatMP3, AutoChapters: true}
+			result, err := jobs.ProcessJob(context.Background(), job, voice, markTTSGenerator{}, tc.storage, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			delay := float64(mp3.EncoderDelay) / 16000
+			check(t, result.Chapters, want, delay)
+
+			data := tc.uploaded.uploadedData
+			if !bytes.HasPrefix(data, []byte("ID3\x04")) {
+				t.Fatalf("mp3 does not start with an ID3v2.4 tag")
+			}
+			size := int(data[6])<<21 | int(data[7])<<14 | int(data[8])<<7 | int(data[9])
+			tag := data[10 : 10+size]
+			if data[10+size] != 0xff {
+				t.Error("no MP3 frame after the ID3 tag")
+			}
+			i := bytes.Index(tag, []byte("chp1\x00"))
+			if j := bytes.LastIndex(tag, []byte("chp1\x00")); i < 0 || j == i {
+				t.Fatalf("CHAP frame for the second chapter not found")
+			} else {
+				start := binary.BigEndian.Uint32(tag[j+5:])
+				if want := uint32(math.Round((3 + delay) * 1000)); start != want {
+					t.Errorf("CHAP start = %d ms, want %d", start, want)
+				}
+			}
+			if result.AudioBytes != int64(len(data)) {
+				t.Errorf("AudioBytes = %d, want %d", result.AudioBytes, len(data))
+			}
+		})
+	}
+
+	t.Run("explicit mid-chunk", func(t *testing.T) {
+		// Without pauses the text is one chunk; an explicit chapter inside
+		// it starts at the first timepoint at or after its offset.
+		job := &jobs.Job{
+			ID:       "test-job-chapters",
+			Text:     "前書き。本編はここから。",
+			VoiceID:  "ja-jp-female-a",
+			Chapters: []jobs.Chapter{{Title: "前書き", CharOffset: 0}, {Title: "本編", CharOffset: 4}},
+		}
+		result, err := jobs.ProcessJob(context.Background(), job, voice, markTTSGenerator{}, &mockAudioStorage{}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		check(t, result.Chapters, []jobs.Chapter{{Title: "前書き", CharOffset: 0}, {Title: "本編", CharOffset: 4, TimeSeconds: 0.4}}, 0)
+	})
+
+	t.Run("headings not detected by default", func(t *testing.T) {
+		uploaded := &mockAudioStorage{}
+		job := &jobs.Job{ID: "test-job-chapters", Text: text, VoiceID: "ja-jp-female-a", Pauses: &jobs.Pauses{HeadingMs: 500}}
+		result, err := jobs.ProcessJob(context.Background(), job, voice, markTTSGenerator{}, uploaded, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(result.Chapters) != 0 {
+			t.Errorf("chapters = %+v, want none", result.Chapters)
+		}
+		if cues, err := wav.Cues(uploaded.uploadedData); err != nil || len(cues) != 0 {
+			t.Errorf("cues = %+v (%v), want none", cues, err)
+		}
+	})
+}
+
+func TestProcessJob_Progress(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	text := "# はじめに\n本文です。\n# 第二部\n本文です。"
+	for _, tc := range []struct {
+		name string
+		job  jobs.Job
+	}{
+		{"wav", jobs.Job{}},
+		{"mp3 stretched", jobs.Job{OutputFormat: jobs.OutputFormatMP3, RenderSpeed: 1.25}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			job := tc.job
+			job.ID, job.Text, job.VoiceID, job.Pauses = "test-job-progress", text, "ja-jp-female-a", &jobs.Pauses{HeadingMs: 500}
+
+			var chunks []int
+			var tps []jobs.TTSTimepoint
+			result, err := jobs.ProcessJob(context.Background(), &job, voice, markTTSGenerator{}, &mockAudioStorage{}, func(chunk, total int, got []jobs.TTSTimepoint) {
+				if total != 4 {
+					t.Errorf("chunk %d of %d, want 4 chunks", chunk, total)
+				}
+				chunks = append(chunks, chunk)
+				tps = append(tps, got...)
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fmt.Sprint(chunks) != "[1 2 3 4]" {
+				t.Errorf("progress chunks = %v, want [1 2 3 4]", chunks)
+			}
+			// Progress already reports the final timeline.
+			if len(tps) != len(result.Timepoints) {
+				t.Fatalf("progress timepoints = %d, result has %d", len(tps), len(result.Timepoints))
+			}
+			for i := range tps {
+				if tps[i].MarkName != result.Timepoints[i].MarkName || math.Abs(tps[i].TimeSeconds-result.Timepoints[i].TimeSeconds) > 1e-9 {
+					t.Errorf("progress timepoint %d = %+v, result %+v", i, tps[i], result.Timepoints[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProcessJob_RejectsInvalidTTSAudio(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{ID: "test-job-bad", Text: "短いテキスト", VoiceID: "ja-jp-female-a"}
+	gen := ttsFunc(func() ([]byte, error) { return []byte("not a wav file at all"), nil })
+
+	_, err := jobs.ProcessJob(context.Background(), job, voice, gen, &mockAudioStorage{}, nil)
+	if !errors.Is(err, wav.ErrNotWAV) {
+		t.Errorf("err = %v, want wav.ErrNotWAV", err)
+	}
+}
+
+// ttsFunc adapts a function returning raw audio to jobs.TTSGenerator.
+type ttsFunc func() ([]byte, error)
+
+func (f ttsFunc) Generate(context.Context, string, *config.VoiceOption, string) ([]byte, []jobs.TTSTimepoint, error) {
+	audio, err := f()
+	return audio, nil, err
+}
+
+func TestProcessJob_MP3Output(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{
+		ID:           "test-job-mp3",
+		Text:         strings.Repeat("あいうえお。", 400),
+		VoiceID:      "ja-jp-female-a",
+		Language:     "ja-JP",
+		OutputFormat: jobs.OutputFormatMP3,
+		Bitrate:      32,
+	}
+
+	buffered := &mockAudioStorage{}
+	streaming := &mockStreamingStorage{}
+	for _, tc := range []struct {
+		name     string
+		storage  jobs.AudioStorage
+		uploaded *mockAudioStorage
+	}{
+		{"buffered", buffered, buffered},
+		{"streaming", streaming, &streaming.mockAudioStorage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gen := &mockTTSGenerator{}
+			result, err := jobs.ProcessJob(context.Background(), job, voice, gen, tc.storage, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			uploaded := tc.uploaded
+
+			if !strings.HasSuffix(uploaded.uploadedName, ".mp3") {
+				t.Errorf("filename %q should end in .mp3", uploaded.uploadedName)
+			}
+			if uploaded.uploadedOpts.ContentType != "audio/mpeg" {
+				t.Errorf("ContentType = %q, want audio/mpeg", uploaded.uploadedOpts.ContentType)
+			}
+			data := uploaded.uploadedData
+			if len(data) < 2 || data[0] != 0xFF || data[1]&0xE0 != 0xE0 {
+				t.Fatalf("output does not start with an MPEG frame sync")
+			}
+			if result.AudioBytes != int64(len(data)) {
+				t.Errorf("AudioBytes = %d, want %d", result.AudioBytes, len(data))
+			}
+			// 32 kbps over the PCM duration, plus the delay padding frames.
+			if want := result.DurationSeconds * 32000 / 8; float64(len(data)) < want || float64(len(data)) > want+2000 {
+				t.Errorf("size %d bytes, want ~%.0f", len(data), want)
+			}
+			// The PCM digest still describes the synthesized samples, not the MP3 bytes.
+			if result.PCMBytes != int64(gen.callCount*32000) {
+				t.Errorf("PCMBytes = %d, want %d", result.PCMBytes, gen.callCount*32000)
+			}
+
+			delay := float64(mp3.EncoderDelay) / 16000
+			if len(result.Timepoints) != gen.callCount {
+				t.Fatalf("expected %d timepoints, got %d", gen.callCount, len(result.Timepoints))
+			}
+			for i, tp := range result.Timepoints {
+				if want := float64(i) + 0.1 + delay; math.Abs(tp.TimeSeconds-want) > 1e-9 {
+					t.Errorf("timepoint %d at %f, want %f", i, tp.TimeSeconds, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessJob_FLACOutput(t *testing.T) {
+	voice := &config.VoiceOption{ID: "ja-jp-female-a", Name: "あかり", Language: "ja-JP", WavenetVoice: "ja-JP-Wavenet-A"}
+	job := &jobs.Job{
+		ID:           "test-job-flac",
+		Text:         strings.Repeat("あいうえお。", 400),
+		VoiceID:      "ja-jp-female-a",
+		Language:     "ja-JP",
+		Title:        "吾輩は猫である",
+		OutputFormat: jobs.OutputFormatFLAC,
+	}
+
+	buffered := &mockAudioStorage{}
+	streaming := &mockStreamingStorage{}
+	for _, tc := range []struct {
+		name     string
+		storage  jobs.AudioStorage
+		uploaded *mockAudioStorage
+	}{
+		{"buffered", buffered, buffered},
+		{"streaming", streaming, &streaming.mockAudioStorage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gen := &mockTTSGenerator{}
+			result, err := jobs.ProcessJob(context.Background(), job, voice, gen, tc.storage, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			uploaded := tc.uploaded
+
+			if !strings.HasSuffix(uploaded.uploadedName, ".flac") {
+				t.Errorf("filename %q should end in .flac", uploaded.uploadedName)
+			}
+			if uploaded.uploadedOpts.ContentType != "audio/flac" {
+				t.Errorf("ContentType = %q, want audio/flac", uploaded.uploadedOpts.ContentType)
+			}
+			if result.AudioBytes != int64(len(uploaded.uploadedData)) {
+				t.Errorf("AudioBytes = %d, want %d", result.AudioBytes, len(uploaded.uploadedData))
+			}
+
+			stream, err := mflac.Parse(bytes.NewReader(uploaded.uploadedData))
+			if err != nil {
+				t.Fatalf("parse FLAC: %v", err)
+			}
+			if want := uint64(gen.callCount * 16000); stream.Info.NSamples != want {
+				t.Errorf("NSamples = %d, want %d", stream.Info.NSamples, want)
+			}
+			tags := map[string]string{}
+			for _, block := range stream.Blocks {
+				if vc, ok := block.Body.(*meta.VorbisComment); ok {
+					for _, tag := range vc.Tags {
+						tags[tag[0]] = tag[1]
+					}
+				}
+			}
+			if tags["TITLE"] != job.Title || tags["ARTIST"] != voice.Name || tags["VOICE"] != voice.ID {
+				t.Errorf("unexpected tags: %v", tags)
+			}
+
+			// Lossless: timepoints are not shifted.
+			for i, tp := range result.Timepoints {
+				if want := float64(i) + 0.1; math.Abs(tp.TimeSeconds-want) > 1e-9 {
+					t.Errorf("timepoint %d at %f, want %f", i, tp.TimeSeconds, want)
+				}
+			}
+		})
+	}
+}
@@ -54,14 +54,29 @@ func (s *FirestoreJobStore) SetProcessing(ctx context.Context, jobID string) err
 	return nil
 }
 
-func (s *FirestoreJobStore) SetCompleted(ctx context.Context, jobID, audioURL string, timepoints []TTSTimepoint) error {
+func (s *FirestoreJobStore) SetCompleted(ctx context.Context, jobID string, result *ProcessResult) error {
 	updates := []firestore.Update{
 		{Path: "status", Value: JobStatusCompleted},
-		{Path: "audioUrl", Value: audioURL},
+		{Path: "audioUrl", Value: result.AudioURL},
+		{Path: "audioPath", Value: result.AudioPath},
+		{Path: "durationSeconds", Value: result.DurationSeconds},
+		{Path: "pcmSha256", Value: result.PCMSHA256},
+		{Path: "pcmBytes", Value: result.PCMBytes},
 		{Path: "updatedAt", Value: time.Now()},
 	}
-	if len(timepoints) > 0 {
-		updates = append(updates, firestore.Update{Path: "timepoints", Value: timepoints})
+	if result.PeaksURL != "" {
+		updates = append(updates, firestore.Update{Path: "peaksUrl", Value: result.PeaksURL})
+	}
+	if len(result.Chapters) > 0 {
+		updates = append(updates, firestore.Update{Path: "chapters", Value: result.Chapters})
+	}
+	if result.OutputLUFS != 0 {
+		updates = append(updates,
+			firestore.Update{Path: "outputLufs", Value: result.OutputLUFS},
+			firestore.Update{Path: "loudnessApproximate", Value: result.LoudnessApproximate})
+	}
+	if len(result.Timepoints) > 0 {
+		updates = append(updates, firestore.Update{Path: "timepoints", Value: result.Timepoints})
 	}
 	_, err := s.client.Collection(jobsCollection).Doc(jobID).Update(ctx, updates)
 	if err != nil {
@@ -81,3 +96,19 @@ func (s *FirestoreJobStore) SetFailed(ctx context.Context, jobID, errMsg string)
 	}
 	return nil
 }
+
+// AddWebhookAttempts appends delivery attempts to the job's webhookAttempts.
+func (s *FirestoreJobStore) AddWebhookAttempts(ctx context.Context, jobID string, attempts []WebhookAttempt) error {
+	values := make([]interface{}, len(attempts))
+	for i, a := range attempts {
+		values[i] = a
+	}
+	_, err := s.client.Collection(jobsCollection).Doc(jobID).Update(ctx, []firestore.Update{
+		{Path: "webhookAttempts", Value: firestore.ArrayUnion(values...)},
+		{Path: "updatedAt", Value: time.Now()},
+	})
+	if err != nil {
+		return fmt.Errorf("firestore add webhook attempts %s: %w", jobID, err)
+	}
+	return nil
+}
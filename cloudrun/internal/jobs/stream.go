@@ -0,0 +1,41 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+// SynthesizeStream synthesizes text one SplitText chunk at a time for
+// callers that send audio while the rest is still being generated. emit
+// receives each chunk's PCM, converted to the first chunk's format as in
+// ProcessJob, and its timepoints adjusted to the whole text and audio.
+// Synthesis stops at the first error from gen or emit, or when ctx is
+// cancelled between chunks.
+func SynthesizeStream(
+	ctx context.Context,
+	text string,
+	voice *config.VoiceOption,
+	language string,
+	gen TTSGenerator,
+	emit func(f wav.Format, pcm []byte, tps []TTSTimepoint) error,
+) error {
+	var pcmFormat *wav.Format
+	var cumulativeTime float64
+	for _, chunk := range SplitText(text, MaxChunkBytes) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f, pcm, tps, err := synthesizeChunk(ctx, gen, chunk, voice, language, pcmFormat)
+		if err != nil {
+			return err
+		}
+		pcmFormat = f
+		if err := emit(*pcmFormat, pcm, AdjustTimepoints(tps, chunk.CharOffset, cumulativeTime)); err != nil {
+			return err
+		}
+		cumulativeTime += pcmFormat.Duration(int64(len(pcm)))
+	}
+	return nil
+}
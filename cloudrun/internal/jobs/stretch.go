@@ -0,0 +1,61 @@
+package jobs
+
+import (
+	"fmt"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+// Bounds for Job.RenderSpeed. Outside them time-stretching artifacts
+// become obvious and client-side playback rate is the better tool.
+const (
+	MinRenderSpeed = 0.5
+	MaxRenderSpeed = 3.0
+)
+
+// ValidateRenderSpeed checks speed is 0 (unchanged) or within
+// [MinRenderSpeed, MaxRenderSpeed].
+func ValidateRenderSpeed(speed float64) error {
+	if speed != 0 && !(speed >= MinRenderSpeed && speed <= MaxRenderSpeed) {
+		return fmt.Errorf("renderSpeed %.2f out of range %.1f to %.1f", speed, MinRenderSpeed, MaxRenderSpeed)
+	}
+	return nil
+}
+
+// stretchSource changes the tempo of src by speed without changing its
+// pitch. The output is exactly 1/speed as long as the input, so anything
+// timed against the input scales by the same factor.
+func stretchSource(src pcmSource, speed float64) pcmSource {
+	return func(emit func(f wav.Format, pcm []byte) error) error {
+		var s *wav.Stretcher
+		var format wav.Format
+		err := src(func(f wav.Format, pcm []byte) error {
+			if s == nil {
+				var err error
+				if s, err = wav.NewStretcher(f, speed); err != nil {
+					return fmt.Errorf("render speed: %w", err)
+				}
+				format = f
+			}
+			if out := s.Write(pcm); len(out) > 0 {
+				return emit(f, out)
+			}
+			return nil
+		})
+		if err != nil || s == nil {
+			return err
+		}
+		if out := s.Flush(); len(out) > 0 {
+			return emit(format, out)
+		}
+		return nil
+	}
+}
+
+// scaleTimepoints divides every timepoint by speed.
+func scaleTimepoints(tps []TTSTimepoint, speed float64) []TTSTimepoint {
+	for i := range tps {
+		tps[i].TimeSeconds /= speed
+	}
+	return tps
+}
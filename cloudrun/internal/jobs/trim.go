@@ -0,0 +1,40 @@
+package jobs
+
+import (
+	"fmt"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+// Default and bounds for SilenceTrim.
+const (
+	defaultTrimThresholdDB = -50.0
+	minTrimThresholdDB     = -90.0
+	maxTrimThresholdDB     = -20.0
+)
+
+// SilenceTrim removes the lead-in and tail silence the TTS engine leaves
+// on each chunk before the chunks are joined.
+type SilenceTrim struct {
+	ThresholdDB float64 `firestore:"thresholdDb,omitempty" json:"thresholdDb,omitempty"` // silence level in dBFS; 0 means -50
+	KeepMs      int     `firestore:"keepMs,omitempty"      json:"keepMs,omitempty"`      // silence kept at each end of a chunk
+}
+
+// Validate checks the threshold and kept silence are within range.
+func (s SilenceTrim) Validate() error {
+	if s.ThresholdDB != 0 && (s.ThresholdDB < minTrimThresholdDB || s.ThresholdDB > maxTrimThresholdDB) {
+		return fmt.Errorf("thresholdDb %.1f out of range %.0f to %.0f", s.ThresholdDB, minTrimThresholdDB, maxTrimThresholdDB)
+	}
+	if s.KeepMs < 0 || s.KeepMs > MaxPauseMs {
+		return fmt.Errorf("keepMs %d out of range 0-%d", s.KeepMs, MaxPauseMs)
+	}
+	return nil
+}
+
+func (s SilenceTrim) options() wav.TrimOptions {
+	threshold := s.ThresholdDB
+	if threshold == 0 {
+		threshold = defaultTrimThresholdDB
+	}
+	return wav.TrimOptions{ThresholdDB: threshold, Keep: millis(s.KeepMs)}
+}
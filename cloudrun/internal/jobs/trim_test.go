@@ -0,0 +1,20 @@
+package jobs_test
+
+import (
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+)
+
+func TestSilenceTrim_Validate(t *testing.T) {
+	for _, s := range []jobs.SilenceTrim{{}, {ThresholdDB: -60, KeepMs: 100}} {
+		if err := s.Validate(); err != nil {
+			t.Errorf("%+v: unexpected error: %v", s, err)
+		}
+	}
+	for _, s := range []jobs.SilenceTrim{{ThresholdDB: -10}, {ThresholdDB: -100}, {KeepMs: -1}, {KeepMs: jobs.MaxPauseMs + 1}} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("%+v: expected error", s)
+		}
+	}
+}
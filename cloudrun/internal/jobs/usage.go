@@ -0,0 +1,81 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
+)
+
+// Rough rates used to estimate a job's output before synthesis. LINEAR16
+// at 24kHz mono is 48000 bytes per second; FLAC typically stores speech
+// in a little over half of that.
+const (
+	estimatedCharsPerSecond = 8.0
+	estimatedWAVBytesPerSec = 48000
+	estimatedFLACRatio      = 0.6
+)
+
+// Usage is the generated-audio accounting for one signed-in user.
+type Usage struct {
+	OwnerID      string    `firestore:"ownerId"      json:"ownerId"`
+	Tier         string    `firestore:"tier"         json:"tier"`
+	BytesStored  int64     `firestore:"bytesStored"  json:"bytesStored"`
+	AudioSeconds float64   `firestore:"audioSeconds" json:"audioSeconds"`
+	JobCount     int64     `firestore:"jobCount"     json:"jobCount"`
+	UpdatedAt    time.Time `firestore:"updatedAt"    json:"updatedAt"`
+}
+
+// QuotaExceededError reports which tier limit a new job would exceed.
+type QuotaExceededError struct {
+	Tier      string
+	Resource  string // "bytesStored" or "audioSeconds"
+	Used      float64
+	Requested float64
+	Limit     float64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota exceeded for %s tier: used %.0f + requested %.0f > limit %.0f",
+		e.Resource, e.Tier, e.Used, e.Requested, e.Limit)
+}
+
+// EstimateAudio returns a rough duration and stored size for text that has
+// not been synthesized yet, in format at bitrate kbps (MP3 and HLS only).
+func EstimateAudio(text string, format OutputFormat, bitrate int) (seconds float64, bytes int64) {
+	seconds = float64(utf8.RuneCountInString(text)) / estimatedCharsPerSecond
+	perSecond := float64(estimatedWAVBytesPerSec)
+	switch format {
+	case OutputFormatMP3, OutputFormatHLS:
+		perSecond = float64(bitrate) * 1000 / 8
+	case OutputFormatFLAC:
+		perSecond *= estimatedFLACRatio
+	}
+	return seconds, int64(seconds * perSecond)
+}
+
+// CheckQuota returns a *QuotaExceededError when adding the requested audio to
+// the owner's current usage would exceed the limits of their tier.
+func CheckQuota(usage *Usage, reqSeconds float64, reqBytes int64) error {
+	limits := config.GetQuotaLimits(usage.Tier)
+	if float64(usage.BytesStored+reqBytes) > float64(limits.MaxBytesStored) {
+		return &QuotaExceededError{
+			Tier:      usage.Tier,
+			Resource:  "bytesStored",
+			Used:      float64(usage.BytesStored),
+			Requested: float64(reqBytes),
+			Limit:     float64(limits.MaxBytesStored),
+		}
+	}
+	if usage.AudioSeconds+reqSeconds > limits.MaxAudioSeconds {
+		return &QuotaExceededError{
+			Tier:      usage.Tier,
+			Resource:  "audioSeconds",
+			Used:      usage.AudioSeconds,
+			Requested: reqSeconds,
+			Limit:     limits.MaxAudioSeconds,
+		}
+	}
+	return nil
+}
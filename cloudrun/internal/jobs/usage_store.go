@@ -0,0 +1,60 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
+)
+
+const usageCollection = "ttsUsage"
+
+// FirestoreUsageStore is the Firestore-backed implementation of UsageStore.
+// The tier field is maintained outside this service (e.g. by subscription
+// verification); documents without one are treated as free tier.
+type FirestoreUsageStore struct {
+	client *firestore.Client
+}
+
+// NewFirestoreUsageStore creates a new FirestoreUsageStore.
+func NewFirestoreUsageStore(client *firestore.Client) *FirestoreUsageStore {
+	return &FirestoreUsageStore{client: client}
+}
+
+func (s *FirestoreUsageStore) Get(ctx context.Context, ownerID string) (*Usage, error) {
+	doc, err := s.client.Collection(usageCollection).Doc(ownerID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return &Usage{OwnerID: ownerID, Tier: config.TierFree}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("firestore get usage %s: %w", ownerID, err)
+	}
+	var usage Usage
+	if err := doc.DataTo(&usage); err != nil {
+		return nil, fmt.Errorf("firestore decode usage %s: %w", ownerID, err)
+	}
+	usage.OwnerID = ownerID
+	if usage.Tier == "" {
+		usage.Tier = config.TierFree
+	}
+	return &usage, nil
+}
+
+func (s *FirestoreUsageStore) Add(ctx context.Context, ownerID string, bytes int64, seconds float64) error {
+	_, err := s.client.Collection(usageCollection).Doc(ownerID).Set(ctx, map[string]interface{}{
+		"ownerId":      ownerID,
+		"bytesStored":  firestore.Increment(bytes),
+		"audioSeconds": firestore.Increment(seconds),
+		"jobCount":     firestore.Increment(1),
+		"updatedAt":    time.Now(),
+	}, firestore.MergeAll)
+	if err != nil {
+		return fmt.Errorf("firestore add usage %s: %w", ownerID, err)
+	}
+	return nil
+}
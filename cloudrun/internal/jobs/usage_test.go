@@ -0,0 +1,99 @@
+package jobs_test
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/config"
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/jobs"
+)
+
+func TestEstimateAudio(t *testing.T) {
+	// 800 characters at ~8 chars/sec → ~100 seconds
+	text := strings.Repeat("あ", 800)
+	for _, tt := range []struct {
+		format  jobs.OutputFormat
+		bitrate int
+		want    int64
+	}{
+		{jobs.OutputFormatWAV, 0, 100 * 48000},
+		{jobs.OutputFormatFLAC, 0, 100 * 48000 * 6 / 10},
+		{jobs.OutputFormatMP3, 64, 100 * 8000},
+		{jobs.OutputFormatHLS, 128, 100 * 16000},
+	} {
+		seconds, bytes := jobs.EstimateAudio(text, tt.format, tt.bitrate)
+		if math.Abs(seconds-100) > 0.001 {
+			t.Errorf("%s: seconds = %f, want 100", tt.format, seconds)
+		}
+		if bytes != tt.want {
+			t.Errorf("%s: bytes = %d, want %d", tt.format, bytes, tt.want)
+		}
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	free := config.QuotaTiers[config.TierFree]
+
+	tests := []struct {
+		name         string
+		usage        jobs.Usage
+		reqSeconds   float64
+		reqBytes     int64
+		wantResource string // empty = allowed
+	}{
+		{
+			name:  "empty usage allowed",
+			usage: jobs.Usage{Tier: config.TierFree},
+		},
+		{
+			name:         "bytes exceeded",
+			usage:        jobs.Usage{Tier: config.TierFree, BytesStored: free.MaxBytesStored - 10},
+			reqBytes:     11,
+			wantResource: "bytesStored",
+		},
+		{
+			name:         "seconds exceeded",
+			usage:        jobs.Usage{Tier: config.TierFree, AudioSeconds: free.MaxAudioSeconds - 1},
+			reqSeconds:   2,
+			wantResource: "audioSeconds",
+		},
+		{
+			name:       "exactly at limit allowed",
+			usage:      jobs.Usage{Tier: config.TierFree, AudioSeconds: free.MaxAudioSeconds - 2},
+			reqSeconds: 2,
+		},
+		{
+			name:       "premium has higher limits",
+			usage:      jobs.Usage{Tier: config.TierPremium, BytesStored: free.MaxBytesStored},
+			reqBytes:   1 << 20,
+			reqSeconds: 60,
+		},
+		{
+			name:         "unknown tier uses free limits",
+			usage:        jobs.Usage{Tier: "", BytesStored: free.MaxBytesStored},
+			reqBytes:     1,
+			wantResource: "bytesStored",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := jobs.CheckQuota(&tt.usage, tt.reqSeconds, tt.reqBytes)
+			if tt.wantResource == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			var quotaErr *jobs.QuotaExceededError
+			if !errors.As(err, &quotaErr) {
+				t.Fatalf("expected QuotaExceededError, got %v", err)
+			}
+			if quotaErr.Resource != tt.wantResource {
+				t.Errorf("resource = %s, want %s", quotaErr.Resource, tt.wantResource)
+			}
+		})
+	}
+}
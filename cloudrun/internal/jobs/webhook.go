@@ -0,0 +1,232 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader carries "t=<unix seconds>,v1=<hex HMAC>", where
+	// the HMAC-SHA256 is keyed with the webhook secret and covers
+	// "<t>.<body>".
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	// WebhookIDHeader repeats the event ID so receivers can drop redeliveries.
+	WebhookIDHeader = "X-Webhook-Id"
+	// WebhookTolerance is the replay window: receivers should reject
+	// signatures whose timestamp is further than this from their clock.
+	WebhookTolerance = 5 * time.Minute
+)
+
+// Webhook event types.
+const (
+	WebhookJobCompleted = "job.completed"
+	WebhookJobFailed    = "job.failed"
+)
+
+const (
+	maxCallbackURL     = 2048
+	webhookTimeout     = 10 * time.Second
+	webhookMaxAttempts = 4
+	webhookBackoff     = time.Second
+)
+
+// WebhookEvent is the JSON body POSTed to a job's callbackUrl.
+type WebhookEvent struct {
+	ID              string    `json:"id"` // "<jobId>:<type>", the same for every delivery
+	Type            string    `json:"type"`
+	JobID           string    `json:"jobId"`
+	FileID          string    `json:"fileId,omitempty"`
+	Status          JobStatus `json:"status"`
+	AudioURL        string    `json:"audioUrl,omitempty"`
+	DurationSeconds float64   `json:"durationSeconds,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// WebhookAttempt records one delivery attempt on the job.
+type WebhookAttempt struct {
+	EventID    string    `firestore:"eventId"              json:"eventId"`
+	At         time.Time `firestore:"at"                   json:"at"`
+	StatusCode int       `firestore:"statusCode,omitempty" json:"statusCode,omitempty"` // 0 when no response arrived
+	Error      string    `firestore:"error,omitempty"      json:"error,omitempty"`
+}
+
+// ValidateCallbackURL checks that s is an absolute https URL without
+// credentials.
+func ValidateCallbackURL(s string) error {
+	if len(s) > maxCallbackURL {
+		return fmt.Errorf("callbackUrl longer than %d bytes", maxCallbackURL)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("callbackUrl: %w", err)
+	}
+	if u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("callbackUrl must be an https URL without credentials")
+	}
+	return nil
+}
+
+// SignWebhook returns the WebhookSignatureHeader value for body sent at t.
+func SignWebhook(secret, body []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + webhookMAC(secret, ts, body)
+}
+
+func webhookMAC(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks a WebhookSignatureHeader value against body, and
+// that its timestamp is within tolerance of now. It is what receivers are
+// expected to do, in Go.
+func VerifyWebhook(secret, body []byte, header string, now time.Time, tolerance time.Duration) error {
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return errors.New("webhook: malformed signature header")
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+		return errors.New("webhook: timestamp outside the replay window")
+	}
+	want := webhookMAC(secret, ts, body)
+	for _, sig := range sigs {
+		if hmac.Equal([]byte(sig), []byte(want)) {
+			return nil
+		}
+	}
+	return errors.New("webhook: signature mismatch")
+}
+
+// HTTPWebhookNotifier is the HTTP implementation of WebhookNotifier.
+type HTTPWebhookNotifier struct {
+	MaxAttempts int           // attempts per event, 4 by default
+	Backoff     time.Duration // delay before the first retry, doubled after each; 1s by default
+
+	secret []byte
+	client *http.Client
+}
+
+// NewHTTPWebhookNotifier creates an HTTPWebhookNotifier that signs with
+// secret. A nil client gets one that refuses to connect to loopback,
+// private and link-local addresses (such as the metadata server), since
+// callback URLs come from API clients.
+func NewHTTPWebhookNotifier(secret []byte, client *http.Client) *HTTPWebhookNotifier {
+	if client == nil {
+		dialer := &net.Dialer{Timeout: webhookTimeout, Control: publicOnly}
+		client = &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: webhookTimeout},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	return &HTTPWebhookNotifier{MaxAttempts: webhookMaxAttempts, Backoff: webhookBackoff, secret: secret, client: client}
+}
+
+// publicOnly is a net.Dialer Control that rejects non-public addresses.
+func publicOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return fmt.Errorf("webhook: refusing to connect to %s", host)
+	}
+	return nil
+}
+
+// Deliver POSTs event to callbackURL, signing each attempt afresh so that
+// retries stay inside the replay window. Network errors, 429 and 5xx
+// responses are retried with exponential backoff; any other response ends
+// delivery. It returns every attempt made and an error unless one got a
+// 2xx response.
+func (n *HTTPWebhookNotifier) Deliver(ctx context.Context, callbackURL string, event WebhookEvent) ([]WebhookAttempt, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("encode webhook event: %w", err)
+	}
+
+	var attempts []WebhookAttempt
+	backoff := n.Backoff
+	for i := range max(n.MaxAttempts, 1) {
+		if i > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return attempts, ctx.Err()
+			}
+			backoff *= 2
+		}
+
+		attempt, retry := n.post(ctx, callbackURL, event.ID, body)
+		attempts = append(attempts, attempt)
+		if attempt.Error == "" {
+			return attempts, nil
+		}
+		if !retry {
+			break
+		}
+	}
+	last := attempts[len(attempts)-1]
+	return attempts, fmt.Errorf("webhook %s: %s after %d attempts", event.ID, last.Error, len(attempts))
+}
+
+// post makes one attempt and reports whether a failure is worth retrying.
+func (n *HTTPWebhookNotifier) post(ctx context.Context, callbackURL, eventID string, body []byte) (WebhookAttempt, bool) {
+	attempt := WebhookAttempt{EventID: eventID, At: time.Now()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "voiceyourtext-webhook/1")
+	req.Header.Set(WebhookIDHeader, eventID)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(n.secret, body, attempt.At))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, ctx.Err() == nil
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return attempt, false
+	}
+	attempt.Error = resp.Status
+	return attempt, resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
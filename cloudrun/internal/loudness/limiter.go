@@ -0,0 +1,255 @@
+package loudness
+
+import "math"
+
+// oversample is the true-peak interpolation factor; 4x keeps the
+// under-read below 0.5 dB for signals up to 20 kHz at 48 kHz (BS.1770 Annex 2).
+const (
+	oversample  = 4
+	tpTaps      = 8     // one-sided interpolation filter length in input samples
+	lookahead   = 0.005 // seconds
+	releaseTime = 0.1   // seconds
+	maxBoostDB  = 20.0
+)
+
+// phases holds the fractional-delay filters for the oversample-1
+// interpolated points between two input samples.
+var phases = func() [oversample - 1][2 * tpTaps]float64 {
+	var p [oversample - 1][2 * tpTaps]float64
+	for ph := range p {
+		frac := float64(ph+1) / oversample
+		for k := range p[ph] {
+			d := frac - float64(k-tpTaps+1)
+			w := 0.5 + 0.5*math.Cos(math.Pi*d/tpTaps) // Hann
+			p[ph][k] = sinc(d) * w
+		}
+	}
+	return p
+}()
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// samplePeaks returns, per sample position, the largest absolute value of
+// any channel at that sample or at the interpolated points up to the next.
+func samplePeaks(chans [][]float64) []float64 {
+	if len(chans) == 0 {
+		return nil
+	}
+	peaks := make([]float64, len(chans[0]))
+	for i := range peaks {
+		peaks[i] = peakAt(chans, i)
+	}
+	return peaks
+}
+
+// peakAt returns the sample peak of position i; samples outside chans
+// count as silence.
+func peakAt(chans [][]float64, i int) float64 {
+	var p float64
+	for _, x := range chans {
+		p = max(p, math.Abs(x[i]))
+		for ph := range phases {
+			var y float64
+			for k, h := range phases[ph] {
+				if j := i + k - tpTaps + 1; j >= 0 && j < len(x) {
+					y += x[j] * h
+				}
+			}
+			p = max(p, math.Abs(y))
+		}
+	}
+	return p
+}
+
+// TruePeak returns the true-peak level of the samples in dBTP.
+func TruePeak(chans [][]float64) float64 {
+	var p float64
+	for _, v := range samplePeaks(chans) {
+		p = max(p, v)
+	}
+	return 20 * math.Log10(p)
+}
+
+// Limit scales the samples in place so their true peak stays at or below
+// ceilingDB dBTP. Gain reduction ramps in over a 5 ms lookahead ahead of
+// each peak and recovers with a 100 ms release, so transients are not
+// clipped and the gain never changes abruptly.
+func Limit(chans [][]float64, sampleRate int, ceilingDB float64) {
+	lm := NewLimiter(sampleRate, ceilingDB)
+	head := lm.Process(chans)
+	tail := lm.Flush()
+	for c, x := range chans {
+		n := 0
+		if head != nil {
+			n = copy(x, head[c])
+		}
+		if tail != nil {
+			copy(x[n:], tail[c])
+		}
+	}
+}
+
+// Limiter is the streaming form of Limit for a signal that arrives in
+// pieces. It holds back the lookahead (about 5 ms) so that peaks in the
+// next piece are seen in time, and carries its gain across pieces, so the
+// output of Process calls followed by Flush is the same as Limit applied
+// to the whole signal.
+type Limiter struct {
+	ceiling float64
+	release float64
+	g       float64
+	ring    []float64 // lookahead minima of the previous L samples
+	ringPos int
+	sum     float64
+
+	x       [][]float64 // input from xBase: peak context, then samples not yet output
+	xBase   int
+	need    []float64 // gain needed at each position from out to needEnd
+	needEnd int
+	out     int // next position to output
+}
+
+// NewLimiter creates a Limiter holding the true peak at or below ceilingDB.
+func NewLimiter(sampleRate int, ceilingDB float64) *Limiter {
+	return &Limiter{
+		ceiling: math.Pow(10, ceilingDB/20),
+		release: 1 - math.Exp(-1/(releaseTime*float64(sampleRate))),
+		g:       1,
+		ring:    make([]float64, max(int(lookahead*float64(sampleRate)), 1)),
+	}
+}
+
+// Process adds the next piece of the signal and returns the limited
+// samples that are ready, which may be fewer than were added.
+func (lm *Limiter) Process(chans [][]float64) [][]float64 {
+	if len(chans) == 0 {
+		return nil
+	}
+	if lm.x == nil {
+		lm.x = make([][]float64, len(chans))
+	}
+	for c := range lm.x {
+		lm.x[c] = append(lm.x[c], chans[c]...)
+	}
+	return lm.advance(false)
+}
+
+// Flush ends the signal and returns the remaining limited samples.
+func (lm *Limiter) Flush() [][]float64 {
+	if lm.x == nil {
+		return nil
+	}
+	return lm.advance(true)
+}
+
+func (lm *Limiter) advance(final bool) [][]float64 {
+	l := len(lm.ring)
+	end := lm.xBase + len(lm.x[0])
+
+	// A position's peak is final once the interpolation filter has all
+	// its input; at the end of the signal the rest is silence.
+	peakEnd := end
+	if !final {
+		peakEnd = end - tpTaps
+	}
+	for ; lm.needEnd < peakEnd; lm.needEnd++ {
+		need := 1.0
+		if p := peakAt(lm.x, lm.needEnd-lm.xBase); p > lm.ceiling {
+			need = lm.ceiling / p
+		}
+		lm.need = append(lm.need, need)
+	}
+
+	// A position can be output once the next L needs are known.
+	outEnd := lm.needEnd
+	if !final {
+		outEnd = lm.needEnd - l + 1
+	}
+	n := outEnd - lm.out
+	if n <= 0 {
+		return nil
+	}
+
+	// Sliding minimum over the next L samples (monotonic deque), then a
+	// moving average over the previous L: the result reaches need[i] by
+	// sample i while ramping smoothly into it.
+	ahead := make([]float64, n)
+	deque := make([]int, 0, l)
+	for i := len(lm.need) - 1; i >= 0; i-- {
+		for len(deque) > 0 && lm.need[deque[len(deque)-1]] >= lm.need[i] {
+			deque = deque[:len(deque)-1]
+		}
+		deque = append(deque, i)
+		if deque[0] >= i+l {
+			deque = deque[1:]
+		}
+		if i < n {
+			ahead[i] = lm.need[deque[0]]
+		}
+	}
+	if lm.out == 0 {
+		// Positions before the start repeat ahead[0], which already
+		// covers any peak within the first L samples.
+		for i := range lm.ring {
+			lm.ring[i] = ahead[0]
+		}
+		lm.sum = ahead[0] * float64(l)
+	}
+
+	out := make([][]float64, len(lm.x))
+	for c := range out {
+		out[c] = make([]float64, n)
+	}
+	from := lm.out - lm.xBase
+	for i := range n {
+		lm.sum += ahead[i] - lm.ring[lm.ringPos]
+		lm.ring[lm.ringPos] = ahead[i]
+		lm.ringPos = (lm.ringPos + 1) % l
+		lm.g = min(lm.sum/float64(l), lm.g+(1-lm.g)*lm.release)
+		for c, x := range lm.x {
+			out[c][i] = x[from+i] * lm.g
+		}
+	}
+	lm.out = outEnd
+	lm.need = lm.need[n:]
+
+	// Keep the samples not yet output plus the context the interpolation
+	// filter needs for the next peaks.
+	keep := max(min(lm.out, lm.needEnd-tpTaps+1), lm.xBase)
+	for c, x := range lm.x {
+		lm.x[c] = append(x[:0], x[keep-lm.xBase:]...)
+	}
+	lm.xBase = keep
+	return out
+}
+
+// Normalize applies the gain that moves integratedLUFS to targetLUFS (at
+// most +20 dB, none for silence) and then limits the true peak to
+// ceilingDB. It returns the applied gain in dB.
+func Normalize(chans [][]float64, sampleRate int, integratedLUFS, targetLUFS, ceilingDB float64) float64 {
+	gainDB := Gain(integratedLUFS, targetLUFS)
+	if gainDB != 0 {
+		g := math.Pow(10, gainDB/20)
+		for _, x := range chans {
+			for i := range x {
+				x[i] *= g
+			}
+		}
+	}
+	Limit(chans, sampleRate, ceilingDB)
+	return gainDB
+}
+
+// Gain returns the gain in dB that moves integratedLUFS to targetLUFS,
+// capped at +20 dB; unmeasurable (silent) input gets no gain.
+func Gain(integratedLUFS, targetLUFS float64) float64 {
+	if math.IsInf(integratedLUFS, -1) || math.IsNaN(integratedLUFS) {
+		return 0
+	}
+	return min(targetLUFS-integratedLUFS, maxBoostDB)
+}
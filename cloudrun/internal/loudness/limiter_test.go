@@ -0,0 +1,104 @@
+package loudness_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/loudness"
+)
+
+func TestTruePeak_InterSamplePeak(t *testing.T) {
+	// A sine at fs/4 sampled at 45° has samples at ±0.707 of its true peak.
+	const rate = 48000
+	x := make([]float64, 4800)
+	for i := range x {
+		x[i] = math.Sin(2*math.Pi*float64(i)/4 + math.Pi/4)
+	}
+	if got := loudness.TruePeak([][]float64{x}); math.Abs(got) > 0.2 {
+		t.Errorf("true peak = %.2f dBTP, want ~0 (sample peak is -3 dB)", got)
+	}
+}
+
+func TestLimit_HoldsCeiling(t *testing.T) {
+	const rate = 24000
+	x := tone(440, -20, rate, 2)
+	// A loud burst in the middle.
+	for i := rate / 2; i < rate; i++ {
+		x[i] *= 30
+	}
+	y := append([]float64(nil), x...)
+	loudness.Limit([][]float64{y}, rate, -1)
+
+	if got := loudness.TruePeak([][]float64{y}); got > -0.9 {
+		t.Errorf("true peak after limiting = %.2f dBTP, want <= -1", got)
+	}
+	// Before the burst the signal is untouched; well after it, fully released.
+	if y[rate/4] != x[rate/4] {
+		t.Errorf("sample before the burst changed from %f to %f", x[rate/4], y[rate/4])
+	}
+	if i := rate * 7 / 4; math.Abs(y[i]-x[i]) > math.Abs(x[i])*0.01 {
+		t.Errorf("sample %d changed from %f to %f", i, x[i], y[i])
+	}
+}
+
+func TestLimiter_Pieces(t *testing.T) {
+	const rate = 24000
+	x := tone(440, -20, rate, 2)
+	for i := rate / 2; i < rate; i++ {
+		x[i] *= 30
+	}
+	whole := append([]float64(nil), x...)
+	loudness.Limit([][]float64{whole}, rate, -1)
+
+	// Split inside the burst, just after it while the gain is releasing,
+	// and into pieces shorter than the lookahead.
+	var got []float64
+	lm := loudness.NewLimiter(rate, -1)
+	for _, p := range [][2]int{{0, rate * 3 / 4}, {rate * 3 / 4, rate + 10}, {rate + 10, rate + 13}, {rate + 13, rate + 50}, {rate + 50, len(x)}} {
+		if out := lm.Process([][]float64{x[p[0]:p[1]]}); out != nil {
+			got = append(got, out[0]...)
+		}
+	}
+	got = append(got, lm.Flush()[0]...)
+
+	if len(got) != len(whole) {
+		t.Fatalf("output %d samples, want %d", len(got), len(whole))
+	}
+	for i := range got {
+		if math.Abs(got[i]-whole[i]) > 1e-12 {
+			t.Fatalf("sample %d = %f, want %f as when limited whole", i, got[i], whole[i])
+		}
+	}
+}
+
+func TestLimit_PeakAtStart(t *testing.T) {
+	x := []float64{2, 0, 0, 0, 0, 0, 0, 0}
+	loudness.Limit([][]float64{x}, 48000, 0)
+	if x[0] > 1 {
+		t.Errorf("first sample = %f, want <= 1", x[0])
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	const rate = 24000
+	x := tone(1000, -30, rate, 10)
+	m := loudness.NewMeter(rate, 1)
+	m.Write([][]float64{x})
+
+	gain := loudness.Normalize([][]float64{x}, rate, m.Integrated(), -16, -1)
+	if math.Abs(gain-(-16-m.Integrated())) > 1e-9 {
+		t.Errorf("gain = %f", gain)
+	}
+	after := loudness.NewMeter(rate, 1)
+	after.Write([][]float64{x})
+	if got := after.Integrated(); math.Abs(got+16) > 0.1 {
+		t.Errorf("normalized loudness = %.2f LUFS, want -16", got)
+	}
+	if tp := loudness.TruePeak([][]float64{x}); tp > -0.9 {
+		t.Errorf("true peak = %.2f dBTP, want <= -1", tp)
+	}
+
+	if g := loudness.Gain(-80, -16); g != 20 {
+		t.Errorf("boost for very quiet input = %f, want capped at 20", g)
+	}
+}
@@ -0,0 +1,177 @@
+// Package loudness measures programme loudness as specified by ITU-R
+// BS.1770-4 / EBU R128 and applies gain with a true-peak limiter.
+package loudness
+
+import "math"
+
+// absoluteGate and relativeGate are the BS.1770 gating thresholds.
+const (
+	absoluteGate = -70.0 // LUFS
+	relativeGate = -10.0 // LU below the absolutely-gated loudness
+)
+
+// Gating blocks are kept in a histogram of binWidth-LU bins from the
+// absolute gate up to maxBlockLUFS, so Integrated costs O(bins) however
+// long the stream. Each bin keeps the exact energy sum of its blocks; only
+// the relative gate is resolved to a bin, which moves the result by far
+// less than binWidth.
+const (
+	binWidth     = 0.01 // LU
+	maxBlockLUFS = 10.0 // louder blocks go in the top bin
+	histBins     = int((maxBlockLUFS - absoluteGate) / binWidth)
+)
+
+// biquad is a second-order IIR section in transposed direct form II.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeighting returns the two BS.1770 K-weighting stages (high shelf, then
+// high pass) for sampleRate, derived from the analog prototypes so rates
+// other than 48 kHz are handled exactly.
+func kWeighting(sampleRate int) [2]biquad {
+	fs := float64(sampleRate)
+
+	// Stage 1: high shelf modelling the acoustic effect of the head.
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / fs)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	// Stage 2: RLB high pass.
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / fs)
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1, b1: -2, b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return [2]biquad{shelf, highPass}
+}
+
+// Meter accumulates integrated loudness over a stream of samples. Feed it
+// with Write in order; Integrated may be called at any point.
+type Meter struct {
+	filters [][2]biquad
+	weights []float64
+	hop     int // samples per 100 ms step; blocks are 4 hops (400 ms, 75% overlap)
+
+	hopSum   []float64    // per-channel sum of squares of the current hop
+	hopFill  int          // samples in the current hop
+	lastHops [][4]float64 // per-channel mean squares of the last four hops
+	hops     int          // completed hops
+
+	// Channel-weighted mean squares of the gating blocks above the
+	// absolute gate, binned by loudness: per-bin sums and counts.
+	binSum   []float64
+	binCount []int
+	sum      float64 // total over all bins
+	count    int
+}
+
+// NewMeter returns a meter for audio at sampleRate with channels channels.
+// Channels 4 and 5 are weighted as surrounds (+1.5 dB) per BS.1770; all
+// others count at unity.
+func NewMeter(sampleRate, channels int) *Meter {
+	m := &Meter{
+		filters:  make([][2]biquad, channels),
+		weights:  make([]float64, channels),
+		hop:      max(sampleRate/10, 1),
+		hopSum:   make([]float64, channels),
+		lastHops: make([][4]float64, channels),
+		binSum:   make([]float64, histBins),
+		binCount: make([]int, histBins),
+	}
+	for c := range m.filters {
+		m.filters[c] = kWeighting(sampleRate)
+		m.weights[c] = 1
+		if c == 3 || c == 4 {
+			m.weights[c] = 1.41
+		}
+	}
+	return m
+}
+
+// Write adds per-channel samples (as returned by wav.Decode) to the
+// measurement. All channels must have the same length.
+func (m *Meter) Write(chans [][]float64) {
+	if len(chans) == 0 {
+		return
+	}
+	for i := range chans[0] {
+		for c := range m.filters {
+			f := &m.filters[c]
+			y := f[1].process(f[0].process(chans[c][i]))
+			m.hopSum[c] += y * y
+		}
+		m.hopFill++
+		if m.hopFill == m.hop {
+			m.endHop()
+		}
+	}
+}
+
+func (m *Meter) endHop() {
+	for c := range m.hopSum {
+		m.lastHops[c][m.hops%4] = m.hopSum[c] / float64(m.hop)
+		m.hopSum[c] = 0
+	}
+	m.hopFill = 0
+	m.hops++
+	if m.hops < 4 {
+		return
+	}
+	var z float64
+	for c, h := range m.lastHops {
+		z += m.weights[c] * (h[0] + h[1] + h[2] + h[3]) / 4
+	}
+	l := lufs(z)
+	if !(l > absoluteGate) {
+		return
+	}
+	b := min(int((l-absoluteGate)/binWidth), histBins-1)
+	m.binSum[b] += z
+	m.binCount[b]++
+	m.sum += z
+	m.count++
+}
+
+// Integrated returns the gated integrated loudness in LUFS so far, or
+// -Inf when nothing above the absolute gate has been measured.
+func (m *Meter) Integrated() float64 {
+	if m.count == 0 {
+		return math.Inf(-1)
+	}
+	threshold := max(lufs(m.sum/float64(m.count))+relativeGate, absoluteGate)
+	var sum float64
+	var n int
+	for b := int((threshold - absoluteGate) / binWidth); b < histBins; b++ {
+		sum += m.binSum[b]
+		n += m.binCount[b]
+	}
+	if n == 0 {
+		return math.Inf(-1)
+	}
+	return lufs(sum / float64(n))
+}
+
+func lufs(meanSquare float64) float64 {
+	return -0.691 + 10*math.Log10(meanSquare)
+}
@@ -0,0 +1,64 @@
+package loudness_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/loudness"
+)
+
+// tone returns seconds of a sine at freq Hz and dBFS amplitude.
+func tone(freq, dbfs float64, rate int, seconds float64) []float64 {
+	a := math.Pow(10, dbfs/20)
+	out := make([]float64, int(seconds*float64(rate)))
+	for i := range out {
+		out[i] = a * math.Sin(2*math.Pi*freq*float64(i)/float64(rate))
+	}
+	return out
+}
+
+// EBU Tech 3341 case 1/2: a stereo 1 kHz sine at -23 / -33 dBFS reads
+// -23 / -33 LUFS within 0.1 LU, at any sample rate.
+func TestMeter_EBU3341Sine(t *testing.T) {
+	for _, rate := range []int{16000, 24000, 44100, 48000} {
+		for _, level := range []float64{-23, -33} {
+			x := tone(1000, level, rate, 20)
+			m := loudness.NewMeter(rate, 2)
+			m.Write([][]float64{x, x})
+			if got := m.Integrated(); math.Abs(got-level) > 0.1 {
+				t.Errorf("%d Hz, %v dBFS: integrated = %.2f LUFS, want %.0f", rate, level, got, level)
+			}
+		}
+	}
+}
+
+// EBU Tech 3341 case 3: -36/-23/-36 dBFS segments of 10/60/10 s read -23 LUFS
+// because the quiet parts fall below the relative gate.
+func TestMeter_RelativeGate(t *testing.T) {
+	const rate = 48000
+	var x []float64
+	x = append(x, tone(1000, -36, rate, 10)...)
+	x = append(x, tone(1000, -23, rate, 60)...)
+	x = append(x, tone(1000, -36, rate, 10)...)
+	m := loudness.NewMeter(rate, 2)
+	// Feed in uneven pieces to exercise the hop bookkeeping across writes.
+	for len(x) > 0 {
+		n := min(len(x), 12345)
+		m.Write([][]float64{x[:n], x[:n]})
+		x = x[n:]
+	}
+	if got := m.Integrated(); math.Abs(got+23) > 0.1 {
+		t.Errorf("integrated = %.2f LUFS, want -23", got)
+	}
+}
+
+func TestMeter_SilenceIsUnmeasurable(t *testing.T) {
+	m := loudness.NewMeter(24000, 1)
+	m.Write([][]float64{make([]float64, 48000)})
+	if got := m.Integrated(); !math.IsInf(got, -1) {
+		t.Errorf("integrated = %f, want -Inf", got)
+	}
+	if g := loudness.Gain(m.Integrated(), -16); g != 0 {
+		t.Errorf("gain for silence = %f, want 0", g)
+	}
+}
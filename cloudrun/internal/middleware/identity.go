@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"firebase.google.com/go/v4/auth"
+)
+
+// IDTokenHeader carries the Firebase ID token of the signed-in user. It is
+// separate from Authorization, which holds the shared API key.
+const IDTokenHeader = "X-Firebase-Token"
+
+// IDTokenVerifier verifies a Firebase ID token and returns the UID of the
+// user it was issued to.
+type IDTokenVerifier interface {
+	VerifyIDToken(ctx context.Context, idToken string) (uid string, err error)
+}
+
+// FirebaseVerifier adapts a Firebase Auth client to IDTokenVerifier.
+type FirebaseVerifier struct {
+	Client *auth.Client
+}
+
+func (v FirebaseVerifier) VerifyIDToken(ctx context.Context, idToken string) (string, error) {
+	token, err := v.Client.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return "", err
+	}
+	return token.UID, nil
+}
+
+type userKey struct{}
+
+// UserAuth is a middleware that verifies the ID token in IDTokenHeader and
+// records the caller's UID for RequestUser. Requests without a token pass
+// through anonymously; handlers that need a user check RequestUser. An
+// invalid token is rejected with 401.
+func UserAuth(verifier IDTokenVerifier, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idToken := r.Header.Get(IDTokenHeader)
+		if idToken == "" {
+			next(w, r)
+			return
+		}
+		uid, err := verifier.VerifyIDToken(r.Context(), idToken)
+		if err != nil || uid == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "Unauthorized", "message": "Invalid or expired ID token"}`))
+			return
+		}
+		next(w, WithUser(r, uid))
+	}
+}
+
+// WithUser returns a shallow copy of r whose caller is the user uid.
+func WithUser(r *http.Request, uid string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userKey{}, uid))
+}
+
+// RequestUser returns the UID of the signed-in caller of r, or "" for an
+// anonymous request.
+func RequestUser(r *http.Request) string {
+	uid, _ := r.Context().Value(userKey{}).(string)
+	return uid
+}
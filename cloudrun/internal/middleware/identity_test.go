@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type stubVerifier map[string]string
+
+func (v stubVerifier) VerifyIDToken(_ context.Context, idToken string) (string, error) {
+	if uid, ok := v[idToken]; ok {
+		return uid, nil
+	}
+	return "", errors.New("invalid token")
+}
+
+func TestUserAuth(t *testing.T) {
+	var got string
+	handler := UserAuth(stubVerifier{"good-token": "user-1"}, func(w http.ResponseWriter, r *http.Request) {
+		got = RequestUser(r)
+	})
+
+	tests := []struct {
+		name     string
+		idToken  string
+		wantCode int
+		wantUser string
+	}{
+		{"anonymous", "", http.StatusOK, ""},
+		{"valid token", "good-token", http.StatusOK, "user-1"},
+		{"invalid token", "forged", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = ""
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.idToken != "" {
+				req.Header.Set(IDTokenHeader, tt.idToken)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+			if w.Code != tt.wantCode || got != tt.wantUser {
+				t.Errorf("status = %d, user = %q; want %d, %q", w.Code, got, tt.wantCode, tt.wantUser)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// RequestAPIKey returns the API key presented with r, from the Bearer
+// token or the X-API-Key header, or "" if there is none.
+func RequestAPIKey(r *http.Request) string {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
+		return parts[1]
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// ConcurrencyLimit is a middleware that allows at most max requests per
+// signed-in user (see UserAuth) in flight at once and rejects the rest with
+// 429. The API key is shared by every installation of the app, so it does
+// not tell clients apart; anonymous requests are rejected with 401.
+// It is meant for long-lived requests such as WebSocket sessions, which
+// hold their slot until the connection closes. The count is kept per
+// instance, so a user's total across a scaled-out service may be higher.
+func ConcurrencyLimit(max int, next http.HandlerFunc) http.HandlerFunc {
+	var mu sync.Mutex
+	active := map[string]int{}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := RequestUser(r)
+		if key == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "Unauthorized", "message": "Sign-in required"}`))
+			return
+		}
+		mu.Lock()
+		if active[key] >= max {
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error": "Too many sessions", "message": "Concurrent session limit reached for this user"}`))
+			return
+		}
+		active[key]++
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			if active[key]--; active[key] == 0 {
+				delete(active, key)
+			}
+			mu.Unlock()
+		}()
+		next(w, r)
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := ConcurrencyLimit(2, func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	request := func(uid string) int {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-API-Key", "shared-key")
+		if uid != "" {
+			req = WithUser(req, uid)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	if code := request(""); code != http.StatusUnauthorized {
+		t.Errorf("anonymous request = %d, want 401", code)
+	}
+
+	// Hold two sessions open for user-a.
+	done := make(chan int, 2)
+	for range 2 {
+		go func() { done <- request("user-a") }()
+		<-entered
+	}
+	if code := request("user-a"); code != http.StatusTooManyRequests {
+		t.Errorf("third user-a request = %d, want 429", code)
+	}
+	go func() { done <- request("user-b") }()
+	<-entered // another user with the same API key is not limited
+
+	close(release)
+	for range 3 {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("held request = %d, want 200", code)
+		}
+	}
+
+	// Slots are released when requests finish.
+	go func() { <-entered }()
+	if code := request("user-a"); code != http.StatusOK {
+		t.Errorf("after release = %d, want 200", code)
+	}
+}
+
+func TestRequestAPIKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+	req.Header.Set("X-API-Key", "def")
+	if got := RequestAPIKey(req); got != "abc" {
+		t.Errorf("RequestAPIKey = %q, want the bearer token", got)
+	}
+	req.Header.Del("Authorization")
+	if got := RequestAPIKey(req); got != "def" {
+		t.Errorf("RequestAPIKey = %q, want the X-API-Key header", got)
+	}
+}
@@ -0,0 +1,42 @@
+package mp3
+
+// bitWriter packs MSB-first bit fields into a byte slice.
+type bitWriter struct {
+	buf   []byte
+	cur   uint64
+	nbits uint
+}
+
+// write appends the low n bits of v (n <= 32).
+func (b *bitWriter) write(v uint32, n int) {
+	if n == 0 {
+		return
+	}
+	b.cur = b.cur<<uint(n) | uint64(v)&(1<<uint(n)-1)
+	b.nbits += uint(n)
+	for b.nbits >= 8 {
+		b.nbits -= 8
+		b.buf = append(b.buf, byte(b.cur>>b.nbits))
+	}
+}
+
+// bitLen returns the number of bits written so far.
+func (b *bitWriter) bitLen() int {
+	return len(b.buf)*8 + int(b.nbits)
+}
+
+// padTo zero-fills up to the given total number of bytes.
+func (b *bitWriter) padTo(nbytes int) {
+	if b.nbits > 0 {
+		b.write(0, int(8-b.nbits))
+	}
+	for len(b.buf) < nbytes {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *bitWriter) reset() {
+	b.buf = b.buf[:0]
+	b.cur = 0
+	b.nbits = 0
+}
@@ -0,0 +1,328 @@
+// Package mp3 implements a constant-bitrate MPEG Layer III encoder in pure Go.
+//
+// The encoder targets synthesized speech: it uses long blocks only, no
+// psychoacoustic model and no bit reservoir, and spends each granule's bits
+// through the global gain alone (scalefactors are always zero). That keeps it
+// small while producing files any MP3 decoder can play.
+package mp3
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	granuleSize = 576
+	maxQuant    = 15 + (1<<13 - 1) // largest magnitude codable with 13 linbits
+)
+
+// EncoderDelay is the number of samples the decoded output lags the input by
+// (filterbank plus one MDCT granule). It is constant for a whole stream, so
+// timepoints computed on the PCM only shift by this fixed amount.
+const EncoderDelay = granuleSize + 481
+
+// DefaultBitrate is a good size/quality trade-off for mono speech (kbps).
+const DefaultBitrate = 64
+
+// SupportedBitrates lists the bitrates (kbps) valid for both MPEG-1 (32-48 kHz)
+// and MPEG-2 LSF (16-24 kHz), so each works for every TTS sample rate.
+var SupportedBitrates = []int{32, 40, 48, 56, 64, 80, 96, 112, 128, 160}
+
+// IsSupportedBitrate reports whether kbps is one of SupportedBitrates.
+func IsSupportedBitrate(kbps int) bool {
+	for _, b := range SupportedBitrates {
+		if b == kbps {
+			return true
+		}
+	}
+	return false
+}
+
+// FrameSamples returns the samples per channel in one frame at sampleRate:
+// 1152 for MPEG-1 rates and 576 for MPEG-2 LSF.
+func FrameSamples(sampleRate int) int {
+	if sampleRate >= 32000 {
+		return 2 * granuleSize
+	}
+	return granuleSize
+}
+
+// Encoder encodes interleaved 16-bit little-endian PCM written to it into
+// MP3 frames on the underlying writer. Close must be called to flush the
+// final frames. Each frame is passed to the writer in a single Write, and
+// since there is no bit reservoir every frame decodes on its own, so the
+// stream can be cut at any Write boundary.
+type Encoder struct {
+	w            io.Writer
+	channels     int
+	lsf          int // 0 = MPEG-1, 1 = MPEG-2 LSF
+	srIndex      int
+	brIndex      int
+	sampleRate   int
+	granules     int
+	sideInfoLen  int
+	frameSamples int
+	slotNum      int // frame size numerator; bytes = slotNum / sampleRate (+ padding)
+	padRem       int
+
+	pcm     [2][]float64 // pending samples per channel, scaled to [-1, 1)
+	partial []byte       // bytes of an incomplete sample frame
+	state   [2]channelState
+	fed     int64 // samples per channel consumed so far
+	frame   bitWriter
+	main    bitWriter
+	err     error
+	closed  bool
+}
+
+type channelState struct {
+	x       [512]float64    // analysis FIFO, x[0] is the newest sample
+	prevSub [32][18]float64 // previous granule's subband samples
+	sub     [32][18]float64 // current granule's subband samples
+	xr      [granuleSize]float64
+	ix      [granuleSize]int
+}
+
+type granuleInfo struct {
+	part23Length int
+	bigValues    int
+	globalGain   int
+	tableSelect  [3]int
+	region0Count int
+	region1Count int
+	count1Table  int // 0 = table A (32), 1 = table B (33)
+	count1       int // number of quadruples in the count1 region
+	region1Start int
+	region2Start int
+}
+
+// NewEncoder returns an Encoder writing to w. sampleRate must be one of the
+// MPEG-1 or MPEG-2 LSF rates (16000-48000 Hz), channels 1 or 2 and bitrate
+// a Layer III bitrate (kbps) valid for that rate.
+func NewEncoder(w io.Writer, sampleRate, channels, bitrate int) (*Encoder, error) {
+	if channels != 1 && channels != 2 {
+		return nil, fmt.Errorf("mp3: unsupported channel count %d", channels)
+	}
+	e := &Encoder{w: w, channels: channels, sampleRate: sampleRate, srIndex: -1, brIndex: -1}
+	for lsf := range sampleRates {
+		for i, sr := range sampleRates[lsf] {
+			if sr == sampleRate {
+				e.lsf, e.srIndex = lsf, i
+			}
+		}
+	}
+	if e.srIndex < 0 {
+		return nil, fmt.Errorf("mp3: unsupported sample rate %d", sampleRate)
+	}
+	for i, br := range bitrates[e.lsf] {
+		if i > 0 && br == bitrate {
+			e.brIndex = i
+		}
+	}
+	if e.brIndex < 0 {
+		return nil, fmt.Errorf("mp3: unsupported bitrate %d kbps at %d Hz", bitrate, sampleRate)
+	}
+
+	if e.lsf == 0 {
+		e.granules = 2
+		e.slotNum = 144000 * bitrate
+		e.sideInfoLen = 17
+		if channels == 2 {
+			e.sideInfoLen = 32
+		}
+	} else {
+		e.granules = 1
+		e.slotNum = 72000 * bitrate
+		e.sideInfoLen = 9
+		if channels == 2 {
+			e.sideInfoLen = 17
+		}
+	}
+	e.frameSamples = e.granules * granuleSize
+	return e, nil
+}
+
+// Write consumes interleaved 16-bit little-endian PCM and emits every
+// complete frame.
+func (e *Encoder) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	if e.closed {
+		return 0, errors.New("mp3: write after close")
+	}
+	n := len(p)
+	frameBytes := 2 * e.channels
+	if len(e.partial) > 0 {
+		need := frameBytes - len(e.partial)
+		if len(p) < need {
+			e.partial = append(e.partial, p...)
+			return n, nil
+		}
+		e.partial = append(e.partial, p[:need]...)
+		e.appendSamples(e.partial)
+		e.partial = e.partial[:0]
+		p = p[need:]
+	}
+	whole := len(p) - len(p)%frameBytes
+	e.appendSamples(p[:whole])
+	e.partial = append(e.partial, p[whole:]...)
+
+	if err := e.encodeFrames(false); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// Close pads the stream with silence so every written sample (including
+// those held back by EncoderDelay) is decodable, then writes the last frames.
+func (e *Encoder) Close() error {
+	if e.closed {
+		return e.err
+	}
+	e.closed = true
+	if e.err != nil {
+		return e.err
+	}
+	total := e.fed + int64(len(e.pcm[0])) + EncoderDelay
+	for ch := 0; ch < e.channels; ch++ {
+		pad := int(total - e.fed - int64(len(e.pcm[ch])))
+		e.pcm[ch] = append(e.pcm[ch], make([]float64, pad)...)
+	}
+	return e.encodeFrames(true)
+}
+
+func (e *Encoder) appendSamples(p []byte) {
+	for i := 0; i+1 < len(p); i += 2 {
+		ch := (i / 2) % e.channels
+		s := int16(uint16(p[i]) | uint16(p[i+1])<<8)
+		e.pcm[ch] = append(e.pcm[ch], float64(s)/32768)
+	}
+}
+
+func (e *Encoder) encodeFrames(flush bool) error {
+	for len(e.pcm[0]) >= e.frameSamples || (flush && len(e.pcm[0]) > 0) {
+		if len(e.pcm[0]) < e.frameSamples {
+			for ch := 0; ch < e.channels; ch++ {
+				e.pcm[ch] = append(e.pcm[ch], make([]float64, e.frameSamples-len(e.pcm[ch]))...)
+			}
+		}
+		if err := e.encodeFrame(); err != nil {
+			e.err = err
+			return err
+		}
+		for ch := 0; ch < e.channels; ch++ {
+			e.pcm[ch] = e.pcm[ch][:copy(e.pcm[ch], e.pcm[ch][e.frameSamples:])]
+		}
+		e.fed += int64(e.frameSamples)
+	}
+	return nil
+}
+
+func (e *Encoder) encodeFrame() error {
+	padding := 0
+	e.padRem += e.slotNum % e.sampleRate
+	if e.padRem >= e.sampleRate {
+		e.padRem -= e.sampleRate
+		padding = 1
+	}
+	frameLen := e.slotNum/e.sampleRate + padding
+	mainBits := (frameLen - 4 - e.sideInfoLen) * 8
+	granuleBits := mainBits / (e.granules * e.channels)
+
+	var info [2][2]granuleInfo
+	e.main.reset()
+	for gr := 0; gr < e.granules; gr++ {
+		for ch := 0; ch < e.channels; ch++ {
+			st := &e.state[ch]
+			e.analyzeGranule(st, e.pcm[ch][gr*granuleSize:(gr+1)*granuleSize])
+			gi := &info[gr][ch]
+			e.quantize(st, gi, granuleBits)
+			start := e.main.bitLen()
+			e.writeHuffman(st, gi)
+			if got := e.main.bitLen() - start; got != gi.part23Length {
+				return fmt.Errorf("mp3: internal bit count mismatch (%d != %d)", got, gi.part23Length)
+			}
+		}
+	}
+
+	e.frame.reset()
+	e.writeHeader(padding)
+	e.writeSideInfo(&info)
+	e.frame.buf = append(e.frame.buf, e.main.buf...)
+	e.frame.cur, e.frame.nbits = e.main.cur, e.main.nbits
+	e.frame.padTo(frameLen)
+	if len(e.frame.buf) != frameLen {
+		return fmt.Errorf("mp3: frame overflow (%d > %d bytes)", len(e.frame.buf), frameLen)
+	}
+	_, err := e.w.Write(e.frame.buf)
+	return err
+}
+
+func (e *Encoder) writeHeader(padding int) {
+	f := &e.frame
+	f.write(0x7ff, 11) // sync
+	if e.lsf == 0 {
+		f.write(3, 2) // MPEG-1
+	} else {
+		f.write(2, 2) // MPEG-2
+	}
+	f.write(1, 2) // Layer III
+	f.write(1, 1) // no CRC
+	f.write(uint32(e.brIndex), 4)
+	f.write(uint32(e.srIndex), 2)
+	f.write(uint32(padding), 1)
+	f.write(0, 1) // private
+	if e.channels == 1 {
+		f.write(3, 2) // single channel
+	} else {
+		f.write(0, 2) // stereo
+	}
+	f.write(0, 2) // mode extension
+	f.write(0, 1) // copyright
+	f.write(1, 1) // original
+	f.write(0, 2) // emphasis
+}
+
+func (e *Encoder) writeSideInfo(info *[2][2]granuleInfo) {
+	f := &e.frame
+	if e.lsf == 0 {
+		f.write(0, 9) // main_data_begin: no bit reservoir
+		if e.channels == 1 {
+			f.write(0, 5)
+		} else {
+			f.write(0, 3)
+		}
+		for ch := 0; ch < e.channels; ch++ {
+			f.write(0, 4) // scfsi
+		}
+	} else {
+		f.write(0, 8)
+		f.write(0, e.channels) // private bits
+	}
+	for gr := 0; gr < e.granules; gr++ {
+		for ch := 0; ch < e.channels; ch++ {
+			gi := &info[gr][ch]
+			f.write(uint32(gi.part23Length), 12)
+			f.write(uint32(gi.bigValues), 9)
+			f.write(uint32(gi.globalGain), 8)
+			if e.lsf == 0 {
+				f.write(0, 4) // scalefac_compress
+			} else {
+				f.write(0, 9)
+			}
+			f.write(0, 1) // window_switching_flag: long blocks only
+			for i := 0; i < 3; i++ {
+				f.write(uint32(gi.tableSelect[i]), 5)
+			}
+			f.write(uint32(gi.region0Count), 4)
+			f.write(uint32(gi.region1Count), 3)
+			if e.lsf == 0 {
+				f.write(0, 1) // preflag
+			}
+			f.write(0, 1) // scalefac_scale
+			f.write(uint32(gi.count1Table), 1)
+		}
+	}
+}
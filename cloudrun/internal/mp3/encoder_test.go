@@ -0,0 +1,216 @@
+package mp3_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	gomp3 "github.com/hajimehoshi/go-mp3"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/mp3"
+)
+
+// tone returns n samples of a two-partial test tone in [-0.5, 0.5].
+func tone(sampleRate, n int) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		t := float64(i) / float64(sampleRate)
+		s[i] = 0.3*math.Sin(2*math.Pi*440*t) + 0.2*math.Sin(2*math.Pi*1234*t)
+	}
+	return s
+}
+
+// toPCM interleaves the given channels as 16-bit little-endian PCM.
+func toPCM(channels ...[]float64) []byte {
+	n := len(channels[0])
+	pcm := make([]byte, 0, n*2*len(channels))
+	for i := 0; i < n; i++ {
+		for _, ch := range channels {
+			pcm = binary.LittleEndian.AppendUint16(pcm, uint16(int16(ch[i]*32767)))
+		}
+	}
+	return pcm
+}
+
+// encode writes pcm in uneven pieces to exercise partial-sample buffering.
+func encode(t *testing.T, pcm []byte, sampleRate, channels, bitrate int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc, err := mp3.NewEncoder(&buf, sampleRate, channels, bitrate)
+	if err != nil {
+		t.Fatalf("NewEncoder: %v", err)
+	}
+	for len(pcm) > 0 {
+		n := min(len(pcm), 4097)
+		if _, err := enc.Write(pcm[:n]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		pcm = pcm[n:]
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// decode returns the left and right channels of the decoded stream.
+func decode(t *testing.T, data []byte) (int, [2][]float64) {
+	t.Helper()
+	d, err := gomp3.NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewDecoder: %v", err)
+	}
+	out, err := io.ReadAll(d)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	var chans [2][]float64
+	for i := 0; i+3 < len(out); i += 4 {
+		chans[0] = append(chans[0], float64(int16(binary.LittleEndian.Uint16(out[i:])))/32767)
+		chans[1] = append(chans[1], float64(int16(binary.LittleEndian.Uint16(out[i+2:])))/32767)
+	}
+	return d.SampleRate(), chans
+}
+
+// snr compares src with dec shifted by EncoderDelay, skipping the edges.
+func snr(src, dec []float64) float64 {
+	var sig, noise float64
+	for i := 1000; i < len(src)-1000; i++ {
+		e := src[i] - dec[i+mp3.EncoderDelay]
+		sig += src[i] * src[i]
+		noise += e * e
+	}
+	return 10 * math.Log10(sig/noise)
+}
+
+func TestEncoder_RoundTripMono(t *testing.T) {
+	for _, sr := range []int{16000, 22050, 24000, 32000, 44100, 48000} {
+		src := tone(sr, sr)
+		data := encode(t, toPCM(src), sr, 1, mp3.DefaultBitrate)
+
+		gotRate, dec := decode(t, data)
+		if gotRate != sr {
+			t.Errorf("%d Hz: decoded sample rate %d", sr, gotRate)
+		}
+		if len(dec[0]) < len(src)+mp3.EncoderDelay {
+			t.Fatalf("%d Hz: decoded %d samples, want at least %d", sr, len(dec[0]), len(src)+mp3.EncoderDelay)
+		}
+		if got := snr(src, dec[0]); got < 30 {
+			t.Errorf("%d Hz: SNR %.1f dB, want >= 30", sr, got)
+		}
+
+		// CBR: the stream size follows the bitrate to within one frame.
+		want := float64(len(dec[0])) / float64(sr) * mp3.DefaultBitrate * 1000 / 8
+		if math.Abs(float64(len(data))-want) > 500 {
+			t.Errorf("%d Hz: %d bytes, want ~%.0f", sr, len(data), want)
+		}
+	}
+}
+
+func TestEncoder_RoundTripStereo(t *testing.T) {
+	const sr = 44100
+	left := tone(sr, sr)
+	right := make([]float64, sr)
+	for i := range right {
+		right[i] = -0.5 * left[i]
+	}
+	_, dec := decode(t, encode(t, toPCM(left, right), sr, 2, 128))
+	if got := snr(left, dec[0]); got < 30 {
+		t.Errorf("left SNR %.1f dB, want >= 30", got)
+	}
+	if got := snr(right, dec[1]); got < 30 {
+		t.Errorf("right SNR %.1f dB, want >= 30", got)
+	}
+}
+
+func TestEncoder_NoiseAndSilence(t *testing.T) {
+	const sr = 24000
+	rng := rand.New(rand.NewSource(1))
+	src := make([]float64, sr)
+	for i := range src[:sr/2] {
+		src[i] = rng.Float64()*1.9 - 0.95 // full-scale noise, then silence
+	}
+	for _, br := range mp3.SupportedBitrates {
+		_, dec := decode(t, encode(t, toPCM(src), sr, 1, br))
+		if len(dec[0]) < sr+mp3.EncoderDelay {
+			t.Errorf("%d kbps: decoded %d samples", br, len(dec[0]))
+		}
+	}
+}
+
+func TestEncoder_Empty(t *testing.T) {
+	data := encode(t, nil, 24000, 1, mp3.DefaultBitrate)
+	if len(data) == 0 {
+		t.Fatal("expected padding frames for an empty stream")
+	}
+	_, dec := decode(t, data)
+	for _, v := range dec[0] {
+		if v != 0 {
+			t.Fatal("expected silence")
+		}
+	}
+}
+
+// frameWriter records each Write separately.
+type frameWriter struct{ writes [][]byte }
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestEncoder_OneFramePerWrite(t *testing.T) {
+	for _, rate := range []int{24000, 44100} {
+		var w frameWriter
+		enc, err := mp3.NewEncoder(&w, rate, 1, mp3.DefaultBitrate)
+		if err != nil {
+			t.Fatalf("NewEncoder: %v", err)
+		}
+		samples := rate / 2
+		enc.Write(toPCM(tone(rate, samples)))
+		enc.Close()
+
+		frames := (samples + mp3.EncoderDelay + mp3.FrameSamples(rate) - 1) / mp3.FrameSamples(rate)
+		if len(w.writes) != frames {
+			t.Errorf("%d Hz: %d writes, want %d frames", rate, len(w.writes), frames)
+		}
+		// Each write is a whole frame that decodes on its own.
+		for i, f := range w.writes {
+			if f[0] != 0xff || f[1]&0xe0 != 0xe0 {
+				t.Fatalf("%d Hz: write %d does not start with a frame sync", rate, i)
+			}
+		}
+		if _, dec := decode(t, w.writes[len(w.writes)/2]); len(dec[0]) != mp3.FrameSamples(rate) {
+			t.Errorf("%d Hz: a single frame decoded to %d samples, want %d", rate, len(dec[0]), mp3.FrameSamples(rate))
+		}
+	}
+}
+
+func TestNewEncoder_Invalid(t *testing.T) {
+	cases := []struct {
+		name                       string
+		sampleRate, channels, rate int
+	}{
+		{"sample rate", 11025, 1, 64},
+		{"channels", 24000, 3, 64},
+		{"bitrate", 24000, 1, 65},
+		{"bitrate for MPEG-2", 24000, 1, 192},
+	}
+	for _, c := range cases {
+		if _, err := mp3.NewEncoder(io.Discard, c.sampleRate, c.channels, c.rate); err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+	}
+}
+
+func TestIsSupportedBitrate(t *testing.T) {
+	if !mp3.IsSupportedBitrate(mp3.DefaultBitrate) {
+		t.Error("default bitrate should be supported")
+	}
+	if mp3.IsSupportedBitrate(320) {
+		t.Error("320 kbps is not valid for MPEG-2 LSF")
+	}
+}
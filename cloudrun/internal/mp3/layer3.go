@@ -0,0 +1,325 @@
+package mp3
+
+import "math"
+
+var (
+	// analysisWindow is the ISO 11172-3 analysis window C, derived from the
+	// synthesis window (D = 32 * C).
+	analysisWindow [512]float64
+	// subbandCos[i][k] = cos((2i+1)(k-16)π/64) for the polyphase matrixing.
+	subbandCos [32][64]float64
+	// mdctCos[k][n] = sin(π/36(n+½)) * cos(π/72(2n+19)(2k+1)), including the
+	// sine window and the 1/9 normalisation that makes IMDCT(MDCT(x)) = x.
+	mdctCos [18][36]float64
+
+	aliasCs = [8]float64{0.857493, 0.881742, 0.949629, 0.983315, 0.995518, 0.999161, 0.999899, 0.999993}
+	aliasCa = [8]float64{-0.514496, -0.471732, -0.313377, -0.181913, -0.094574, -0.040966, -0.014199, -0.003700}
+
+	// regionSubdivision gives (region0_count, region1_count) keyed by the
+	// number of scalefactor bands the big_values region spans.
+	regionSubdivision = [23][2]int{
+		{0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 1}, {1, 1}, {1, 1},
+		{1, 2}, {2, 2}, {2, 3}, {2, 3}, {3, 4}, {3, 4}, {3, 4}, {4, 5},
+		{4, 5}, {4, 6}, {5, 6}, {5, 6}, {5, 7}, {6, 7}, {6, 7},
+	}
+)
+
+func init() {
+	for i := range analysisWindow {
+		analysisWindow[i] = float64(windowD[i]) / (65536 * 32)
+	}
+	for i := 0; i < 32; i++ {
+		for k := 0; k < 64; k++ {
+			subbandCos[i][k] = math.Cos(float64((2*i+1)*(k-16)) * math.Pi / 64)
+		}
+	}
+	for k := 0; k < 18; k++ {
+		for n := 0; n < 36; n++ {
+			win := math.Sin(math.Pi / 36 * (float64(n) + 0.5))
+			mdctCos[k][n] = win * math.Cos(math.Pi/72*float64((2*n+19)*(2*k+1))) / 9
+		}
+	}
+}
+
+// analyzeGranule runs 576 samples through the polyphase filterbank, the
+// MDCT and the alias-reduction butterflies, leaving the spectrum in st.xr.
+func (e *Encoder) analyzeGranule(st *channelState, samples []float64) {
+	st.prevSub = st.sub
+	var y [64]float64
+	for t := 0; t < 18; t++ {
+		copy(st.x[32:], st.x[:480])
+		for i := 0; i < 32; i++ {
+			st.x[31-i] = samples[t*32+i]
+		}
+		for i := range y {
+			y[i] = 0
+			for j := 0; j < 8; j++ {
+				y[i] += analysisWindow[i+64*j] * st.x[i+64*j]
+			}
+		}
+		for band := 0; band < 32; band++ {
+			s := 0.0
+			for k, c := range subbandCos[band] {
+				s += c * y[k]
+			}
+			// Frequency inversion: the decoder negates odd samples of odd
+			// subbands, so pre-compensate here.
+			if band&1 == 1 && t&1 == 1 {
+				s = -s
+			}
+			st.sub[band][t] = s
+		}
+	}
+
+	var z [36]float64
+	for band := 0; band < 32; band++ {
+		copy(z[:18], st.prevSub[band][:])
+		copy(z[18:], st.sub[band][:])
+		for k := 0; k < 18; k++ {
+			s := 0.0
+			for n, c := range mdctCos[k] {
+				s += c * z[n]
+			}
+			st.xr[band*18+k] = s
+		}
+	}
+
+	for sb := 1; sb < 32; sb++ {
+		for i := 0; i < 8; i++ {
+			lo, hi := &st.xr[18*sb-1-i], &st.xr[18*sb+i]
+			l, u := *lo, *hi
+			*lo = l*aliasCs[i] + u*aliasCa[i]
+			*hi = u*aliasCs[i] - l*aliasCa[i]
+		}
+	}
+}
+
+// quantize picks the smallest global gain whose Huffman-coded spectrum fits
+// in maxBits, leaving the quantized values in st.ix and the side info in gi.
+func (e *Encoder) quantize(st *channelState, gi *granuleInfo, maxBits int) {
+	if maxBits > 4095 {
+		maxBits = 4095
+	}
+	var mag [granuleSize]float64
+	peak := 0.0
+	for i, v := range st.xr {
+		mag[i] = math.Pow(math.Abs(v), 0.75)
+		peak = math.Max(peak, mag[i])
+	}
+	if peak == 0 {
+		*gi = granuleInfo{globalGain: 210}
+		st.ix = [granuleSize]int{}
+		return
+	}
+
+	try := func(gain int) bool {
+		step := math.Pow(2, -0.1875*float64(gain-210))
+		if peak*step+0.4054 > maxQuant {
+			return false
+		}
+		for i, m := range mag {
+			q := int(m*step + 0.4054)
+			if st.xr[i] < 0 {
+				q = -q
+			}
+			st.ix[i] = q
+		}
+		e.layout(st, gi)
+		gi.globalGain = gain
+		return gi.part23Length <= maxBits
+	}
+
+	lo, hi := 0, 255
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if try(mid) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	try(lo)
+}
+
+// layout partitions st.ix into big_values, count1 and zero regions, picks
+// Huffman tables and computes part2_3_length.
+func (e *Encoder) layout(st *channelState, gi *granuleInfo) {
+	ix := &st.ix
+	end := granuleSize
+	for end >= 2 && ix[end-1] == 0 && ix[end-2] == 0 {
+		end -= 2
+	}
+	count1 := 0
+	for end >= 4 && abs(ix[end-1]) <= 1 && abs(ix[end-2]) <= 1 && abs(ix[end-3]) <= 1 && abs(ix[end-4]) <= 1 {
+		end -= 4
+		count1++
+	}
+	gi.bigValues = end / 2
+	gi.count1 = count1
+
+	sfb := &sfbLong[e.lsf][e.srIndex]
+	gi.region0Count, gi.region1Count = 0, 0
+	gi.region1Start, gi.region2Start = end, end
+	if end > 0 {
+		n := 0
+		for sfb[n] < end {
+			n++
+		}
+		r0 := regionSubdivision[n][0]
+		for r0 > 0 && sfb[r0+1] > end {
+			r0--
+		}
+		r1 := regionSubdivision[n][1]
+		for r1 > 0 && sfb[r0+r1+2] > end {
+			r1--
+		}
+		gi.region0Count, gi.region1Count = r0, r1
+		gi.region1Start = min(sfb[r0+1], end)
+		gi.region2Start = min(sfb[r0+r1+2], end)
+	}
+
+	bits := 0
+	bounds := [4]int{0, gi.region1Start, gi.region2Start, end}
+	for r := 0; r < 3; r++ {
+		t, b := chooseTable(ix[bounds[r]:bounds[r+1]])
+		gi.tableSelect[r] = t
+		bits += b
+	}
+
+	var quadBits [2]int
+	for q := end; q < end+4*count1; q += 4 {
+		idx, signs := quadIndex(ix[q : q+4])
+		for t := range quadBits {
+			quadBits[t] += int(count1Tables[t].lens[idx]) + signs
+		}
+	}
+	gi.count1Table = 0
+	if quadBits[1] < quadBits[0] {
+		gi.count1Table = 1
+	}
+	gi.part23Length = bits + quadBits[gi.count1Table]
+}
+
+// chooseTable returns the cheapest Huffman table for coding vals in pairs.
+func chooseTable(vals []int) (table, bits int) {
+	peak := 0
+	for _, v := range vals {
+		peak = max(peak, abs(v))
+	}
+	if peak == 0 {
+		return 0, 0
+	}
+	table, bits = -1, math.MaxInt
+	for t := 1; t < len(huffTables); t++ {
+		h := &huffTables[t]
+		if h.codes == nil {
+			continue
+		}
+		if h.linbits == 0 && peak >= h.xlen || h.linbits > 0 && peak > 15+(1<<h.linbits-1) {
+			continue
+		}
+		if b := pairBits(h, vals); b < bits {
+			table, bits = t, b
+		}
+	}
+	return table, bits
+}
+
+func pairBits(h *huffTable, vals []int) int {
+	bits := 0
+	for i := 0; i+1 < len(vals); i += 2 {
+		x, y := abs(vals[i]), abs(vals[i+1])
+		if h.linbits > 0 {
+			if x >= 15 {
+				x, bits = 15, bits+h.linbits
+			}
+			if y >= 15 {
+				y, bits = 15, bits+h.linbits
+			}
+		}
+		bits += int(h.lens[x*h.xlen+y])
+		if x != 0 {
+			bits++
+		}
+		if y != 0 {
+			bits++
+		}
+	}
+	return bits
+}
+
+func quadIndex(q []int) (idx, signs int) {
+	for _, v := range q {
+		idx = idx<<1 | abs(v)
+		signs += abs(v)
+	}
+	return idx, signs
+}
+
+// writeHuffman appends the coded spectrum described by gi to e.main.
+func (e *Encoder) writeHuffman(st *channelState, gi *granuleInfo) {
+	ix := &st.ix
+	end := gi.bigValues * 2
+	bounds := [4]int{0, gi.region1Start, gi.region2Start, end}
+	for r := 0; r < 3; r++ {
+		if gi.tableSelect[r] == 0 {
+			continue
+		}
+		h := &huffTables[gi.tableSelect[r]]
+		for i := bounds[r]; i+1 < bounds[r+1]; i += 2 {
+			e.writePair(h, ix[i], ix[i+1])
+		}
+	}
+	t := &count1Tables[gi.count1Table]
+	for q := end; q < end+4*gi.count1; q += 4 {
+		idx, _ := quadIndex(ix[q : q+4])
+		e.main.write(t.codes[idx], int(t.lens[idx]))
+		for _, v := range ix[q : q+4] {
+			if v != 0 {
+				e.main.write(sign(v), 1)
+			}
+		}
+	}
+}
+
+func (e *Encoder) writePair(h *huffTable, vx, vy int) {
+	x, y := abs(vx), abs(vy)
+	var linx, liny int
+	if h.linbits > 0 {
+		if x >= 15 {
+			linx, x = x-15, 15
+		}
+		if y >= 15 {
+			liny, y = y-15, 15
+		}
+	}
+	idx := x*h.xlen + y
+	m := &e.main
+	m.write(h.codes[idx], int(h.lens[idx]))
+	if x == 15 && h.linbits > 0 {
+		m.write(uint32(linx), h.linbits)
+	}
+	if x != 0 {
+		m.write(sign(vx), 1)
+	}
+	if y == 15 && h.linbits > 0 {
+		m.write(uint32(liny), h.linbits)
+	}
+	if y != 0 {
+		m.write(sign(vy), 1)
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func sign(v int) uint32 {
+	if v < 0 {
+		return 1
+	}
+	return 0
+}
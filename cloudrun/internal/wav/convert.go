@@ -0,0 +1,243 @@
+package wav
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// FormatMismatchError reports a file whose sample format differs from the
+// format the output was started with.
+type FormatMismatchError struct {
+	Index int // position of the offending file
+	Want  Format
+	Got   Format
+}
+
+func (e *FormatMismatchError) Error() string {
+	return fmt.Sprintf("wav: file %d format mismatch: got %s, want %s", e.Index, e.Got, e.Want)
+}
+
+func (f Format) String() string {
+	kind := "pcm"
+	if f.AudioFormat == FormatIEEEFloat {
+		kind = "float"
+	} else if f.AudioFormat != FormatPCM {
+		kind = fmt.Sprintf("format%d", f.AudioFormat)
+	}
+	return fmt.Sprintf("%d Hz/%dch/%d-bit %s", f.SampleRate, f.Channels, f.BitsPerSample, kind)
+}
+
+// Equal reports whether f and g describe the same sample layout.
+func (f Format) Equal(g Format) bool {
+	return f.AudioFormat == g.AudioFormat && f.Channels == g.Channels &&
+		f.SampleRate == g.SampleRate && f.BitsPerSample == g.BitsPerSample &&
+		f.BlockAlign == g.BlockAlign
+}
+
+// resampleTaps is the one-sided length of the resampling filter in input
+// samples at unity cutoff.
+const resampleTaps = 16
+
+// Convert converts sample data from one format to another: bit depth and
+// integer/float encoding, channel count (mono up-mix by duplication, down-mix
+// to mono by averaging) and sample rate (windowed-sinc interpolation).
+// Data already in the target format is returned unchanged.
+func Convert(pcm []byte, from, to Format) ([]byte, error) {
+	if from.Equal(to) {
+		return pcm, nil
+	}
+	if err := checkCodec(from); err != nil {
+		return nil, err
+	}
+	if err := checkCodec(to); err != nil {
+		return nil, err
+	}
+	if from.Channels != to.Channels && from.Channels != 1 && to.Channels != 1 {
+		return nil, fmt.Errorf("wav: cannot convert %d channels to %d", from.Channels, to.Channels)
+	}
+
+	chans := decode(pcm, from)
+	chans = remix(chans, to.Channels)
+	if from.SampleRate != to.SampleRate {
+		for c := range chans {
+			chans[c] = resample(chans[c], from.SampleRate, to.SampleRate)
+		}
+	}
+	return encode(chans, to), nil
+}
+
+func checkCodec(f Format) error {
+	switch {
+	case f.AudioFormat == FormatPCM && (f.BitsPerSample == 8 || f.BitsPerSample == 16 ||
+		f.BitsPerSample == 24 || f.BitsPerSample == 32):
+	case f.AudioFormat == FormatIEEEFloat && (f.BitsPerSample == 32 || f.BitsPerSample == 64):
+	default:
+		return fmt.Errorf("wav: unsupported sample format %s", f)
+	}
+	if f.Channels <= 0 || f.SampleRate <= 0 || f.BlockAlign < f.Channels*f.BitsPerSample/8 {
+		return fmt.Errorf("wav: invalid format %s", f)
+	}
+	return nil
+}
+
+// Decode splits interleaved samples into per-channel values in [-1, 1).
+// f must be one of the integer or float formats Convert accepts.
+func Decode(pcm []byte, f Format) ([][]float64, error) {
+	if err := checkCodec(f); err != nil {
+		return nil, err
+	}
+	return decode(pcm, f), nil
+}
+
+// Encode interleaves per-channel values into sample data in format f,
+// rounding and clipping integer samples. It is the inverse of Decode.
+func Encode(chans [][]float64, f Format) ([]byte, error) {
+	if err := checkCodec(f); err != nil {
+		return nil, err
+	}
+	if len(chans) != f.Channels {
+		return nil, fmt.Errorf("wav: %d channels of samples for %s", len(chans), f)
+	}
+	return encode(chans, f), nil
+}
+
+func decode(pcm []byte, f Format) [][]float64 {
+	frames := len(pcm) / f.BlockAlign
+	width := f.BitsPerSample / 8
+	chans := make([][]float64, f.Channels)
+	for c := range chans {
+		chans[c] = make([]float64, frames)
+	}
+	for i := 0; i < frames; i++ {
+		for c := range chans {
+			b := pcm[i*f.BlockAlign+c*width:]
+			chans[c][i] = decodeSample(b, f)
+		}
+	}
+	return chans
+}
+
+func decodeSample(b []byte, f Format) float64 {
+	if f.AudioFormat == FormatIEEEFloat {
+		if f.BitsPerSample == 64 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+	switch f.BitsPerSample {
+	case 8:
+		return float64(int(b[0])-128) / (1 << 7)
+	case 16:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+	case 24:
+		v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+		return float64(v) / (1 << 23)
+	default:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+	}
+}
+
+func encode(chans [][]float64, f Format) []byte {
+	frames := 0
+	if len(chans) > 0 {
+		frames = len(chans[0])
+	}
+	width := f.BitsPerSample / 8
+	out := make([]byte, frames*f.BlockAlign)
+	for i := 0; i < frames; i++ {
+		for c := range chans {
+			encodeSample(out[i*f.BlockAlign+c*width:], chans[c][i], f)
+		}
+	}
+	return out
+}
+
+func encodeSample(b []byte, v float64, f Format) {
+	if f.AudioFormat == FormatIEEEFloat {
+		if f.BitsPerSample == 64 {
+			binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+		} else {
+			binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+		}
+		return
+	}
+	scale := float64(int64(1) << (f.BitsPerSample - 1))
+	s := int64(math.Round(v * scale))
+	s = max(min(s, int64(scale)-1), -int64(scale))
+	switch f.BitsPerSample {
+	case 8:
+		b[0] = byte(s + 128)
+	case 16:
+		binary.LittleEndian.PutUint16(b, uint16(s))
+	case 24:
+		b[0], b[1], b[2] = byte(s), byte(s>>8), byte(s>>16)
+	default:
+		binary.LittleEndian.PutUint32(b, uint32(s))
+	}
+}
+
+// remix changes the channel count: mono is duplicated to every output
+// channel and any input is averaged down to mono.
+func remix(chans [][]float64, n int) [][]float64 {
+	switch {
+	case len(chans) == n:
+		return chans
+	case n == 1:
+		mono := make([]float64, len(chans[0]))
+		for _, ch := range chans {
+			for i, v := range ch {
+				mono[i] += v
+			}
+		}
+		for i := range mono {
+			mono[i] /= float64(len(chans))
+		}
+		return [][]float64{mono}
+	default:
+		out := make([][]float64, n)
+		for c := range out {
+			out[c] = append([]float64(nil), chans[0]...)
+		}
+		return out
+	}
+}
+
+// resample converts x from rate from to rate to with a Hann-windowed sinc
+// filter whose cutoff is the lower of the two Nyquist frequencies.
+func resample(x []float64, from, to int) []float64 {
+	n := int(int64(len(x)) * int64(to) / int64(from))
+	out := make([]float64, n)
+	ratio := float64(from) / float64(to)
+	cutoff := min(1, 1/ratio)
+	half := int(math.Ceil(resampleTaps / cutoff))
+	for i := range out {
+		t := float64(i) * ratio
+		center := int(t)
+		var sum float64
+		for k := center - half + 1; k <= center+half; k++ {
+			if k < 0 || k >= len(x) {
+				continue
+			}
+			d := t - float64(k)
+			sum += x[k] * cutoff * sinc(cutoff*d) * hann(d/float64(half))
+		}
+		out[i] = sum
+	}
+	return out
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// hann is the Hann window on [-1, 1].
+func hann(x float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	return 0.5 + 0.5*math.Cos(math.Pi*x)
+}
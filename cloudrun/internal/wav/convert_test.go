@@ -0,0 +1,165 @@
+package wav_test
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+var (
+	mono16   = wav.Format{AudioFormat: wav.FormatPCM, Channels: 1, SampleRate: 24000, BitsPerSample: 16, BlockAlign: 2}
+	stereo16 = wav.Format{AudioFormat: wav.FormatPCM, Channels: 2, SampleRate: 24000, BitsPerSample: 16, BlockAlign: 4}
+	mono24   = wav.Format{AudioFormat: wav.FormatPCM, Channels: 1, SampleRate: 24000, BitsPerSample: 24, BlockAlign: 3}
+	monoF32  = wav.Format{AudioFormat: wav.FormatIEEEFloat, Channels: 1, SampleRate: 24000, BitsPerSample: 32, BlockAlign: 4}
+)
+
+func int16s(pcm []byte) []int16 {
+	out := make([]int16, len(pcm)/2)
+	for i := range out {
+		out[i] = int16(binary.LittleEndian.Uint16(pcm[2*i:]))
+	}
+	return out
+}
+
+func fromInt16s(s []int16) []byte {
+	out := make([]byte, 2*len(s))
+	for i, v := range s {
+		binary.LittleEndian.PutUint16(out[2*i:], uint16(v))
+	}
+	return out
+}
+
+// sine returns n 16-bit samples of a tone at freq Hz.
+func sine(freq float64, rate, n int) []int16 {
+	out := make([]int16, n)
+	for i := range out {
+		out[i] = int16(math.Round(16000 * math.Sin(2*math.Pi*freq*float64(i)/float64(rate))))
+	}
+	return out
+}
+
+func TestConvert_SameFormatIsUnchanged(t *testing.T) {
+	pcm := fromInt16s([]int16{1, 2, 3})
+	out, err := wav.Convert(pcm, mono16, mono16)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if &out[0] != &pcm[0] {
+		t.Error("expected the input slice to be returned")
+	}
+}
+
+func TestConvert_BitDepthRoundTrip(t *testing.T) {
+	in := []int16{0, 1, -1, 12345, -32768, 32767}
+	pcm := fromInt16s(in)
+	for _, mid := range []wav.Format{mono24, monoF32} {
+		wide, err := wav.Convert(pcm, mono16, mid)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", mid, err)
+		}
+		if len(wide) != len(in)*mid.BlockAlign {
+			t.Fatalf("%s: len = %d, want %d", mid, len(wide), len(in)*mid.BlockAlign)
+		}
+		back, err := wav.Convert(wide, mid, mono16)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", mid, err)
+		}
+		for i, v := range int16s(back) {
+			if v != in[i] {
+				t.Errorf("%s: sample %d = %d, want %d", mid, i, v, in[i])
+			}
+		}
+	}
+	wide, _ := wav.Convert(pcm, mono16, mono24)
+	if got := wide[9:12]; got[0] != 0 || got[1] != 0x39 || got[2] != 0x30 {
+		t.Errorf("12345 as 24-bit = % x, want 00 39 30", got)
+	}
+}
+
+func TestConvert_Channels(t *testing.T) {
+	stereo := fromInt16s([]int16{100, 300, -200, -400})
+	mono, err := wav.Convert(stereo, stereo16, mono16)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := int16s(mono); len(got) != 2 || got[0] != 200 || got[1] != -300 {
+		t.Errorf("down-mix = %v, want [200 -300]", got)
+	}
+
+	up, err := wav.Convert(mono, mono16, stereo16)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := int16s(up); len(got) != 4 || got[0] != 200 || got[1] != 200 || got[2] != -300 || got[3] != -300 {
+		t.Errorf("up-mix = %v, want [200 200 -300 -300]", got)
+	}
+
+	quad := wav.Format{AudioFormat: wav.FormatPCM, Channels: 4, SampleRate: 24000, BitsPerSample: 16, BlockAlign: 8}
+	if _, err := wav.Convert(stereo, stereo16, quad); err == nil {
+		t.Error("expected error converting 2 to 4 channels")
+	}
+}
+
+func TestConvert_Resample(t *testing.T) {
+	for _, tc := range []struct{ from, to int }{
+		{22050, 24000},
+		{24000, 22050},
+		{48000, 16000},
+		{16000, 44100},
+	} {
+		from := mono16
+		from.SampleRate = tc.from
+		to := mono16
+		to.SampleRate = tc.to
+
+		out, err := wav.Convert(fromInt16s(sine(440, tc.from, tc.from)), from, to)
+		if err != nil {
+			t.Fatalf("%d->%d: unexpected error: %v", tc.from, tc.to, err)
+		}
+		got := int16s(out)
+		if len(got) != tc.to {
+			t.Fatalf("%d->%d: %d samples, want %d", tc.from, tc.to, len(got), tc.to)
+		}
+		// Away from the edges the output must be the same tone at the new rate.
+		want := sine(440, tc.to, tc.to)
+		var errPow, sigPow float64
+		for i := tc.to / 10; i < tc.to*9/10; i++ {
+			d := float64(got[i]) - float64(want[i])
+			errPow += d * d
+			sigPow += float64(want[i]) * float64(want[i])
+		}
+		if snr := 10 * math.Log10(sigPow/errPow); snr < 40 {
+			t.Errorf("%d->%d: SNR = %.1f dB, want >= 40", tc.from, tc.to, snr)
+		}
+	}
+}
+
+func TestConvert_UnsupportedFormat(t *testing.T) {
+	alaw := wav.Format{AudioFormat: 6, Channels: 1, SampleRate: 8000, BitsPerSample: 8, BlockAlign: 1}
+	if _, err := wav.Convert([]byte{1, 2}, alaw, mono16); err == nil {
+		t.Error("expected error for A-law input")
+	}
+}
+
+func TestDecodeEncode(t *testing.T) {
+	pcm := fromInt16s([]int16{100, -200, 300, -400})
+	chans, err := wav.Decode(pcm, stereo16)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(chans) != 2 || len(chans[0]) != 2 || chans[1][0] != -200.0/32768 {
+		t.Fatalf("unexpected channels %v", chans)
+	}
+	out, err := wav.Encode(chans, stereo16)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(out) != string(pcm) {
+		t.Errorf("round trip = %v, want %v", int16s(out), int16s(pcm))
+	}
+	if _, err := wav.Encode(chans, mono16); err == nil {
+		t.Error("expected error encoding 2 channels as mono")
+	}
+}
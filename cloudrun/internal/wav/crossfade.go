@@ -0,0 +1,71 @@
+package wav
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Crossfader joins consecutive pieces of sample data with a raised-cosine
+// crossfade. Only the last crossfade length of output is held back between
+// writes, so it can sit in a streaming pipeline.
+type Crossfader struct {
+	f      Format
+	frames int    // crossfade length in sample frames
+	tail   []byte // end of the output so far, not yet released
+}
+
+// NewCrossfader returns a Crossfader for data in format f overlapping d of
+// each piece with the end of the previous one.
+func NewCrossfader(f Format, d time.Duration) (*Crossfader, error) {
+	if err := checkCodec(f); err != nil {
+		return nil, err
+	}
+	if d < 0 {
+		return nil, fmt.Errorf("wav: negative crossfade %v", d)
+	}
+	return &Crossfader{f: f, frames: int(math.Round(d.Seconds() * float64(f.SampleRate)))}, nil
+}
+
+// Write adds the next piece and returns the output that is now final,
+// together with the number of bytes at the start of pcm that were
+// overlapped with the previous piece. The output timeline is therefore
+// that much shorter than the sum of the inputs. The overlap is shortened
+// when either side is shorter than the crossfade.
+func (c *Crossfader) Write(pcm []byte) (out []byte, overlap int64) {
+	ba := c.f.BlockAlign
+	pcm = pcm[:len(pcm)/ba*ba]
+	ov := min(c.frames, len(c.tail)/ba, len(pcm)/ba)
+
+	joined := make([]byte, 0, len(c.tail)+len(pcm)-ov*ba)
+	joined = append(joined, c.tail[:len(c.tail)-ov*ba]...)
+	if ov > 0 {
+		joined = append(joined, c.mix(c.tail[len(c.tail)-ov*ba:], pcm[:ov*ba])...)
+	}
+	joined = append(joined, pcm[ov*ba:]...)
+
+	hold := min(c.frames, len(joined)/ba) * ba
+	out = joined[:len(joined)-hold]
+	c.tail = append(c.tail[:0:0], joined[len(joined)-hold:]...)
+	return out, int64(ov * ba)
+}
+
+// Flush returns the held-back end of the output.
+func (c *Crossfader) Flush() []byte {
+	out := c.tail
+	c.tail = nil
+	return out
+}
+
+// mix fades out a while fading in b over their common length.
+func (c *Crossfader) mix(a, b []byte) []byte {
+	from, to := decode(a, c.f), decode(b, c.f)
+	n := len(from[0])
+	for i := range n {
+		in := 0.5 - 0.5*math.Cos(math.Pi*(float64(i)+0.5)/float64(n))
+		for ch := range from {
+			from[ch][i] = from[ch][i]*(1-in) + to[ch][i]*in
+		}
+	}
+	return encode(from, c.f)
+}
@@ -0,0 +1,88 @@
+package wav_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+func constant(v int16, n int) []int16 {
+	s := make([]int16, n)
+	for i := range s {
+		s[i] = v
+	}
+	return s
+}
+
+func TestCrossfader_Join(t *testing.T) {
+	f := mono16
+	f.SampleRate = 1000
+	fader, err := wav.NewCrossfader(f, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out1, ov1 := fader.Write(fromInt16s(constant(10000, 100)))
+	out2, ov2 := fader.Write(fromInt16s(constant(-10000, 100)))
+	out := append(append(out1, out2...), fader.Flush()...)
+
+	if ov1 != 0 || ov2 != 20 {
+		t.Errorf("overlaps = %d, %d bytes; want 0, 20", ov1, ov2)
+	}
+	if len(out1) != 90*2 {
+		t.Errorf("first write released %d bytes, want %d (tail held back)", len(out1), 90*2)
+	}
+	s := int16s(out)
+	if len(s) != 190 {
+		t.Fatalf("joined %d samples, want 190", len(s))
+	}
+	if s[89] != 10000 || s[100] != -10000 {
+		t.Errorf("samples around the fade = %d, %d; want untouched", s[89], s[100])
+	}
+	// The fade moves monotonically from one level to the other through zero.
+	for i := 91; i < 100; i++ {
+		if s[i] >= s[i-1] {
+			t.Errorf("sample %d = %d not below sample %d = %d", i, s[i], i-1, s[i-1])
+		}
+	}
+	if math.Abs(float64(s[94])+float64(s[95])) > 1 {
+		t.Errorf("midpoint samples %d, %d should be symmetric", s[94], s[95])
+	}
+}
+
+func TestCrossfader_ShortPieces(t *testing.T) {
+	f := mono16
+	f.SampleRate = 1000
+	fader, _ := wav.NewCrossfader(f, 10*time.Millisecond)
+
+	var total, overlap int64
+	var out []byte
+	for _, n := range []int{4, 30, 3, 0, 25} {
+		o, ov := fader.Write(fromInt16s(constant(1000, n)))
+		out = append(out, o...)
+		total += int64(2 * n)
+		overlap += ov
+	}
+	out = append(out, fader.Flush()...)
+	if int64(len(out)) != total-overlap {
+		t.Errorf("output %d bytes, want inputs %d minus overlaps %d", len(out), total, overlap)
+	}
+	for i, v := range int16s(out) {
+		if v != 1000 {
+			t.Fatalf("sample %d = %d; crossfading equal levels must keep the level", i, v)
+		}
+	}
+}
+
+func TestConcatenateCrossfade(t *testing.T) {
+	w := makeWAV(16000, 1, 16, 16000)
+	result, err := wav.ConcatenateCrossfade([][]byte{w, w, w}, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d := wav.Duration(result); math.Abs(d-2.96) > 1e-9 {
+		t.Errorf("duration = %f, want 2.96 (two 20 ms overlaps)", d)
+	}
+}
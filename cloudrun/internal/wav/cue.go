@@ -0,0 +1,140 @@
+package wav
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Cue is a labelled position in the sample data. It is written as a cue
+// point with a LIST/adtl label, which audio players and editors show as a
+// marker.
+type Cue struct {
+	Frame uint32 // sample frame offset from the start of the data
+	Label string
+}
+
+// CueChunks returns the "cue " chunk and the LIST chunk of type adtl
+// labelling each cue, ready to pass to HeaderWithChunks. Cue IDs are
+// assigned from 1 in order. It returns nil for no cues.
+func CueChunks(cues []Cue) []byte {
+	if len(cues) == 0 {
+		return nil
+	}
+	cue := binary.LittleEndian.AppendUint32(nil, uint32(len(cues)))
+	adtl := []byte("adtl")
+	for i, c := range cues {
+		id := uint32(i + 1)
+		cue = binary.LittleEndian.AppendUint32(cue, id)
+		cue = binary.LittleEndian.AppendUint32(cue, c.Frame) // play order position
+		cue = append(cue, "data"...)
+		cue = binary.LittleEndian.AppendUint32(cue, 0) // chunk start
+		cue = binary.LittleEndian.AppendUint32(cue, 0) // block start
+		cue = binary.LittleEndian.AppendUint32(cue, c.Frame)
+
+		labl := binary.LittleEndian.AppendUint32(nil, id)
+		labl = append(append(labl, c.Label...), 0)
+		adtl = appendChunk(adtl, "labl", labl)
+	}
+	return appendChunk(appendChunk(nil, "cue ", cue), "LIST", adtl)
+}
+
+// appendChunk appends a RIFF chunk with its pad byte to b.
+func appendChunk(b []byte, id string, body []byte) []byte {
+	b = append(b, id...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(body)))
+	b = append(b, body...)
+	if len(body)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// HeaderWithChunks returns a header like HeaderFor with chunks (complete,
+// padded RIFF chunks such as those from CueChunks) placed between the fmt
+// and data chunks.
+func HeaderWithChunks(f Format, dataLen int64, chunks []byte) []byte {
+	extra := int64(len(chunks))
+	var h []byte
+	if dataLen+extra > MaxDataSize {
+		h = RF64Header(f, dataLen)
+		binary.LittleEndian.PutUint64(h[20:28], uint64(RF64HeaderSize-8+extra+dataLen))
+	} else {
+		h = Header(f, dataLen)
+		binary.LittleEndian.PutUint32(h[4:8], uint32(HeaderSize-8+extra+dataLen))
+	}
+	data := h[len(h)-8:]
+	return append(append(h[:len(h)-8:len(h)-8], chunks...), data...)
+}
+
+// ResizeHeader rewrites a header built by Header or HeaderWithChunks for
+// dataLen bytes of sample data, keeping any extra chunks and switching to
+// RF64 when needed.
+func ResizeHeader(header []byte, dataLen int64) ([]byte, error) {
+	info, err := Parse(header)
+	if err != nil {
+		return nil, err
+	}
+	var extra []byte
+	walkChunks(header[:info.DataOffset-8], 12, func(id string, body []byte) {
+		if id != "fmt " && id != "ds64" {
+			extra = appendChunk(extra, id, body)
+		}
+	})
+	return HeaderWithChunks(info.Format, dataLen, extra), nil
+}
+
+// walkChunks calls fn for each complete chunk in data from pos on, stopping
+// at the first one that overruns data.
+func walkChunks(data []byte, pos int64, fn func(id string, body []byte)) {
+	for pos+8 <= int64(len(data)) {
+		id := string(data[pos : pos+4])
+		size := int64(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := pos + 8
+		if body+size > int64(len(data)) {
+			return
+		}
+		fn(id, data[body:body+size])
+		pos = body + size + size&1
+	}
+}
+
+// Cues returns the cue points of a WAV file with their adtl labels, in the
+// order of the cue chunk.
+func Cues(data []byte) ([]Cue, error) {
+	if _, err := Parse(data); err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	var cues []Cue
+	labels := map[uint32]string{}
+	var err error
+	walkChunks(data, 12, func(id string, body []byte) {
+		switch {
+		case id == "cue " && len(body) >= 4:
+			n := int(binary.LittleEndian.Uint32(body))
+			if len(body) < 4+24*n {
+				err = fmt.Errorf("wav: truncated cue chunk")
+				return
+			}
+			for i := range n {
+				p := body[4+24*i:]
+				ids = append(ids, binary.LittleEndian.Uint32(p))
+				cues = append(cues, Cue{Frame: binary.LittleEndian.Uint32(p[20:])})
+			}
+		case id == "LIST" && len(body) >= 4 && string(body[:4]) == "adtl":
+			walkChunks(body, 4, func(id string, sub []byte) {
+				if id == "labl" && len(sub) >= 4 {
+					text := sub[4:]
+					for len(text) > 0 && text[len(text)-1] == 0 {
+						text = text[:len(text)-1]
+					}
+					labels[binary.LittleEndian.Uint32(sub)] = string(text)
+				}
+			})
+		}
+	})
+	for i := range cues {
+		cues[i].Label = labels[ids[i]]
+	}
+	return cues, err
+}
@@ -0,0 +1,68 @@
+package wav_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+func TestCueChunks_RoundTrip(t *testing.T) {
+	cues := []wav.Cue{{Frame: 0, Label: "第一章"}, {Frame: 48000, Label: "Chapter 2"}, {Frame: 96001, Label: ""}}
+	pcm := make([]byte, 200000)
+	file := append(wav.HeaderWithChunks(mono16, int64(len(pcm)), wav.CueChunks(cues)), pcm...)
+
+	info, err := wav.Parse(file)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if info.DataLength != int64(len(pcm)) || !bytes.Equal(info.Data(file), pcm) {
+		t.Errorf("data chunk not found after the cue chunks")
+	}
+	if got := wav.Duration(file); got != float64(len(pcm)/2)/24000 {
+		t.Errorf("Duration = %f", got)
+	}
+
+	got, err := wav.Cues(file)
+	if err != nil {
+		t.Fatalf("Cues: %v", err)
+	}
+	if len(got) != len(cues) {
+		t.Fatalf("cues = %+v, want %+v", got, cues)
+	}
+	for i := range cues {
+		if got[i] != cues[i] {
+			t.Errorf("cue %d = %+v, want %+v", i, got[i], cues[i])
+		}
+	}
+}
+
+func TestResizeHeader_KeepsChunks(t *testing.T) {
+	cues := []wav.Cue{{Frame: 10, Label: "odd"}}
+	placeholder := wav.HeaderWithChunks(stereo16, 0, wav.CueChunks(cues))
+
+	h, err := wav.ResizeHeader(placeholder, 400)
+	if err != nil {
+		t.Fatalf("ResizeHeader: %v", err)
+	}
+	if want := wav.HeaderWithChunks(stereo16, 400, wav.CueChunks(cues)); !bytes.Equal(h, want) {
+		t.Errorf("resized header differs from one built for the final size")
+	}
+
+	// Past the 32-bit limit the header becomes RF64 and keeps the cues.
+	h, err = wav.ResizeHeader(placeholder, wav.MaxDataSize+2)
+	if err != nil {
+		t.Fatalf("ResizeHeader: %v", err)
+	}
+	if string(h[:4]) != "RF64" {
+		t.Fatalf("header starts with %q, want RF64", h[:4])
+	}
+	if got, _ := wav.Cues(h); len(got) != 1 || got[0] != cues[0] {
+		t.Errorf("cues = %+v, want %+v", got, cues)
+	}
+
+	// A plain header resizes to HeaderFor.
+	if h, _ := wav.ResizeHeader(wav.Header(mono16, 0), 1000); !bytes.Equal(h, wav.HeaderFor(mono16, 1000)) {
+		t.Error("plain header not resized to HeaderFor")
+	}
+}
@@ -0,0 +1,107 @@
+package wav
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// speechHold is how long the speech detector stays open after the last
+// sample above the threshold, so the background does not pump between
+// syllables.
+const speechHold = 150 * time.Millisecond
+
+// MixOptions configures a Mixer.
+type MixOptions struct {
+	GainDB      float64       // background level, 0 plays it as recorded
+	DuckDB      float64       // extra background attenuation while speech is present, <= 0
+	ThresholdDB float64       // speech level (dBFS) that counts as speech
+	Attack      time.Duration // time for the background to duck once speech starts
+	Release     time.Duration // time for it to recover after speech stops
+}
+
+// Mixer plays a looping background track under speech, ducking it while
+// the speech is above a threshold. Output has exactly the length of the
+// speech written, so anything timed against the speech is unchanged.
+type Mixer struct {
+	f    Format
+	bed  []byte // background in format f, whole frames
+	pos  int    // byte offset of the next background frame
+	opts MixOptions
+
+	threshold         float64
+	attack, release   float64 // one-pole smoothing coefficients per frame
+	hold, sinceSpeech int     // frames
+	duck              float64 // current ducking in dB, between opts.DuckDB and 0
+}
+
+// NewMixer returns a Mixer for speech in format f over background, which is
+// sample data in format bf and is converted to f.
+func NewMixer(f Format, background []byte, bf Format, opts MixOptions) (*Mixer, error) {
+	if err := checkCodec(f); err != nil {
+		return nil, err
+	}
+	bed, err := Convert(background, bf, f)
+	if err != nil {
+		return nil, err
+	}
+	bed = bed[:len(bed)/f.BlockAlign*f.BlockAlign]
+	if len(bed) == 0 {
+		return nil, errors.New("wav: empty background")
+	}
+	rate := float64(f.SampleRate)
+	return &Mixer{
+		sinceSpeech: math.MaxInt / 2, // no speech yet
+		f:           f,
+		bed:         bed,
+		opts:        opts,
+		threshold:   math.Pow(10, opts.ThresholdDB/20),
+		hold:        int(speechHold.Seconds() * rate),
+		attack:      smoothing(opts.Attack, rate),
+		release:     smoothing(opts.Release, rate),
+	}, nil
+}
+
+// smoothing returns the per-frame coefficient of a one-pole filter with
+// time constant d; zero means instant.
+func smoothing(d time.Duration, rate float64) float64 {
+	if d <= 0 {
+		return 1
+	}
+	return 1 - math.Exp(-1/(d.Seconds()*rate))
+}
+
+// Write mixes the next piece of speech with the background and returns it.
+func (m *Mixer) Write(pcm []byte) []byte {
+	ba := m.f.BlockAlign
+	pcm = pcm[:len(pcm)/ba*ba]
+	bed := make([]byte, 0, len(pcm))
+	for len(bed) < len(pcm) {
+		n := min(len(pcm)-len(bed), len(m.bed)-m.pos)
+		bed = append(bed, m.bed[m.pos:m.pos+n]...)
+		m.pos = (m.pos + n) % len(m.bed)
+	}
+
+	speech, music := decode(pcm, m.f), decode(bed, m.f)
+	gain := m.opts.GainDB
+	for i := range speech[0] {
+		m.sinceSpeech++
+		for c := range speech {
+			if math.Abs(speech[c][i]) >= m.threshold {
+				m.sinceSpeech = 0
+			}
+		}
+
+		target, coef := 0.0, m.release
+		if m.sinceSpeech <= m.hold {
+			target, coef = m.opts.DuckDB, m.attack
+		}
+		m.duck += (target - m.duck) * coef
+
+		g := math.Pow(10, (gain+m.duck)/20)
+		for c := range speech {
+			speech[c][i] += g * music[c][i]
+		}
+	}
+	return encode(speech, m.f)
+}
@@ -0,0 +1,81 @@
+package wav_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+func TestMixer_LoopsBackground(t *testing.T) {
+	m, err := wav.NewMixer(mono16, fromInt16s([]int16{1, 2, 3}), mono16, wav.MixOptions{ThresholdDB: -40})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []int16
+	for _, n := range []int{2, 5, 1} {
+		got = append(got, int16s(m.Write(make([]byte, 2*n)))...)
+	}
+	want := []int16{1, 2, 3, 1, 2, 3, 1, 2}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("output = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestMixer_DucksUnderSpeech(t *testing.T) {
+	f := mono16
+	f.SampleRate = 1000 // 1 frame per ms
+
+	// 500 ms silence, 500 ms loud speech, 1000 ms silence.
+	speech := make([]int16, 2000)
+	for i := 500; i < 1000; i++ {
+		speech[i] = int16(10000 * (i%2*2 - 1))
+	}
+	bed := fromInt16s([]int16{1000})
+	m, err := wav.NewMixer(f, bed, f, wav.MixOptions{
+		GainDB:      -6,
+		DuckDB:      -20,
+		ThresholdDB: -40,
+		Attack:      10 * time.Millisecond,
+		Release:     100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := int16s(m.Write(fromInt16s(speech)))
+	if len(out) != len(speech) {
+		t.Fatalf("%d samples, want %d", len(out), len(speech))
+	}
+
+	music := func(i int) float64 { return float64(out[i]) - float64(speech[i]) }
+	level := 1000 * math.Pow(10, -6.0/20)
+	if got := music(400); math.Abs(got-level) > 1 {
+		t.Errorf("background before speech = %.0f, want %.0f", got, level)
+	}
+	if got, want := music(900), level*math.Pow(10, -20.0/20); math.Abs(got-want) > 1 {
+		t.Errorf("background under speech = %.0f, want %.0f", got, want)
+	}
+	if got := music(1999); math.Abs(got-level) > 1 {
+		t.Errorf("background after speech = %.0f, want %.0f", got, level)
+	}
+}
+
+func TestMixer_ConvertsBackground(t *testing.T) {
+	// A stereo background at another rate is converted to the speech format.
+	bf := stereo16
+	bf.SampleRate = 48000
+	m, err := wav.NewMixer(mono16, make([]byte, 4*480), bf, wav.MixOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out := m.Write(make([]byte, 200)); len(out) != 200 {
+		t.Errorf("len = %d, want 200", len(out))
+	}
+
+	if _, err := wav.NewMixer(mono16, nil, mono16, wav.MixOptions{}); err == nil {
+		t.Error("expected error for empty background")
+	}
+}
@@ -0,0 +1,78 @@
+package wav
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Peaks builds a downsampled waveform for player UIs: the minimum and
+// maximum sample over every fixed number of frames, across all channels,
+// quantized to 8 bits. It marshals to the audiowaveform JSON layout, which
+// common waveform components read directly, and stays small (two bytes of
+// data per peak) however long the audio is.
+type Peaks struct {
+	f      Format
+	per    int // frames per peak
+	n      int // frames in the current, unfinished peak
+	lo, hi float64
+	data   []int8 // min, max pairs of finished peaks
+}
+
+// NewPeaks returns a Peaks for data in format f with one peak per interval.
+func NewPeaks(f Format, interval time.Duration) (*Peaks, error) {
+	if err := checkCodec(f); err != nil {
+		return nil, err
+	}
+	per := int(math.Round(interval.Seconds() * float64(f.SampleRate)))
+	if per < 1 {
+		return nil, fmt.Errorf("wav: peak interval %v shorter than a sample", interval)
+	}
+	return &Peaks{f: f, per: per}, nil
+}
+
+// Write adds the next piece of sample data.
+func (p *Peaks) Write(pcm []byte) {
+	chans := decode(pcm[:len(pcm)/p.f.BlockAlign*p.f.BlockAlign], p.f)
+	for i := range chans[0] {
+		for c := range chans {
+			p.lo, p.hi = min(p.lo, chans[c][i]), max(p.hi, chans[c][i])
+		}
+		if p.n++; p.n == p.per {
+			p.data = append(p.data, quantize8(p.lo), quantize8(p.hi))
+			p.n, p.lo, p.hi = 0, 0, 0
+		}
+	}
+}
+
+// Len returns the number of peaks, counting an unfinished last one.
+func (p *Peaks) Len() int {
+	n := len(p.data) / 2
+	if p.n > 0 {
+		n++
+	}
+	return n
+}
+
+func quantize8(v float64) int8 {
+	return int8(max(min(math.Round(v*128), 127), -128))
+}
+
+// MarshalJSON encodes the peaks written so far in audiowaveform's format.
+func (p *Peaks) MarshalJSON() ([]byte, error) {
+	data := p.data
+	if p.n > 0 {
+		data = append(data[:len(data):len(data)], quantize8(p.lo), quantize8(p.hi))
+	}
+	// []int8 so the data encodes as numbers rather than base64.
+	return json.Marshal(struct {
+		Version         int    `json:"version"`
+		Channels        int    `json:"channels"`
+		SampleRate      int    `json:"sample_rate"`
+		SamplesPerPixel int    `json:"samples_per_pixel"`
+		Bits            int    `json:"bits"`
+		Length          int    `json:"length"`
+		Data            []int8 `json:"data"`
+	}{2, 1, p.f.SampleRate, p.per, 8, len(data) / 2, data})
+}
@@ -0,0 +1,58 @@
+package wav_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+func TestPeaks(t *testing.T) {
+	f := stereo16
+	f.SampleRate = 1000
+	p, err := wav.NewPeaks(f, 2*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Five stereo frames: two full peaks and one partial. Either channel
+	// may hold the extreme.
+	p.Write(fromInt16s([]int16{16384, 0, 0, -8192}))
+	p.Write(fromInt16s([]int16{100, 200, 32767, -32768, -256, 0}))
+	if p.Len() != 3 {
+		t.Errorf("Len = %d, want 3", p.Len())
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got struct {
+		Version         int    `json:"version"`
+		Channels        int    `json:"channels"`
+		SampleRate      int    `json:"sample_rate"`
+		SamplesPerPixel int    `json:"samples_per_pixel"`
+		Bits            int    `json:"bits"`
+		Length          int    `json:"length"`
+		Data            []int8 `json:"data"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshal %s: %v", data, err)
+	}
+	if got.Version != 2 || got.Channels != 1 || got.SampleRate != 1000 || got.SamplesPerPixel != 2 || got.Bits != 8 || got.Length != 3 {
+		t.Errorf("unexpected header: %s", data)
+	}
+	want := []int8{-32, 64, -128, 127, -1, 0}
+	if len(got.Data) != len(want) {
+		t.Fatalf("data = %v, want %v", got.Data, want)
+	}
+	for i := range want {
+		if got.Data[i] != want[i] {
+			t.Fatalf("data = %v, want %v", got.Data, want)
+		}
+	}
+
+	if _, err := wav.NewPeaks(f, 0); err == nil {
+		t.Error("expected error for zero interval")
+	}
+}
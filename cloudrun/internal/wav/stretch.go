@@ -0,0 +1,212 @@
+package wav
+
+import (
+	"fmt"
+	"math"
+)
+
+// WSOLA parameters, in seconds.
+const (
+	stretchFrame     = 0.030 // analysis/synthesis frame length
+	stretchTolerance = 0.008 // how far a frame may move to stay in phase
+)
+
+// Stretcher changes tempo without changing pitch using WSOLA (waveform
+// similarity overlap-add): output frames are taken from the input at
+// speed times the output hop, each shifted by up to a few milliseconds to
+// the position that best continues the previous frame's waveform.
+//
+// It streams: Write returns the output that is final so far and holds back
+// only about one frame of input and output. After Flush the total output
+// is round(input / speed) sample frames, so times scale by exactly 1/speed.
+type Stretcher struct {
+	f     Format
+	speed float64
+	n     int // frame length in samples
+	hop   int // output hop, n/2
+	tol   int // search range either side of the nominal position
+	win   []float64
+
+	in      [][]float64 // per-channel input not yet discarded
+	mono    []float64   // channel sum of in, for the similarity search
+	inBase  int64       // input index of in[*][0]
+	inTotal int64
+
+	out     [][]float64 // overlap-add accumulator
+	outBase int64       // output index of out[*][0]
+
+	k       int64 // next frame
+	prevPos int64 // input position of the previous frame
+}
+
+// NewStretcher returns a Stretcher for data in format f that plays speed
+// times faster (speed > 1) or slower (speed < 1).
+func NewStretcher(f Format, speed float64) (*Stretcher, error) {
+	if err := checkCodec(f); err != nil {
+		return nil, err
+	}
+	if !(speed > 0) || math.IsInf(speed, 0) {
+		return nil, fmt.Errorf("wav: invalid speed %v", speed)
+	}
+	n := max(int(stretchFrame*float64(f.SampleRate))/2*2, 4)
+	s := &Stretcher{
+		f:     f,
+		speed: speed,
+		n:     n,
+		hop:   n / 2,
+		tol:   max(int(stretchTolerance*float64(f.SampleRate)), 1),
+		win:   make([]float64, n),
+		in:    make([][]float64, f.Channels),
+		out:   make([][]float64, f.Channels),
+	}
+	// Periodic Hann: overlapping at n/2 the windows sum to exactly one.
+	for i := range s.win {
+		s.win[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n))
+	}
+	return s, nil
+}
+
+// Write adds input and returns the output that is now final.
+func (s *Stretcher) Write(pcm []byte) []byte {
+	chans := decode(pcm, s.f)
+	for c := range chans {
+		s.in[c] = append(s.in[c], chans[c]...)
+	}
+	for i := range chans[0] {
+		var m float64
+		for c := range chans {
+			m += chans[c][i]
+		}
+		s.mono = append(s.mono, m)
+	}
+	s.inTotal += int64(len(chans[0]))
+	s.process(false)
+	// Never release past what the input so far is guaranteed to produce.
+	return s.release(min(s.k*int64(s.hop), int64(float64(s.inTotal)/s.speed)))
+}
+
+// Flush processes the remaining input and returns the rest of the output.
+func (s *Stretcher) Flush() []byte {
+	s.process(true)
+	return s.release(int64(math.Round(float64(s.inTotal) / s.speed)))
+}
+
+// nominal returns the unshifted input position of frame k.
+func (s *Stretcher) nominal(k int64) int64 {
+	return int64(math.Round(float64(k) * float64(s.hop) * s.speed))
+}
+
+func (s *Stretcher) process(final bool) {
+	for {
+		a := s.nominal(s.k)
+		if final {
+			if a >= s.inTotal {
+				return
+			}
+		} else if max(a+int64(s.tol), s.prevPos+int64(s.hop))+int64(s.n) > s.inTotal {
+			return
+		}
+
+		pos := a
+		if s.k > 0 {
+			pos = a + s.bestOffset(a, s.prevPos+int64(s.hop))
+		}
+
+		start := s.k*int64(s.hop) - s.outBase
+		for c := range s.out {
+			if need := int(start) + s.n; len(s.out[c]) < need {
+				s.out[c] = append(s.out[c], make([]float64, need-len(s.out[c]))...)
+			}
+			for i := range s.n {
+				w := s.win[i]
+				if s.k == 0 && i < s.hop {
+					w = 1 // nothing overlaps the first half of the first frame
+				}
+				s.out[c][int(start)+i] += w * s.sample(c, pos+int64(i))
+			}
+		}
+		s.prevPos = pos
+		s.k++
+
+		// Input before the next frame's search window and before the
+		// continuation of this one is no longer needed. When flushing,
+		// both can lie past the end of the input.
+		keep := min(s.nominal(s.k)-int64(s.tol), s.prevPos+int64(s.hop), s.inTotal)
+		if drop := int(keep - s.inBase); drop > 0 {
+			for c := range s.in {
+				s.in[c] = s.in[c][drop:]
+			}
+			s.mono = s.mono[drop:]
+			s.inBase = keep
+		}
+	}
+}
+
+// sample returns input sample i of channel c, zero past the end.
+func (s *Stretcher) sample(c int, i int64) float64 {
+	if j := i - s.inBase; j >= 0 && j < int64(len(s.in[c])) {
+		return s.in[c][j]
+	}
+	return 0
+}
+
+func (s *Stretcher) monoAt(i int64) float64 {
+	if j := i - s.inBase; j >= 0 && j < int64(len(s.mono)) {
+		return s.mono[j]
+	}
+	return 0
+}
+
+// bestOffset returns the shift in [-tol, tol] of the frame at a whose
+// waveform best matches the natural continuation at ref, searching
+// coarsely on a decimated grid and then refining around the best match.
+func (s *Stretcher) bestOffset(a, ref int64) int64 {
+	lo := max(-int64(s.tol), s.inBase-a)
+	hi := int64(s.tol)
+	score := func(d int64, step int) float64 {
+		var xy, xx float64
+		for i := 0; i < s.n; i += step {
+			x := s.monoAt(a + d + int64(i))
+			xy += x * s.monoAt(ref+int64(i))
+			xx += x * x
+		}
+		if xx == 0 {
+			return 0
+		}
+		return xy / math.Sqrt(xx)
+	}
+
+	best, bestScore := int64(0), math.Inf(-1)
+	for d := lo; d <= hi; d += 2 {
+		if sc := score(d, 4); sc > bestScore {
+			best, bestScore = d, sc
+		}
+	}
+	coarse := best
+	bestScore = math.Inf(-1)
+	for d := max(coarse-2, lo); d <= min(coarse+2, hi); d++ {
+		if sc := score(d, 1); sc > bestScore {
+			best, bestScore = d, sc
+		}
+	}
+	return best
+}
+
+// release encodes and returns output up to index end, padding with
+// silence if the accumulator is shorter.
+func (s *Stretcher) release(end int64) []byte {
+	n := int(end - s.outBase)
+	if n <= 0 {
+		return nil
+	}
+	chans := make([][]float64, len(s.out))
+	for c := range s.out {
+		if len(s.out[c]) < n {
+			s.out[c] = append(s.out[c], make([]float64, n-len(s.out[c]))...)
+		}
+		chans[c] = s.out[c][:n]
+		s.out[c] = append([]float64(nil), s.out[c][n:]...)
+	}
+	s.outBase = end
+	return encode(chans, s.f)
+}
@@ -0,0 +1,104 @@
+package wav_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+// stretch runs pcm through a Stretcher in pieces of the given sizes (in
+// frames), cycling through them.
+func stretch(t *testing.T, f wav.Format, speed float64, pcm []byte, pieces ...int) []byte {
+	t.Helper()
+	s, err := wav.NewStretcher(f, speed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var out []byte
+	for i := 0; len(pcm) > 0; i++ {
+		n := min(pieces[i%len(pieces)]*f.BlockAlign, len(pcm))
+		out = append(out, s.Write(pcm[:n])...)
+		pcm = pcm[n:]
+	}
+	return append(out, s.Flush()...)
+}
+
+// zeroCrossingRate estimates the frequency of a sine from its zero crossings.
+func zeroCrossingRate(s []int16, rate int) float64 {
+	crossings := 0
+	for i := 1; i < len(s); i++ {
+		if (s[i-1] < 0) != (s[i] < 0) {
+			crossings++
+		}
+	}
+	return float64(crossings) / 2 / (float64(len(s)) / float64(rate))
+}
+
+func TestStretcher_LengthAndPitch(t *testing.T) {
+	f := mono16
+	in := fromInt16s(sine(440, f.SampleRate, 2*f.SampleRate))
+	for _, speed := range []float64{0.5, 0.75, 1.25, 1.5, 2, 3} {
+		out := int16s(stretch(t, f, speed, in, 1000))
+		if want := int(math.Round(float64(2*f.SampleRate) / speed)); len(out) != want {
+			t.Errorf("speed %v: %d samples, want %d", speed, len(out), want)
+		}
+		// Skip the edges, where the last frames fade out.
+		mid := out[len(out)/10 : len(out)*9/10]
+		if hz := zeroCrossingRate(mid, f.SampleRate); math.Abs(hz-440) > 440*0.02 {
+			t.Errorf("speed %v: pitch %.1f Hz, want 440", speed, hz)
+		}
+	}
+}
+
+func TestStretcher_UnitSpeedReconstructs(t *testing.T) {
+	f := mono16
+	s := sine(300, f.SampleRate, f.SampleRate)
+	out := int16s(stretch(t, f, 1, fromInt16s(s), 777))
+	if len(out) != len(s) {
+		t.Fatalf("%d samples, want %d", len(out), len(s))
+	}
+	for i := 0; i < len(s)*9/10; i++ {
+		if d := int(out[i]) - int(s[i]); d < -1 || d > 1 {
+			t.Fatalf("sample %d = %d, want %d", i, out[i], s[i])
+		}
+	}
+}
+
+func TestStretcher_ChunkingDoesNotMatter(t *testing.T) {
+	f := stereo16
+	mono := sine(523, f.SampleRate, f.SampleRate)
+	in := make([]int16, 0, 2*len(mono))
+	for _, v := range mono {
+		in = append(in, v, -v/2)
+	}
+	pcm := fromInt16s(in)
+	whole := stretch(t, f, 1.5, pcm, len(in))
+	pieces := stretch(t, f, 1.5, pcm, 1, 300, 4097, 20)
+	if !bytes.Equal(whole, pieces) {
+		t.Error("output depends on how the input was split")
+	}
+}
+
+func TestStretcher_AnyInputLength(t *testing.T) {
+	// The last frames at Flush may start past the end of the input; every
+	// length must still come out at round(n / speed).
+	f := wav.Format{AudioFormat: wav.FormatPCM, Channels: 1, SampleRate: 16000, BitsPerSample: 16, BlockAlign: 2}
+	for _, speed := range []float64{0.5, 1.25, 3} {
+		for n := 16000; n < 16000+400; n += 7 {
+			out := stretch(t, f, speed, make([]byte, n*f.BlockAlign), 8000)
+			if want := int(math.Round(float64(n)/speed)) * f.BlockAlign; len(out) != want {
+				t.Errorf("speed %v, %d frames: %d bytes out, want %d", speed, n, len(out), want)
+			}
+		}
+	}
+}
+
+func TestNewStretcher_Invalid(t *testing.T) {
+	for _, speed := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if _, err := wav.NewStretcher(mono16, speed); err == nil {
+			t.Errorf("speed %v: expected error", speed)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package wav
+
+import (
+	"math"
+	"time"
+)
+
+// TrimOptions configures TrimSilence.
+type TrimOptions struct {
+	ThresholdDB float64       // frames with every sample below this level (dBFS) are silent
+	Keep        time.Duration // silence left at each end so word onsets and decays are not clipped
+}
+
+// TrimSilence removes leading and trailing silence from pcm, leaving
+// opts.Keep of it at each end. It returns the trimmed samples and the
+// number of bytes removed from the front, so callers can shift anything
+// timed against the original start. Audio that is silent throughout is cut
+// down to opts.Keep.
+func TrimSilence(pcm []byte, f Format, opts TrimOptions) ([]byte, int64, error) {
+	if err := checkCodec(f); err != nil {
+		return nil, 0, err
+	}
+	threshold := math.Pow(10, opts.ThresholdDB/20)
+	width := f.BitsPerSample / 8
+	frames := len(pcm) / f.BlockAlign
+	loud := func(i int) bool {
+		for c := 0; c < f.Channels; c++ {
+			if math.Abs(decodeSample(pcm[i*f.BlockAlign+c*width:], f)) >= threshold {
+				return true
+			}
+		}
+		return false
+	}
+
+	first := 0
+	for first < frames && !loud(first) {
+		first++
+	}
+	last := frames
+	for last > first && !loud(last-1) {
+		last--
+	}
+
+	keep := int(math.Round(opts.Keep.Seconds() * float64(f.SampleRate)))
+	start, end := max(first-keep, 0), min(last+keep, frames)
+	if first == frames {
+		start, end = 0, min(keep, frames)
+	}
+	return pcm[start*f.BlockAlign : end*f.BlockAlign], int64(start * f.BlockAlign), nil
+}
@@ -0,0 +1,66 @@
+package wav_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
+)
+
+func TestTrimSilence(t *testing.T) {
+	f := mono16
+	f.SampleRate = 1000 // 1 frame per ms keeps the arithmetic readable
+
+	// 300 ms near-silence (noise at -66 dBFS), 200 ms tone, 500 ms silence.
+	s := make([]int16, 1000)
+	for i := range 300 {
+		s[i] = int16(16 * (i%2*2 - 1))
+	}
+	for i := 300; i < 500; i++ {
+		s[i] = 8000
+	}
+	pcm := fromInt16s(s)
+
+	out, lead, err := wav.TrimSilence(pcm, f, wav.TrimOptions{ThresholdDB: -50, Keep: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lead != 280*2 {
+		t.Errorf("lead = %d bytes, want %d", lead, 280*2)
+	}
+	if len(out) != 240*2 {
+		t.Errorf("len = %d bytes, want %d", len(out), 240*2)
+	}
+	if got := int16s(out)[20]; got != 8000 {
+		t.Errorf("first kept tone sample = %d, want 8000", got)
+	}
+
+	// A lower threshold keeps the noise floor as signal.
+	if _, lead, _ := wav.TrimSilence(pcm, f, wav.TrimOptions{ThresholdDB: -70}); lead != 0 {
+		t.Errorf("lead with -70 dB threshold = %d, want 0", lead)
+	}
+}
+
+func TestTrimSilence_AllSilent(t *testing.T) {
+	f := mono16
+	f.SampleRate = 1000
+	out, lead, err := wav.TrimSilence(make([]byte, 2000), f, wav.TrimOptions{ThresholdDB: -50, Keep: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lead != 0 || len(out) != 100 {
+		t.Errorf("lead = %d len = %d, want 0 and 100", lead, len(out))
+	}
+}
+
+func TestTrimSilence_Stereo(t *testing.T) {
+	// Only the right channel has signal; the frame must still count as loud.
+	pcm := fromInt16s([]int16{0, 0, 0, 9000, 0, 0})
+	out, lead, err := wav.TrimSilence(pcm, stereo16, wav.TrimOptions{ThresholdDB: -40})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lead != 4 || len(out) != 4 {
+		t.Errorf("lead = %d len = %d, want 4 and 4", lead, len(out))
+	}
+}
@@ -2,56 +2,303 @@ package wav
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"time"
 )
 
-// Duration returns the playback duration of a WAV file in seconds by parsing
-// the PCM header fields. Returns 0 if the data is too short or invalid.
-func Duration(data []byte) float64 {
-	if len(data) < 44 {
+// Audio format codes found in the fmt chunk.
+const (
+	FormatPCM        = 1
+	FormatIEEEFloat  = 3
+	formatExtensible = 0xFFFE
+)
+
+// HeaderSize is the size of the canonical header written by Header.
+const HeaderSize = 44
+
+// RF64HeaderSize is the size of the header written by RF64Header.
+const RF64HeaderSize = 80
+
+// MaxDataSize is the largest data chunk a canonical header can describe:
+// the RIFF size field (36 + data length) must fit in 32 bits. Larger files
+// need an RF64 header.
+const MaxDataSize = math.MaxUint32 - (HeaderSize - 8)
+
+// sizeInDS64 is the 32-bit size placeholder meaning "see the ds64 chunk".
+const sizeInDS64 = math.MaxUint32
+
+var (
+	// ErrNotWAV is returned when data does not start with a RIFF/WAVE header.
+	ErrNotWAV = errors.New("wav: not a RIFF/WAVE file")
+	// ErrNoFormat is returned when no usable fmt chunk precedes the end of data.
+	ErrNoFormat = errors.New("wav: missing or invalid fmt chunk")
+	// ErrNoData is returned when the file has no data chunk.
+	ErrNoData = errors.New("wav: missing data chunk")
+)
+
+// Format describes the sample layout from the fmt chunk. For
+// WAVE_FORMAT_EXTENSIBLE files AudioFormat holds the sub-format code.
+type Format struct {
+	AudioFormat   uint16
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+	BlockAlign    int // bytes per sample frame across all channels
+}
+
+// Duration returns the playback time of n bytes of sample data in seconds.
+func (f Format) Duration(n int64) float64 {
+	if f.BlockAlign == 0 || f.SampleRate == 0 {
 		return 0
 	}
-	sampleRate    := binary.LittleEndian.Uint32(data[24:28])
-	dataSize      := binary.LittleEndian.Uint32(data[40:44])
-	bitsPerSample := binary.LittleEndian.Uint16(data[34:36])
-	numChannels   := binary.LittleEndian.Uint16(data[22:24])
-	if sampleRate == 0 || bitsPerSample == 0 || numChannels == 0 {
-		return 0
+	return float64(n/int64(f.BlockAlign)) / float64(f.SampleRate)
+}
+
+// Info is the result of Parse.
+type Info struct {
+	Format     Format
+	DataOffset int64 // byte offset of the data chunk payload
+	DataLength int64 // payload length in whole sample frames, clamped to the bytes present
+}
+
+// Data returns the sample data of the file Info was parsed from.
+func (i *Info) Data(data []byte) []byte {
+	return data[i.DataOffset : i.DataOffset+i.DataLength]
+}
+
+// Parse walks the RIFF chunks of a WAV file and returns its format and the
+// location of the sample data. Unknown chunks (LIST, fact, ...) are skipped,
+// odd-sized chunks honour the RIFF pad byte, and a data chunk whose declared
+// size overruns the file (e.g. a streamed header with a placeholder size) is
+// clamped to the bytes actually present. RF64 and BW64 files take the data
+// size from their ds64 chunk.
+func Parse(data []byte) (*Info, error) {
+	if len(data) < 12 || string(data[8:12]) != "WAVE" {
+		return nil, ErrNotWAV
+	}
+	var rf64 bool
+	switch string(data[0:4]) {
+	case "RIFF":
+	case "RF64", "BW64":
+		rf64 = true
+	default:
+		return nil, ErrNotWAV
+	}
+
+	var info Info
+	haveFormat, haveData := false, false
+	pos := int64(12)
+	end := int64(len(data))
+	ds64DataSize := int64(-1)
+	for pos+8 <= end && !(haveFormat && haveData) {
+		id := string(data[pos : pos+4])
+		size := int64(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := pos + 8
+
+		switch id {
+		case "ds64":
+			// riffSize(8) dataSize(8) sampleCount(8) tableLength(4) table
+			if rf64 && size >= 24 && body+24 <= end {
+				n := binary.LittleEndian.Uint64(data[body+8 : body+16])
+				ds64DataSize = int64(min(n, uint64(end)))
+			}
+		case "fmt ":
+			if size < 16 || body+16 > end {
+				return nil, ErrNoFormat
+			}
+			f, err := parseFormat(data[body:min(body+size, end)])
+			if err != nil {
+				return nil, err
+			}
+			info.Format = f
+			haveFormat = true
+		case "data":
+			if rf64 && size == sizeInDS64 && ds64DataSize >= 0 {
+				size = ds64DataSize
+			}
+			info.DataOffset = body
+			info.DataLength = min(size, end-body)
+			haveData = true
+		}
+		pos = body + size + size&1
+	}
+
+	if !haveFormat {
+		return nil, ErrNoFormat
+	}
+	if !haveData {
+		return nil, ErrNoData
+	}
+	info.DataLength -= info.DataLength % int64(info.Format.BlockAlign)
+	return &info, nil
+}
+
+func parseFormat(b []byte) (Format, error) {
+	f := Format{
+		AudioFormat:   binary.LittleEndian.Uint16(b[0:2]),
+		Channels:      int(binary.LittleEndian.Uint16(b[2:4])),
+		SampleRate:    int(binary.LittleEndian.Uint32(b[4:8])),
+		BlockAlign:    int(binary.LittleEndian.Uint16(b[12:14])),
+		BitsPerSample: int(binary.LittleEndian.Uint16(b[14:16])),
+	}
+	if f.AudioFormat == formatExtensible {
+		// cbSize(2) validBits(2) channelMask(4) then the sub-format GUID,
+		// whose first two bytes are the format code.
+		if len(b) < 40 {
+			return Format{}, fmt.Errorf("%w: truncated WAVE_FORMAT_EXTENSIBLE", ErrNoFormat)
+		}
+		f.AudioFormat = binary.LittleEndian.Uint16(b[24:26])
+		if f.AudioFormat == formatExtensible {
+			return Format{}, fmt.Errorf("%w: nested WAVE_FORMAT_EXTENSIBLE", ErrNoFormat)
+		}
+	}
+	if f.Channels == 0 || f.SampleRate == 0 || f.BitsPerSample == 0 {
+		return Format{}, fmt.Errorf("%w: channels=%d sampleRate=%d bits=%d",
+			ErrNoFormat, f.Channels, f.SampleRate, f.BitsPerSample)
+	}
+	if want := f.Channels * ((f.BitsPerSample + 7) / 8); f.BlockAlign < want {
+		f.BlockAlign = want
+	}
+	return f, nil
+}
+
+// Header returns a canonical 44-byte WAV header for dataLen bytes of samples.
+// dataLen must not exceed MaxDataSize; HeaderFor switches to RF64 beyond it.
+func Header(f Format, dataLen int64) []byte {
+	h := make([]byte, HeaderSize)
+	copy(h[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:8], uint32(36+dataLen))
+	copy(h[8:12], "WAVE")
+	copy(h[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:20], 16)
+	binary.LittleEndian.PutUint16(h[20:22], f.AudioFormat)
+	binary.LittleEndian.PutUint16(h[22:24], uint16(f.Channels))
+	binary.LittleEndian.PutUint32(h[24:28], uint32(f.SampleRate))
+	binary.LittleEndian.PutUint32(h[28:32], uint32(f.SampleRate*f.BlockAlign))
+	binary.LittleEndian.PutUint16(h[32:34], uint16(f.BlockAlign))
+	binary.LittleEndian.PutUint16(h[34:36], uint16(f.BitsPerSample))
+	copy(h[36:40], "data")
+	binary.LittleEndian.PutUint32(h[40:44], uint32(dataLen))
+	return h
+}
+
+// RF64Header returns an 80-byte RF64 header (EBU Tech 3306) for dataLen bytes
+// of samples, with the 64-bit sizes in a ds64 chunk.
+func RF64Header(f Format, dataLen int64) []byte {
+	h := make([]byte, RF64HeaderSize)
+	copy(h[0:4], "RF64")
+	binary.LittleEndian.PutUint32(h[4:8], sizeInDS64)
+	copy(h[8:12], "WAVE")
+	copy(h[12:16], "ds64")
+	binary.LittleEndian.PutUint32(h[16:20], 28)
+	binary.LittleEndian.PutUint64(h[20:28], uint64(RF64HeaderSize-8+dataLen))
+	binary.LittleEndian.PutUint64(h[28:36], uint64(dataLen))
+	if f.BlockAlign > 0 {
+		binary.LittleEndian.PutUint64(h[36:44], uint64(dataLen/int64(f.BlockAlign)))
+	}
+	// h[44:48]: table length 0
+	copy(h[48:72], Header(f, 0)[12:36])
+	copy(h[72:76], "data")
+	binary.LittleEndian.PutUint32(h[76:80], sizeInDS64)
+	return h
+}
+
+// HeaderFor returns a canonical header when dataLen fits in 32-bit sizes
+// and an RF64 header otherwise.
+func HeaderFor(f Format, dataLen int64) []byte {
+	if dataLen > MaxDataSize {
+		return RF64Header(f, dataLen)
+	}
+	return Header(f, dataLen)
+}
+
+// StreamingHeader returns a canonical header for a stream of unknown
+// length, with the RIFF and data sizes set to the 0xFFFFFFFF placeholder
+// that players read as "until end of stream".
+func StreamingHeader(f Format) []byte {
+	h := Header(f, 0)
+	binary.LittleEndian.PutUint32(h[4:8], math.MaxUint32)
+	binary.LittleEndian.PutUint32(h[40:44], math.MaxUint32)
+	return h
+}
+
+// Silence returns d of digital silence in format f, rounded to whole
+// sample frames.
+func Silence(f Format, d time.Duration) []byte {
+	frames := int64(math.Round(d.Seconds() * float64(f.SampleRate)))
+	b := make([]byte, max(frames, 0)*int64(f.BlockAlign))
+	if f.AudioFormat == FormatPCM && f.BitsPerSample == 8 {
+		for i := range b {
+			b[i] = 0x80 // unsigned midpoint
+		}
 	}
-	bytesPerSample := int(bitsPerSample/8) * int(numChannels)
-	if bytesPerSample == 0 {
+	return b
+}
+
+// Duration returns the playback duration of a WAV file in seconds.
+// Returns 0 if the data is not a valid WAV file.
+func Duration(data []byte) float64 {
+	info, err := Parse(data)
+	if err != nil {
 		return 0
 	}
-	return float64(int(dataSize)/bytesPerSample) / float64(sampleRate)
+	return info.Format.Duration(info.DataLength)
 }
 
-// Concatenate merges multiple PCM-WAV files into one.
-// The RIFF header of the first file is reused; only PCM data (after byte 44)
-// from all files is concatenated, and the RIFF/data sizes are updated.
+// Concatenate merges multiple WAV files into one with a canonical header
+// using the first file's format. Only the data chunk payloads are joined;
+// any other chunks are dropped. A file in a different format yields a
+// *FormatMismatchError; use Convert to bring it to the first file's format.
 func Concatenate(files [][]byte) ([]byte, error) {
+	return ConcatenateCrossfade(files, 0)
+}
+
+// ConcatenateCrossfade is Concatenate with a crossfade of d at each join
+// (see Crossfader); the result is shorter by the overlapped samples.
+func ConcatenateCrossfade(files [][]byte, d time.Duration) ([]byte, error) {
 	if len(files) == 0 {
 		return nil, fmt.Errorf("wav: no files to concatenate")
 	}
 	if len(files) == 1 {
 		return files[0], nil
 	}
-	if len(files[0]) < 44 {
-		return nil, fmt.Errorf("wav: first file has invalid header (len=%d)", len(files[0]))
-	}
 
-	var pcm []byte
-	for _, f := range files {
-		if len(f) > 44 {
-			pcm = append(pcm, f[44:]...)
+	infos := make([]*Info, len(files))
+	var total int64
+	for i, f := range files {
+		info, err := Parse(f)
+		if err != nil {
+			return nil, fmt.Errorf("wav: file %d: %w", i, err)
+		}
+		if i > 0 && !info.Format.Equal(infos[0].Format) {
+			return nil, &FormatMismatchError{Index: i, Want: infos[0].Format, Got: info.Format}
 		}
+		infos[i] = info
+		total += info.DataLength
 	}
 
-	result := make([]byte, 44+len(pcm))
-	copy(result[:44], files[0][:44])
-	copy(result[44:], pcm)
-
-	binary.LittleEndian.PutUint32(result[4:8], uint32(36+len(pcm)))
-	binary.LittleEndian.PutUint32(result[40:44], uint32(len(pcm)))
+	format := infos[0].Format
+	if d > 0 {
+		fader, err := NewCrossfader(format, d)
+		if err != nil {
+			return nil, err
+		}
+		var pcm []byte
+		for i, f := range files {
+			out, _ := fader.Write(infos[i].Data(f))
+			pcm = append(pcm, out...)
+		}
+		pcm = append(pcm, fader.Flush()...)
+		return append(HeaderFor(format, int64(len(pcm))), pcm...), nil
+	}
 
+	header := HeaderFor(format, total)
+	result := make([]byte, 0, int64(len(header))+total)
+	result = append(result, header...)
+	for i, f := range files {
+		result = append(result, infos[i].Data(f)...)
+	}
 	return result, nil
 }
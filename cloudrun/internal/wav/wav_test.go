@@ -2,8 +2,10 @@ package wav_test
 
 import (
 	"encoding/binary"
+	"errors"
 	"math"
 	"testing"
+	"time"
 
 	"github.com/entaku0818/voiceyourtext-cloudrun/internal/wav"
 )
@@ -126,3 +128,341 @@ func TestConcatenate_InvalidFirstHeader(t *testing.T) {
 		t.Errorf("single short file should be returned as-is, got error: %v", err)
 	}
 }
+
+// --- Parse ---
+
+// chunk encodes a RIFF chunk including the pad byte for odd sizes.
+func chunk(id string, body []byte) []byte {
+	c := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	c = append(c, body...)
+	if len(body)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+// riff wraps chunks in a RIFF/WAVE container.
+func riff(chunks ...[]byte) []byte {
+	var body []byte
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	out := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(4+len(body)))...)
+	out = append(out, "WAVE"...)
+	return append(out, body...)
+}
+
+func fmtBody(format uint16, channels uint16, rate uint32, bits uint16) []byte {
+	b := binary.LittleEndian.AppendUint16(nil, format)
+	b = binary.LittleEndian.AppendUint16(b, channels)
+	b = binary.LittleEndian.AppendUint32(b, rate)
+	b = binary.LittleEndian.AppendUint32(b, rate*uint32(channels)*uint32(bits/8))
+	b = binary.LittleEndian.AppendUint16(b, channels*bits/8)
+	return binary.LittleEndian.AppendUint16(b, bits)
+}
+
+func extensibleFmtBody(subFormat uint16, channels uint16, rate uint32, bits uint16) []byte {
+	b := fmtBody(0xFFFE, channels, rate, bits)
+	b = binary.LittleEndian.AppendUint16(b, 22)   // cbSize
+	b = binary.LittleEndian.AppendUint16(b, bits) // valid bits
+	b = binary.LittleEndian.AppendUint32(b, 0x4)  // channel mask
+	b = binary.LittleEndian.AppendUint16(b, subFormat)
+	return append(b, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71)
+}
+
+func TestParse_Canonical(t *testing.T) {
+	data := makeWAV(24000, 1, 16, 100)
+	info, err := wav.Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := wav.Format{AudioFormat: wav.FormatPCM, Channels: 1, SampleRate: 24000, BitsPerSample: 16, BlockAlign: 2}
+	if info.Format != want {
+		t.Errorf("Format = %+v, want %+v", info.Format, want)
+	}
+	if info.DataOffset != 44 || info.DataLength != 200 {
+		t.Errorf("data at %d+%d, want 44+200", info.DataOffset, info.DataLength)
+	}
+}
+
+func TestStreamingHeader_ParsesToBytesPresent(t *testing.T) {
+	f := wav.Format{AudioFormat: wav.FormatPCM, Channels: 1, SampleRate: 24000, BitsPerSample: 16, BlockAlign: 2}
+	data := append(wav.StreamingHeader(f), make([]byte, 301)...)
+	if got := binary.LittleEndian.Uint32(data[40:44]); got != 0xffffffff {
+		t.Errorf("data size = %#x, want placeholder", got)
+	}
+	info, err := wav.Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Format != f || info.DataLength != 300 {
+		t.Errorf("parsed %+v with %d data bytes, want %+v with 300", info.Format, info.DataLength, f)
+	}
+}
+
+func TestParse_SkipsListAndFactChunks(t *testing.T) {
+	pcm := make([]byte, 48000)
+	data := riff(
+		chunk("fmt ", fmtBody(1, 1, 24000, 16)),
+		chunk("LIST", append([]byte("INFOISFT"), binary.LittleEndian.AppendUint32(nil, 5)...)), // odd inner size, even chunk
+		chunk("fact", binary.LittleEndian.AppendUint32(nil, 24000)),
+		chunk("junk", []byte{1, 2, 3}), // odd size → pad byte
+		chunk("data", pcm),
+	)
+	info, err := wav.Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.DataLength != int64(len(pcm)) || info.DataOffset != int64(len(data)-len(pcm)) {
+		t.Errorf("data at %d+%d, want %d+%d", info.DataOffset, info.DataLength, len(data)-len(pcm), len(pcm))
+	}
+	if d := wav.Duration(data); math.Abs(d-1.0) > 0.001 {
+		t.Errorf("expected ~1.0s, got %f", d)
+	}
+}
+
+func TestParse_Extensible(t *testing.T) {
+	data := riff(chunk("fmt ", extensibleFmtBody(wav.FormatPCM, 1, 48000, 24)), chunk("data", make([]byte, 48000*3)))
+	info, err := wav.Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Format.AudioFormat != wav.FormatPCM || info.Format.BitsPerSample != 24 || info.Format.BlockAlign != 3 {
+		t.Errorf("unexpected format %+v", info.Format)
+	}
+	if d := wav.Duration(data); math.Abs(d-1.0) > 0.001 {
+		t.Errorf("expected ~1.0s, got %f", d)
+	}
+}
+
+func TestParse_OverlongDataIsClamped(t *testing.T) {
+	data := makeWAV(16000, 1, 16, 1000)
+	binary.LittleEndian.PutUint32(data[40:44], 0xFFFFFFFF) // streaming placeholder
+	data = append(data, 0xAB)                              // trailing half sample
+	info, err := wav.Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.DataLength != 2000 {
+		t.Errorf("DataLength = %d, want 2000", info.DataLength)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, wav.ErrNotWAV},
+		{"not riff", append([]byte("RIFX"), make([]byte, 40)...), wav.ErrNotWAV},
+		{"no fmt", riff(chunk("data", make([]byte, 4))), wav.ErrNoFormat},
+		{"short fmt", riff(chunk("fmt ", make([]byte, 8)), chunk("data", nil)), wav.ErrNoFormat},
+		{"zero channels", riff(chunk("fmt ", fmtBody(1, 0, 16000, 16)), chunk("data", nil)), wav.ErrNoFormat},
+		{"no data", riff(chunk("fmt ", fmtBody(1, 1, 16000, 16))), wav.ErrNoData},
+		{"truncated extensible", riff(chunk("fmt ", fmtBody(0xFFFE, 1, 16000, 16)), chunk("data", nil)), wav.ErrNoFormat},
+	}
+	for _, c := range cases {
+		if _, err := wav.Parse(c.data); !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+func TestHeader_RoundTrip(t *testing.T) {
+	f := wav.Format{AudioFormat: wav.FormatPCM, Channels: 2, SampleRate: 44100, BitsPerSample: 16, BlockAlign: 4}
+	data := append(wav.Header(f, 400), make([]byte, 400)...)
+	info, err := wav.Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Format != f || info.DataOffset != wav.HeaderSize || info.DataLength != 400 {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
+
+func TestRF64Header_RoundTrip(t *testing.T) {
+	f := wav.Format{AudioFormat: wav.FormatPCM, Channels: 1, SampleRate: 24000, BitsPerSample: 16, BlockAlign: 2}
+	h := wav.RF64Header(f, 6_000_000_000)
+	if len(h) != wav.RF64HeaderSize || string(h[0:4]) != "RF64" || string(h[12:16]) != "ds64" {
+		t.Fatalf("unexpected header % x", h[:16])
+	}
+	if got := binary.LittleEndian.Uint64(h[28:36]); got != 6_000_000_000 {
+		t.Errorf("ds64 data size = %d", got)
+	}
+	if got := binary.LittleEndian.Uint64(h[36:44]); got != 3_000_000_000 {
+		t.Errorf("ds64 sample count = %d", got)
+	}
+
+	// Parse with the real payload shorter than declared, as a streamed
+	// prefix would be; the data is clamped to the bytes present.
+	data := append(h, make([]byte, 100)...)
+	info, err := wav.Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Format != f || info.DataOffset != wav.RF64HeaderSize || info.DataLength != 100 {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
+
+func TestParse_RF64UsesDS64Size(t *testing.T) {
+	ds64 := make([]byte, 28)
+	binary.LittleEndian.PutUint64(ds64[8:16], 10)
+	data := riff(chunk("ds64", ds64), chunk("fmt ", fmtBody(1, 1, 16000, 16)), chunk("data", make([]byte, 20)))
+	copy(data[0:4], "BW64")
+	binary.LittleEndian.PutUint32(data[len(data)-24:], 0xFFFFFFFF) // data size placeholder
+
+	info, err := wav.Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.DataLength != 10 {
+		t.Errorf("DataLength = %d, want 10 from ds64", info.DataLength)
+	}
+}
+
+func TestHeaderFor_SwitchesToRF64(t *testing.T) {
+	f := wav.Format{AudioFormat: wav.FormatPCM, Channels: 2, SampleRate: 48000, BitsPerSample: 16, BlockAlign: 4}
+	if h := wav.HeaderFor(f, wav.MaxDataSize); string(h[0:4]) != "RIFF" || binary.LittleEndian.Uint32(h[4:8]) != 0xFFFFFFFF {
+		t.Errorf("at the limit: got % x, want a full canonical RIFF header", h[0:8])
+	}
+	if h := wav.HeaderFor(f, wav.MaxDataSize+1); string(h[0:4]) != "RF64" {
+		t.Errorf("past the limit: got %q, want RF64", h[0:4])
+	}
+}
+
+func TestSilence(t *testing.T) {
+	f := wav.Format{AudioFormat: wav.FormatPCM, Channels: 2, SampleRate: 24000, BitsPerSample: 16, BlockAlign: 4}
+	s := wav.Silence(f, 250*time.Millisecond)
+	if len(s) != 6000*4 {
+		t.Fatalf("len = %d, want %d", len(s), 6000*4)
+	}
+	for i, b := range s {
+		if b != 0 {
+			t.Fatalf("byte %d = %d, want 0", i, b)
+		}
+	}
+	if d := f.Duration(int64(len(s))); math.Abs(d-0.25) > 1e-9 {
+		t.Errorf("Duration = %f, want 0.25", d)
+	}
+
+	u8 := wav.Format{AudioFormat: wav.FormatPCM, Channels: 1, SampleRate: 8000, BitsPerSample: 8, BlockAlign: 1}
+	if s := wav.Silence(u8, time.Millisecond); len(s) != 8 || s[0] != 0x80 {
+		t.Errorf("8-bit silence = % x, want 8 bytes of 0x80", s)
+	}
+}
+
+func TestConcatenate_NonCanonicalHeaders(t *testing.T) {
+	w1 := riff(chunk("fmt ", fmtBody(1, 1, 16000, 16)), chunk("LIST", []byte("INFO")), chunk("data", make([]byte, 32000)))
+	w2 := makeWAV(16000, 1, 16, 16000)
+
+	result, err := wav.Concatenate([][]byte{w1, w2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != wav.HeaderSize+64000 {
+		t.Errorf("len = %d, want %d", len(result), wav.HeaderSize+64000)
+	}
+	if d := wav.Duration(result); math.Abs(d-2.0) > 0.001 {
+		t.Errorf("expected ~2.0s, got %f", d)
+	}
+}
+
+func TestConcatenate_InvalidLaterFile(t *testing.T) {
+	_, err := wav.Concatenate([][]byte{makeWAV(16000, 1, 16, 10), {0, 1, 2}})
+	if !errors.Is(err, wav.ErrNotWAV) {
+		t.Errorf("err = %v, want ErrNotWAV", err)
+	}
+}
+
+func TestConcatenate_FormatMismatch(t *testing.T) {
+	_, err := wav.Concatenate([][]byte{
+		makeWAV(24000, 1, 16, 10),
+		makeWAV(24000, 1, 16, 10),
+		makeWAV(22050, 1, 16, 10),
+	})
+	var mismatch *wav.FormatMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("err = %v, want *FormatMismatchError", err)
+	}
+	if mismatch.Index != 2 || mismatch.Want.SampleRate != 24000 || mismatch.Got.SampleRate != 22050 {
+		t.Errorf("unexpected mismatch %+v", mismatch)
+	}
+}
+
+// --- Fuzz ---
+
+func FuzzParse(f *testing.F) {
+	f.Add(makeWAV(24000, 1, 16, 10))
+	f.Add(append(wav.RF64Header(wav.Format{AudioFormat: wav.FormatPCM, Channels: 1, SampleRate: 8000, BitsPerSample: 16, BlockAlign: 2}, 8), make([]byte, 8)...))
+	f.Add(riff(chunk("fmt ", fmtBody(1, 2, 44100, 16)), chunk("LIST", []byte("INFOx")), chunk("data", make([]byte, 9))))
+	f.Add(riff(chunk("fmt ", extensibleFmtBody(3, 1, 48000, 32)), chunk("data", make([]byte, 8))))
+	f.Add(riff(chunk("data", make([]byte, 4)), chunk("fmt ", fmtBody(1, 1, 8000, 8))))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, err := wav.Parse(data)
+		if err != nil {
+			if wav.Duration(data) != 0 {
+				t.Error("Duration should be 0 for invalid data")
+			}
+			return
+		}
+		fm := info.Format
+		if fm.Channels <= 0 || fm.SampleRate <= 0 || fm.BlockAlign <= 0 {
+			t.Fatalf("invalid format accepted: %+v", fm)
+		}
+		if info.DataOffset < 12 || info.DataLength < 0 || info.DataOffset+info.DataLength > int64(len(data)) {
+			t.Fatalf("data range %d+%d outside %d bytes", info.DataOffset, info.DataLength, len(data))
+		}
+		if info.DataLength%int64(fm.BlockAlign) != 0 {
+			t.Fatalf("partial sample frame: %d %% %d", info.DataLength, fm.BlockAlign)
+		}
+
+		// Re-wrapping the samples in a canonical header must round-trip.
+		pcm := info.Data(data)
+		again, err := wav.Parse(append(wav.Header(fm, int64(len(pcm))), pcm...))
+		if err != nil {
+			t.Fatalf("canonical re-wrap failed: %v", err)
+		}
+		if again.DataLength != info.DataLength {
+			t.Fatalf("re-wrap DataLength %d, want %d", again.DataLength, info.DataLength)
+		}
+	})
+}
+
+func FuzzConcatenate(f *testing.F) {
+	f.Add(makeWAV(16000, 1, 16, 5), makeWAV(16000, 1, 16, 7))
+	f.Add(riff(chunk("fmt ", fmtBody(1, 1, 16000, 16)), chunk("junk", []byte{1}), chunk("data", make([]byte, 6))), makeWAV(16000, 1, 16, 1))
+	f.Add(makeWAV(16000, 1, 16, 4), makeWAV(16000, 1, 8, 4))
+	f.Fuzz(func(t *testing.T, a, b []byte) {
+		ia, errA := wav.Parse(a)
+		ib, errB := wav.Parse(b)
+		result, err := wav.Concatenate([][]byte{a, b})
+		if errA != nil || errB != nil {
+			if err == nil {
+				t.Fatal("expected error for invalid input")
+			}
+			return
+		}
+		if !ia.Format.Equal(ib.Format) {
+			var mismatch *wav.FormatMismatchError
+			if !errors.As(err, &mismatch) {
+				t.Fatalf("err = %v, want *FormatMismatchError for %+v and %+v", err, ia.Format, ib.Format)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		info, err := wav.Parse(result)
+		if err != nil {
+			t.Fatalf("result does not parse: %v", err)
+		}
+		// Lengths are measured in the first file's block size.
+		want := ia.DataLength + ib.DataLength
+		want -= want % int64(ia.Format.BlockAlign)
+		if info.DataLength != want {
+			t.Fatalf("DataLength = %d, want %d", info.DataLength, want)
+		}
+	})
+}